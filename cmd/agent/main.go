@@ -5,19 +5,37 @@ import (
 	"log"
 
 	"github.com/sreway/yametrics/internal/agent"
+	"github.com/sreway/yametrics/internal/config"
 )
 
-func init() {
-	flag.StringVar(&agent.ServerAddressDefault, "a", agent.ServerAddressDefault,
-		"server address: host:port")
-	flag.DurationVar(&agent.ReportIntervalDefault, "r", agent.ReportIntervalDefault, "report interval")
-	flag.DurationVar(&agent.PollIntervalDefault, "p", agent.PollIntervalDefault, "poll interval")
-	flag.StringVar(&agent.KeyDefault, "k", agent.KeyDefault, "encrypt key")
+func main() {
+	configFile := flag.String("c", "", "config file, JSON or YAML")
+	flag.String("a", agent.ServerAddressDefault, "server address: host:port")
+	flag.Duration("r", agent.ReportIntervalDefault, "report interval")
+	flag.Duration("p", agent.PollIntervalDefault, "poll interval")
+	flag.String("k", agent.KeyDefault, "encrypt key")
+	flag.String("l", agent.PullAddressDefault, "pull mode address: host:port")
 	flag.Parse()
-}
 
-func main() {
-	cli, err := agent.NewAgent()
+	// only the flags given on the command line override the config file and the environment
+	var opts []agent.OptionAgent
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "a":
+			opts = append(opts, agent.WithServerAddress(value))
+		case "r":
+			opts = append(opts, agent.WithReportInterval(value))
+		case "p":
+			opts = append(opts, agent.WithPollInterval(value))
+		case "k":
+			opts = append(opts, agent.WithKey(value))
+		case "l":
+			opts = append(opts, agent.WithPullAddress(value))
+		}
+	})
+
+	cli, err := agent.NewAgent(config.Path(*configFile), opts...)
 	if err != nil {
 		log.Fatalln(err)
 	}
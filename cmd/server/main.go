@@ -4,19 +4,48 @@ import (
 	"flag"
 	"log"
 
+	"github.com/sreway/yametrics/internal/config"
 	"github.com/sreway/yametrics/internal/server"
 )
 
 func main() {
-	flag.StringVar(&server.AddressDefault, "a", server.AddressDefault, "address: host:port")
-	flag.DurationVar(&server.StoreIntervalDefault, "i", server.StoreIntervalDefault, "store interval")
-	flag.BoolVar(&server.RestoreDefault, "r", server.RestoreDefault, "restoring metrics at startup")
-	flag.StringVar(&server.StoreFileDefault, "f", server.StoreFileDefault, "store file")
-	flag.StringVar(&server.KeyDefault, "k", server.KeyDefault, "encrypt key")
-	flag.StringVar(&server.DsnDefault, "d", server.DsnDefault, "PosgreSQL data source name")
+	configFile := flag.String("c", "", "config file, JSON or YAML")
+	flag.String("a", server.AddressDefault, "address: host:port")
+	flag.Duration("i", server.StoreIntervalDefault, "store interval")
+	flag.Bool("r", server.RestoreDefault, "restoring metrics at startup")
+	flag.String("f", server.StoreFileDefault, "store file")
+	flag.String("w", server.WalFileDefault, "write-ahead log file, disabled when empty")
+	flag.String("k", server.KeyDefault, "encrypt key")
+	flag.String("d", server.DsnDefault, "PostgreSQL data source name, bolt://<path> or sqlite://<path> of an embedded database")
+	flag.String("m", string(server.StorageModeDefault),
+		"what happens when the PostgreSQL database is unavailable: strict, fallback or buffered")
 	flag.Parse()
 
-	serv, err := server.NewServer()
+	// only the flags given on the command line override the config file and the environment
+	var opts []server.OptionServer
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "a":
+			opts = append(opts, server.WithAddr(value))
+		case "i":
+			opts = append(opts, server.WithStoreInterval(value))
+		case "r":
+			opts = append(opts, server.WithRestore(value))
+		case "f":
+			opts = append(opts, server.WithStoreFile(value))
+		case "w":
+			opts = append(opts, server.WithWalFile(value))
+		case "k":
+			opts = append(opts, server.WithKey(value))
+		case "d":
+			opts = append(opts, server.WithDsn(value))
+		case "m":
+			opts = append(opts, server.WithStorageMode(value))
+		}
+	})
+
+	serv, err := server.NewServer(config.Path(*configFile), opts...)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -13,33 +13,43 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-
 	"github.com/sreway/yametrics/internal/collector"
+	"github.com/sreway/yametrics/internal/logging"
 	"github.com/sreway/yametrics/internal/metrics"
+	"github.com/sreway/yametrics/internal/sink"
 )
 
 type Agent interface {
 	Start()
-	CollectRuntimeMetrics(ctx context.Context, wg *sync.WaitGroup)
+	Collect(ctx context.Context, wg *sync.WaitGroup, p collector.Plugin)
 	Send(ctx context.Context, wg *sync.WaitGroup)
 }
 
 type agent struct {
 	collector  collector.Collector
+	plugins    []collector.Plugin
+	sink       sink.Sink
 	httpClient http.Client
 	Config     *agentConfig
+	configFile string
+	opts       []OptionAgent
+	mu         sync.RWMutex
+	// workers are the collect and send loops, restarted when their configuration changes
+	workersCancel context.CancelFunc
+	workersWG     sync.WaitGroup
 }
 
-func (a *agent) CollectRuntimeMetrics(ctx context.Context, wg *sync.WaitGroup) {
+func (a *agent) Collect(ctx context.Context, wg *sync.WaitGroup, p collector.Plugin) {
 	defer wg.Done()
-	tick := time.NewTicker(a.Config.PollInterval)
+	tick := time.NewTicker(p.Interval())
 	defer tick.Stop()
 
 	for {
 		select {
 		case <-tick.C:
-			a.collector.CollectRuntimeMetrics()
+			if err := a.collector.Collect(ctx, p); err != nil {
+				logging.Errorf("agent collect error: %v", err)
+			}
 
 		case <-ctx.Done():
 			return
@@ -47,39 +57,21 @@ func (a *agent) CollectRuntimeMetrics(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
-func (a *agent) CollectUtilMetrics(ctx context.Context, wg *sync.WaitGroup) {
-	defer wg.Done()
-	cpuUsage := make(chan collector.Gauge)
-	stopCh := make(chan struct{})
-
-	go CollectCPUInfo(ctx, wg, cpuUsage, stopCh)
-
-	for {
-		select {
-		case cpuData := <-cpuUsage:
-			a.collector.CollectUtilMetrics(cpuData)
-		case <-ctx.Done():
-			close(stopCh)
-			return
-		}
-	}
-}
-
 func (a *agent) Send(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
-	tick := time.NewTicker(a.Config.ReportInterval)
+	tick := time.NewTicker(a.config().ReportInterval)
 	defer tick.Stop()
 
 	for {
 		select {
 		case <-tick.C:
 			exposeMetrics := a.collector.ExposeMetrics()
-			err := a.SendToSever(exposeMetrics, a.Config.Key != "")
+			err := a.SendToSever(exposeMetrics, a.config().Key != "")
 
 			if err != nil {
-				log.Printf("agent send error: %v", err)
+				logging.Errorf("agent send error: %v", err)
 			} else {
-				a.collector.ClearPollCounter()
+				a.collector.ResetCounters(exposeMetrics)
 			}
 
 		case <-ctx.Done():
@@ -92,23 +84,39 @@ func (a *agent) Start() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	systemSignals := make(chan os.Signal, 1)
-	signal.Notify(systemSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(systemSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 	exitChan := make(chan int)
 	wg := new(sync.WaitGroup)
-	wg.Add(4)
-	go a.CollectRuntimeMetrics(ctx, wg)
-	go a.CollectUtilMetrics(ctx, wg)
+	if err := logging.SetLevel(a.config().LogLevel); err != nil {
+		log.Fatalln(err)
+	}
+	a.startWorkers(ctx)
+
+	if a.config().PullAddress != "" {
+		if err := a.startPull(ctx, wg); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if a.sink != nil {
+		if err := a.sink.Start(ctx, wg); err != nil {
+			log.Fatalln(err)
+		}
+	}
 
-	go a.Send(ctx, wg)
 	go func() {
 		for {
 			s := <-systemSignals
 			switch s {
 			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
-				log.Println("signal triggered.")
+				logging.Infof("signal triggered.")
 				exitChan <- 0
+			case syscall.SIGHUP:
+				if err := a.reload(ctx); err != nil {
+					logging.Errorf("config reload: %v", err)
+				}
 			default:
-				log.Println("unknown signal.")
+				logging.Warnf("unknown signal.")
 				exitChan <- 1
 			}
 		}
@@ -116,12 +124,16 @@ func (a *agent) Start() {
 
 	exitCode := <-exitChan
 	cancel()
+	a.stopWorkers()
+	closePlugins(a.plugins)
 	wg.Wait()
 	os.Exit(exitCode)
 }
 
-func NewAgent(opts ...OptionAgent) (Agent, error) {
-	agentCfg, err := newAgentConfig()
+// NewAgent creates an agent configured from the config file, the environment and then
+// the options, which take precedence over the other sources.
+func NewAgent(configFile string, opts ...OptionAgent) (Agent, error) {
+	agentCfg, err := newAgentConfig(configFile)
 	if err != nil {
 		return nil, err
 	}
@@ -132,11 +144,30 @@ func NewAgent(opts ...OptionAgent) (Agent, error) {
 		}
 	}
 
-	return &agent{
+	if err = agentCfg.Valid(); err != nil {
+		return nil, fmt.Errorf("NewAgent: %w", err)
+	}
+	agentCfg.metricEndpoint = fmt.Sprintf("http://%s/updates/", agentCfg.ServerAddress)
+
+	plugins, err := collector.EnabledPlugins(&agentCfg.Collectors, agentCfg.PollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("NewAgent: %w", err)
+	}
+
+	a := &agent{
 		collector:  collector.NewCollector(),
+		plugins:    plugins,
 		Config:     agentCfg,
 		httpClient: http.Client{},
-	}, nil
+		configFile: configFile,
+		opts:       opts,
+	}
+
+	if agentCfg.Sink.Enabled() {
+		a.sink = sink.NewSink(agentCfg.Sink, a.collector.Update)
+	}
+
+	return a, nil
 }
 
 func (a *agent) SendToSever(m []metrics.Metric, withHash bool) error {
@@ -148,7 +179,7 @@ func (a *agent) SendToSever(m []metrics.Metric, withHash bool) error {
 
 	for index, metric := range m {
 		if withHash {
-			sign := metric.CalcHash(a.Config.Key)
+			sign := metric.CalcHash(a.config().Key)
 			m[index].Hash = sign
 		}
 	}
@@ -157,7 +188,7 @@ func (a *agent) SendToSever(m []metrics.Metric, withHash bool) error {
 		return fmt.Errorf("failed encode metric: %w", err)
 	}
 
-	request, err := http.NewRequest(http.MethodPost, a.Config.metricEndpoint, &body)
+	request, err := http.NewRequest(http.MethodPost, a.config().metricEndpoint, &body)
 	if err != nil {
 		return fmt.Errorf("failed create request: %w", err)
 	}
@@ -174,29 +205,3 @@ func (a *agent) SendToSever(m []metrics.Metric, withHash bool) error {
 
 	return nil
 }
-
-func getCPUInfo() collector.Gauge {
-	percent, _ := cpu.Percent(10*time.Second, false)
-	return collector.Gauge(percent[0])
-}
-
-func CollectCPUInfo(ctx context.Context, wg *sync.WaitGroup, dataCh chan collector.Gauge, stopCh chan struct{}) {
-	defer wg.Done()
-
-	for {
-		// try exit early
-		select {
-		case <-stopCh:
-			return
-		default:
-		}
-
-		select {
-		case <-ctx.Done():
-			return
-		case <-stopCh:
-			return
-		case dataCh <- getCPUInfo():
-		}
-	}
-}
@@ -8,15 +8,26 @@ import (
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/sreway/yametrics/internal/collector"
+	"github.com/sreway/yametrics/internal/config"
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/sink"
 )
 
 type (
 	agentConfig struct {
-		PollInterval   time.Duration `env:"POLL_INTERVAL"`
-		ReportInterval time.Duration `env:"REPORT_INTERVAL"`
-		ServerAddress  string        `env:"ADDRESS"`
+		PollInterval   time.Duration `env:"POLL_INTERVAL" yaml:"poll_interval"`
+		ReportInterval time.Duration `env:"REPORT_INTERVAL" yaml:"report_interval"`
+		ServerAddress  string        `env:"ADDRESS" yaml:"address"`
 		metricEndpoint string
-		Key            string `env:"KEY"`
+		Key            string `env:"KEY" yaml:"key"`
+		// PullAddress switches the agent to pull mode: instead of pushing to the server
+		// it serves the collected metrics on /metrics and /metrics/json.
+		PullAddress string           `env:"PULL_ADDRESS" yaml:"pull_address"`
+		Collectors  collector.Config `yaml:"collectors"`
+		Sink        sink.Config      `yaml:"sink"`
+		LogLevel    logging.Level    `env:"LOG_LEVEL" yaml:"log_level"`
 	}
 	OptionAgent func(*agentConfig) error
 )
@@ -26,34 +37,83 @@ var (
 	ReportIntervalDefault = 10 * time.Second
 	PollIntervalDefault   = 2 * time.Second
 	KeyDefault            string
-	ErrInvalidConfigOps   = errors.New("invalid configuration option")
-	ErrInvalidConfig      = errors.New("invalid configuration")
+	PullAddressDefault    string
+	LogLevelDefault       = logging.LevelInfo
+	CollectorsDefault     = []string{
+		collector.RuntimePluginName,
+		collector.MemoryPluginName,
+		collector.CPUPluginName,
+	}
+	ErrInvalidConfigOps = errors.New("invalid configuration option")
+	ErrInvalidConfig    = errors.New("invalid configuration")
 )
 
-func newAgentConfig() (*agentConfig, error) {
+// newAgentConfig applies the config file over the defaults and the environment over the file,
+// see the config package for the precedence rules.
+func newAgentConfig(configFile string) (*agentConfig, error) {
 	cfg := agentConfig{
 		ServerAddress:  ServerAddressDefault,
 		ReportInterval: ReportIntervalDefault,
 		PollInterval:   PollIntervalDefault,
 		Key:            KeyDefault,
+		PullAddress:    PullAddressDefault,
+		Collectors:     collector.NewConfig(),
+		LogLevel:       LogLevelDefault,
+	}
+	cfg.Collectors.Enabled = CollectorsDefault
+
+	if err := config.Load(configFile, &cfg); err != nil {
+		return nil, fmt.Errorf("newAgentConfig: %w", err)
 	}
 
 	if err := env.Parse(&cfg); err != nil {
 		return nil, fmt.Errorf("newAgentConfig: %w", err)
 	}
 
+	return &cfg, nil
+}
+
+// Valid checks the resulting configuration, errors name the invalid field by its config file key.
+func (cfg *agentConfig) Valid() error {
 	_, port, err := net.SplitHostPort(cfg.ServerAddress)
 	if err != nil {
-		return nil, fmt.Errorf("newAgentConfig: %w invalid address %s", ErrInvalidConfig, cfg.ServerAddress)
+		return fmt.Errorf("%w: address: invalid host:port %s", ErrInvalidConfig, cfg.ServerAddress)
 	}
 
 	_, err = strconv.Atoi(port)
 	if err != nil {
-		return nil, fmt.Errorf("newAgentConfig: %w invalid port %s", ErrInvalidConfigOps, cfg.ServerAddress)
+		return fmt.Errorf("%w: address: invalid port %s", ErrInvalidConfig, cfg.ServerAddress)
 	}
 
-	cfg.metricEndpoint = fmt.Sprintf("http://%s/updates/", cfg.ServerAddress)
-	return &cfg, nil
+	if cfg.PollInterval <= 0 {
+		return fmt.Errorf("%w: poll_interval: must be positive, got %s", ErrInvalidConfig, cfg.PollInterval)
+	}
+
+	if cfg.ReportInterval <= 0 {
+		return fmt.Errorf("%w: report_interval: must be positive, got %s", ErrInvalidConfig, cfg.ReportInterval)
+	}
+
+	if cfg.PullAddress != "" {
+		if _, _, err = net.SplitHostPort(cfg.PullAddress); err != nil {
+			return fmt.Errorf("%w: pull_address: invalid host:port %s", ErrInvalidConfig, cfg.PullAddress)
+		}
+	}
+
+	for name, interval := range cfg.Collectors.Intervals {
+		if interval <= 0 {
+			return fmt.Errorf("%w: collectors.intervals.%s: must be positive, got %s", ErrInvalidConfig, name, interval)
+		}
+	}
+
+	if err = cfg.Sink.Valid(); err != nil {
+		return fmt.Errorf("%w: sink: %v", ErrInvalidConfig, err)
+	}
+
+	if err = cfg.LogLevel.Valid(); err != nil {
+		return fmt.Errorf("%w: log_level: %v", ErrInvalidConfig, err)
+	}
+
+	return nil
 }
 
 func WithPollInterval(poolInterval string) OptionAgent {
@@ -79,3 +139,28 @@ func WithReportInterval(reportInterval string) OptionAgent {
 		return nil
 	}
 }
+
+func WithServerAddress(address string) OptionAgent {
+	return func(cfg *agentConfig) error {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("WithServerAddress: %w invalid address %s", ErrInvalidConfigOps, address)
+		}
+
+		cfg.ServerAddress = address
+		return nil
+	}
+}
+
+func WithKey(key string) OptionAgent {
+	return func(cfg *agentConfig) error {
+		cfg.Key = key
+		return nil
+	}
+}
+
+func WithPullAddress(address string) OptionAgent {
+	return func(cfg *agentConfig) error {
+		cfg.PullAddress = address
+		return nil
+	}
+}
@@ -2,9 +2,14 @@ package agent
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/collector"
 )
 
 func TestWithPollInterval(t *testing.T) {
@@ -35,7 +40,7 @@ func TestWithPollInterval(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg, err := newAgentConfig()
+			cfg, err := newAgentConfig("")
 			assert.NoError(t, err)
 			ops := WithPollInterval(tt.args.poolInterval)
 			err = ops(cfg)
@@ -76,7 +81,7 @@ func TestWithReportInterval(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg, err := newAgentConfig()
+			cfg, err := newAgentConfig("")
 			assert.NoError(t, err)
 			ops := WithReportInterval(tt.args.reportInterval)
 			err = ops(cfg)
@@ -161,6 +166,69 @@ func Test_newAgentConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+
+		{
+			name: "valid collector intervals",
+			args: args{
+				envName:  "COLLECTOR_INTERVALS",
+				envValue: "cpu:10s,memory:5s",
+			},
+			wantErr: false,
+		},
+
+		{
+			name: "invalid collector intervals",
+			args: args{
+				envName:  "COLLECTOR_INTERVALS",
+				envValue: "cpu",
+			},
+			wantErr: true,
+		},
+
+		{
+			name: "loopback statsd address",
+			args: args{
+				envName:  "STATSD_ADDRESS",
+				envValue: "127.0.0.1:8125",
+			},
+			wantErr: false,
+		},
+
+		{
+			name: "public statsd address",
+			args: args{
+				envName:  "STATSD_ADDRESS",
+				envValue: "0.0.0.0:8125",
+			},
+			wantErr: true,
+		},
+
+		{
+			name: "valid pull address",
+			args: args{
+				envName:  "PULL_ADDRESS",
+				envValue: "0.0.0.0:9100",
+			},
+			wantErr: false,
+		},
+
+		{
+			name: "invalid pull address",
+			args: args{
+				envName:  "PULL_ADDRESS",
+				envValue: "9100",
+			},
+			wantErr: true,
+		},
+
+		{
+			name: "invalid process targets",
+			args: args{
+				envName:  "PROCESS_TARGETS",
+				envValue: "nginx",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -171,7 +239,10 @@ func Test_newAgentConfig(t *testing.T) {
 				assert.NoError(t, err)
 			}()
 			assert.NoError(t, err)
-			_, err = newAgentConfig()
+			cfg, err := newAgentConfig("")
+			if err == nil {
+				err = cfg.Valid()
+			}
 			if !tt.wantErr {
 				assert.NoError(t, err)
 			} else {
@@ -180,3 +251,101 @@ func Test_newAgentConfig(t *testing.T) {
 		})
 	}
 }
+
+const testConfigFile = `
+address: 10.0.0.1:8080
+poll_interval: 5s
+report_interval: 20s
+collectors:
+  enabled: [cpu, process, exec]
+  intervals:
+    cpu: 10s
+  process:
+    targets:
+      - name: nginx
+        exe: nginx
+  exec:
+    scripts:
+      - name: queue
+        command: [/usr/local/bin/queue-len]
+        interval: 1m
+        format: prometheus
+`
+
+func Test_newAgentConfig_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfigFile), 0o644))
+	t.Setenv("REPORT_INTERVAL", "30s")
+
+	cfg, err := newAgentConfig(path)
+	require.NoError(t, err)
+	require.NoError(t, WithPollInterval("1s")(cfg))
+
+	assert.Equal(t, "10.0.0.1:8080", cfg.ServerAddress, "file overrides defaults")
+	assert.Equal(t, 30*time.Second, cfg.ReportInterval, "environment overrides file")
+	assert.Equal(t, time.Second, cfg.PollInterval, "flags override environment")
+	assert.Equal(t, []string{"cpu", "process", "exec"}, cfg.Collectors.Enabled)
+	assert.Equal(t, collector.Intervals{"cpu": 10 * time.Second}, cfg.Collectors.Intervals)
+	assert.Equal(t, collector.ProcessTargets{{Name: "nginx", Exe: "nginx"}}, cfg.Collectors.Process.Targets)
+	assert.Equal(t, collector.ExecScripts{{
+		Name:     "queue",
+		Command:  []string{"/usr/local/bin/queue-len"},
+		Interval: collector.Duration(time.Minute),
+		Format:   collector.ExecFormatProm,
+	}}, cfg.Collectors.Exec.Scripts)
+	assert.Equal(t, collector.ProcPathDefault, cfg.Collectors.ProcPath, "nested defaults are kept")
+}
+
+func TestNewAgent_ValidAfterOptions(t *testing.T) {
+	t.Setenv("ADDRESS", "localhost")
+
+	_, err := NewAgent("")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = NewAgent("", WithServerAddress("127.0.0.1:8080"))
+	assert.NoError(t, err, "the flag replaces the invalid environment")
+}
+
+func Test_newAgentConfig_FileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		field   string
+	}{
+		{
+			name:    "unknown field",
+			content: "pol_interval: 5s\n",
+			field:   "pol_interval",
+		},
+
+		{
+			name:    "invalid address",
+			content: "address: localhost\n",
+			field:   "address",
+		},
+
+		{
+			name:    "zero poll interval",
+			content: "poll_interval: 0s\n",
+			field:   "poll_interval",
+		},
+
+		{
+			name:    "negative collector interval",
+			content: "collectors:\n  intervals:\n    cpu: -1s\n",
+			field:   "collectors.intervals.cpu",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agent.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o644))
+
+			cfg, err := newAgentConfig(path)
+			if err == nil {
+				err = cfg.Valid()
+			}
+			assert.ErrorContains(t, err, tt.field)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// In pull mode the agent doesn't push, so the exposed counters are cumulative
+// since the agent start and the scraper computes the increments.
+func (a *agent) initPullRoutes(r *chi.Mux) {
+	r.Get("/metrics", a.PrometheusMetrics)
+	r.Get("/metrics/json", a.JSONMetrics)
+}
+
+func (a *agent) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	_ = r
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if err := metrics.WritePrometheusText(w, a.collector.ExposeMetrics()); err != nil {
+		logging.Errorf("Agent_PrometheusMetrics: %v", err)
+	}
+}
+
+func (a *agent) JSONMetrics(w http.ResponseWriter, r *http.Request) {
+	_ = r
+	w.Header().Set("Content-Type", "application/json")
+
+	exposeMetrics := a.collector.ExposeMetrics()
+	if key := a.config().Key; key != "" {
+		for i := range exposeMetrics {
+			exposeMetrics[i].Hash = exposeMetrics[i].CalcHash(key)
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(exposeMetrics); err != nil {
+		logging.Errorf("Agent_JSONMetrics: %v", err)
+	}
+}
+
+func (a *agent) startPull(ctx context.Context, wg *sync.WaitGroup) error {
+	listener, err := net.Listen("tcp", a.config().PullAddress)
+	if err != nil {
+		return fmt.Errorf("Agent_startPull: %w", err)
+	}
+
+	r := chi.NewRouter()
+	a.initPullRoutes(r)
+	httpServer := &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_ = httpServer.Shutdown(shutdownCtx)
+		}()
+
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("agent pull http error: %v", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/collector"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_agent_pullRoutes(t *testing.T) {
+	a := &agent{
+		collector: collector.NewCollector(),
+		Config:    &agentConfig{Key: "secret"},
+	}
+	a.collector.Update([]metrics.Metric{
+		collector.Counter(5).Metric("PollCount"),
+		collector.Gauge(17.5).Metric("QueueLength"),
+	})
+
+	r := chi.NewRouter()
+	a.initPullRoutes(r)
+
+	t.Run("prometheus", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+		assert.Equal(t, "# TYPE PollCount counter\nPollCount 5\n# TYPE QueueLength gauge\nQueueLength 17.5\n",
+			w.Body.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics/json", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var m []metrics.Metric
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+		require.Len(t, m, 2)
+		for _, item := range m {
+			assert.Equal(t, item.CalcHash("secret"), item.Hash)
+		}
+	})
+}
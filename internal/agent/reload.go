@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sreway/yametrics/internal/collector"
+	"github.com/sreway/yametrics/internal/config"
+	"github.com/sreway/yametrics/internal/logging"
+)
+
+var ErrRestartRequired = errors.New("configuration change requires restart")
+
+func (a *agent) config() *agentConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Config
+}
+
+// startWorkers starts a collect loop per plugin and, unless the agent is in pull mode, the send loop.
+func (a *agent) startWorkers(ctx context.Context) {
+	ctx, a.workersCancel = context.WithCancel(ctx)
+
+	a.workersWG.Add(len(a.plugins))
+	for _, p := range a.plugins {
+		go a.Collect(ctx, &a.workersWG, p)
+	}
+
+	if a.config().PullAddress == "" {
+		a.workersWG.Add(1)
+		go a.Send(ctx, &a.workersWG)
+	}
+}
+
+func (a *agent) stopWorkers() {
+	if a.workersCancel == nil {
+		return
+	}
+	a.workersCancel()
+	a.workersWG.Wait()
+}
+
+// restartRequired reports the config keys bound at startup, the listeners of pull mode and the sink.
+func restartRequired(key string) bool {
+	return key == "pull_address" || strings.HasPrefix(key, "sink.")
+}
+
+// reload re-reads the configuration the agent was started with and applies it live.
+// The command line options are applied again, so flags keep their precedence. A change
+// of a setting bound at startup rejects the whole reload and the current config stays.
+// Collectors are recreated when their settings change, so their baselines start over.
+func (a *agent) reload(ctx context.Context) error {
+	cfg, err := newAgentConfig(a.configFile)
+	if err != nil {
+		return fmt.Errorf("Agent_reload: %w", err)
+	}
+
+	for _, opt := range a.opts {
+		if err = opt(cfg); err != nil {
+			return fmt.Errorf("Agent_reload: %w", err)
+		}
+	}
+
+	if err = cfg.Valid(); err != nil {
+		return fmt.Errorf("Agent_reload: %w", err)
+	}
+	cfg.metricEndpoint = fmt.Sprintf("http://%s/updates/", cfg.ServerAddress)
+
+	changed := config.Diff(a.config(), cfg)
+	if len(changed) == 0 {
+		logging.Infof("config reloaded: no changes")
+		return nil
+	}
+
+	var rejected []string
+	restartCollectors, restartWorkers := false, false
+	for _, key := range changed {
+		switch {
+		case restartRequired(key):
+			rejected = append(rejected, key)
+		case key == "poll_interval", strings.HasPrefix(key, "collectors."):
+			restartCollectors = true
+		case key == "report_interval":
+			restartWorkers = true
+		}
+	}
+
+	if len(rejected) != 0 {
+		return fmt.Errorf("Agent_reload: %w: %s", ErrRestartRequired, strings.Join(rejected, ", "))
+	}
+
+	plugins := a.plugins
+	if restartCollectors {
+		if plugins, err = collector.EnabledPlugins(&cfg.Collectors, cfg.PollInterval); err != nil {
+			return fmt.Errorf("Agent_reload: %w", err)
+		}
+	}
+
+	if err = logging.SetLevel(cfg.LogLevel); err != nil {
+		if restartCollectors {
+			closePlugins(plugins)
+		}
+		return fmt.Errorf("Agent_reload: %w", err)
+	}
+
+	if restartCollectors || restartWorkers {
+		a.stopWorkers()
+	}
+
+	a.mu.Lock()
+	a.Config = cfg
+	a.mu.Unlock()
+	logging.Infof("config reloaded, changed: %s", strings.Join(changed, ", "))
+
+	if restartCollectors || restartWorkers {
+		a.dropDisabled(plugins)
+		if restartCollectors {
+			closePlugins(a.plugins)
+		}
+		a.plugins = plugins
+		a.startWorkers(ctx)
+	}
+
+	return nil
+}
+
+// dropDisabled removes the metrics of the running plugins missing from the new ones,
+// the workers are stopped so they can't be collected again.
+func (a *agent) dropDisabled(plugins []collector.Plugin) {
+	enabled := make(map[string]struct{}, len(plugins))
+	for _, p := range plugins {
+		enabled[p.Name()] = struct{}{}
+	}
+
+	for _, p := range a.plugins {
+		if _, exist := enabled[p.Name()]; !exist {
+			a.collector.Drop(p.Name())
+		}
+	}
+}
+
+// closePlugins closes plugins no longer polled, their workers are stopped or never started.
+func closePlugins(plugins []collector.Plugin) {
+	for _, p := range plugins {
+		if err := p.Close(); err != nil {
+			logging.Errorf("%s: %v", p.Name(), err)
+		}
+	}
+}
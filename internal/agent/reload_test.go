@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/collector"
+	"github.com/sreway/yametrics/internal/logging"
+)
+
+func exposed(a *agent, id string) bool {
+	for _, m := range a.collector.ExposeMetrics() {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// closedPlugin records whether the agent closed the plugin.
+type closedPlugin struct {
+	collector.Plugin
+	closed bool
+}
+
+func (p *closedPlugin) Close() error {
+	p.closed = true
+	return p.Plugin.Close()
+}
+
+func Test_agent_reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	writeConfig("poll_interval: 1h\nreport_interval: 1h\ncollectors:\n  enabled: [runtime]\n")
+
+	cli, err := NewAgent(path, WithKey("flag"))
+	require.NoError(t, err)
+	a := cli.(*agent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.startWorkers(ctx)
+	defer a.stopWorkers()
+
+	writeConfig("poll_interval: 30m\nreport_interval: 1h\naddress: 10.0.0.1:8080\n" +
+		"collectors:\n  enabled: [runtime, memory]\n")
+	require.NoError(t, a.reload(ctx))
+	assert.Equal(t, 30*time.Minute, a.config().PollInterval)
+	assert.Equal(t, "http://10.0.0.1:8080/updates/", a.config().metricEndpoint)
+	assert.Equal(t, "flag", a.config().Key, "flags keep their precedence")
+	require.Len(t, a.plugins, 2)
+	assert.Equal(t, collector.MemoryPluginName, a.plugins[1].Name())
+	assert.Equal(t, 30*time.Minute, a.plugins[1].Interval())
+
+	t.Cleanup(func() { _ = logging.SetLevel(logging.LevelInfo) })
+	writeConfig("poll_interval: 30m\nreport_interval: 1h\naddress: 10.0.0.1:8080\nlog_level: debug\n" +
+		"collectors:\n  enabled: [runtime, memory]\n")
+	require.NoError(t, a.reload(ctx))
+	assert.True(t, logging.Enabled(logging.LevelDebug), "the log level applies live")
+	assert.Len(t, a.plugins, 2, "the collectors are kept")
+
+	require.NoError(t, a.collector.Collect(ctx, a.plugins[0]))
+	require.NoError(t, a.collector.Collect(ctx, a.plugins[1]))
+	require.True(t, exposed(a, "TotalMemory"))
+	replaced := &closedPlugin{Plugin: a.plugins[1]}
+	a.plugins[1] = replaced
+	writeConfig("poll_interval: 30m\nreport_interval: 1h\naddress: 10.0.0.1:8080\nlog_level: debug\n" +
+		"collectors:\n  enabled: [runtime]\n")
+	require.NoError(t, a.reload(ctx))
+	assert.False(t, exposed(a, "TotalMemory"), "the metrics of a disabled collector are dropped")
+	assert.True(t, exposed(a, "PollCount"))
+	assert.True(t, replaced.closed, "the replaced collectors are closed")
+
+	writeConfig("poll_interval: 1m\npull_address: 127.0.0.1:9100\n")
+	assert.ErrorIs(t, a.reload(ctx), ErrRestartRequired)
+	assert.Equal(t, 30*time.Minute, a.config().PollInterval, "rejected reload keeps the current config")
+
+	writeConfig("collectors:\n  enabled: [unknown]\n")
+	assert.ErrorIs(t, a.reload(ctx), collector.ErrUnknownPlugin)
+	assert.Len(t, a.plugins, 1)
+}
@@ -0,0 +1,222 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const CgroupPluginName = "cgroup"
+
+var (
+	CgroupRootDefault = "/sys/fs/cgroup"
+	ErrCgroupNotFound = errors.New("cgroup v2 not found")
+)
+
+type (
+	CgroupConfig struct {
+		Root string `env:"ROOT" yaml:"root"`
+		// Path is the cgroup directory to report, the agent's own cgroup if empty.
+		Path string `env:"PATH" yaml:"path"`
+	}
+
+	cgroupStat struct {
+		time           time.Time
+		cpuUsage       uint64
+		cpuPeriods     uint64
+		cpuThrottled   uint64
+		throttledUsec  uint64
+		ioReadBytes    uint64
+		ioWriteBytes   uint64
+		ioReadOps      uint64
+		ioWriteOps     uint64
+		cpuStatPresent bool
+		ioStatPresent  bool
+	}
+
+	cgroupPlugin struct {
+		plugin
+		procPath string
+		root     string
+		path     string
+		now      func() time.Time
+		previous *cgroupStat
+	}
+)
+
+func init() {
+	Register(CgroupPluginName, newCgroupPlugin)
+}
+
+func newCgroupPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	root := cfg.Cgroup.Root
+	if root == "" {
+		root = CgroupRootDefault
+	}
+
+	return &cgroupPlugin{
+		plugin: plugin{
+			name:     CgroupPluginName,
+			interval: interval,
+		},
+		procPath: cfg.ProcPath,
+		root:     root,
+		path:     cfg.Cgroup.Path,
+		now:      time.Now,
+	}, nil
+}
+
+// Collect reports container scoped resources from the cgroup v2 interface files.
+// Cumulative CPU and I/O statistics are reported as counters of the increase since
+// the previous poll, controllers that are not enabled for the cgroup are skipped.
+func (p *cgroupPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	dir, err := p.cgroupDir()
+	if err != nil {
+		return nil, fmt.Errorf("cgroupPlugin_Collect: %w", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("cgroupPlugin_Collect: %w: %s", ErrCgroupNotFound, dir)
+	}
+
+	var collected []metrics.Metric
+
+	if value, ok := readCgroupValue(dir, "memory.current"); ok {
+		collected = append(collected, Gauge(value).Metric("CgroupMemoryUsage"))
+	}
+
+	// memory.max contains "max" when the cgroup has no limit
+	if value, ok := readCgroupValue(dir, "memory.max"); ok {
+		collected = append(collected, Gauge(value).Metric("CgroupMemoryLimit"))
+	}
+
+	if value, ok := readCgroupValue(dir, "pids.current"); ok {
+		collected = append(collected, Gauge(value).Metric("CgroupPids"))
+	}
+
+	current := p.readStat(dir)
+	if previous := p.previous; previous != nil {
+		collected = append(collected, p.deltas(previous, current)...)
+	}
+	p.previous = current
+
+	return collected, nil
+}
+
+func (p *cgroupPlugin) deltas(previous, current *cgroupStat) []metrics.Metric {
+	var collected []metrics.Metric
+
+	if previous.cpuStatPresent && current.cpuStatPresent {
+		elapsed := current.time.Sub(previous.time).Microseconds()
+		if elapsed > 0 && current.cpuUsage >= previous.cpuUsage {
+			usage := float64(current.cpuUsage-previous.cpuUsage) / float64(elapsed) * 100
+			collected = append(collected, Gauge(usage).Metric("CgroupCPUutilization"))
+		}
+
+		collected = append(collected,
+			counterDelta(previous.cpuPeriods, current.cpuPeriods).Metric("CgroupCPUPeriods"),
+			counterDelta(previous.cpuThrottled, current.cpuThrottled).Metric("CgroupCPUThrottledPeriods"),
+			counterDelta(previous.throttledUsec, current.throttledUsec).Metric("CgroupCPUThrottledUsec"),
+		)
+	}
+
+	if previous.ioStatPresent && current.ioStatPresent {
+		collected = append(collected,
+			counterDelta(previous.ioReadBytes, current.ioReadBytes).Metric("CgroupIOReadBytes"),
+			counterDelta(previous.ioWriteBytes, current.ioWriteBytes).Metric("CgroupIOWriteBytes"),
+			counterDelta(previous.ioReadOps, current.ioReadOps).Metric("CgroupIOReadOps"),
+			counterDelta(previous.ioWriteOps, current.ioWriteOps).Metric("CgroupIOWriteOps"),
+		)
+	}
+
+	return collected
+}
+
+func (p *cgroupPlugin) readStat(dir string) *cgroupStat {
+	stat := &cgroupStat{time: p.now()}
+
+	if lines, err := readFields(filepath.Join(dir, "cpu.stat")); err == nil {
+		stat.cpuStatPresent = true
+		for _, fields := range lines {
+			switch fields[0] {
+			case "usage_usec":
+				stat.cpuUsage = parseUint(fields, 1)
+			case "nr_periods":
+				stat.cpuPeriods = parseUint(fields, 1)
+			case "nr_throttled":
+				stat.cpuThrottled = parseUint(fields, 1)
+			case "throttled_usec":
+				stat.throttledUsec = parseUint(fields, 1)
+			}
+		}
+	}
+
+	// io.stat: "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0" per device
+	if lines, err := readFields(filepath.Join(dir, "io.stat")); err == nil {
+		stat.ioStatPresent = true
+		for _, fields := range lines {
+			for _, field := range fields[1:] {
+				key, value, _ := strings.Cut(field, "=")
+				n, _ := strconv.ParseUint(value, 10, 64)
+				switch key {
+				case "rbytes":
+					stat.ioReadBytes += n
+				case "wbytes":
+					stat.ioWriteBytes += n
+				case "rios":
+					stat.ioReadOps += n
+				case "wios":
+					stat.ioWriteOps += n
+				}
+			}
+		}
+	}
+
+	return stat
+}
+
+// cgroupDir returns the configured cgroup directory or resolves the agent's
+// own cgroup from the unified hierarchy entry ("0::/path") of /proc/self/cgroup.
+func (p *cgroupPlugin) cgroupDir() (string, error) {
+	if p.path != "" {
+		if filepath.IsAbs(p.path) {
+			return p.path, nil
+		}
+		return filepath.Join(p.root, p.path), nil
+	}
+
+	data, err := os.ReadFile(procFile(p.procPath, "self", "cgroup"))
+	if err != nil {
+		return "", fmt.Errorf("cgroupDir: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(p.root, strings.TrimPrefix(line, "0::")), nil
+		}
+	}
+
+	return "", fmt.Errorf("cgroupDir: %w", ErrCgroupNotFound)
+}
+
+func readCgroupValue(dir, name string) (uint64, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, false
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
+}
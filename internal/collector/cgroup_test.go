@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_cgroupPlugin_cgroupDir(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "own cgroup",
+			want: "testdata/cgroup/app.slice/app.service",
+		},
+
+		{
+			name: "relative path",
+			path: "app.slice",
+			want: "testdata/cgroup/app.slice",
+		},
+
+		{
+			name: "absolute path",
+			path: "/sys/fs/cgroup/system.slice",
+			want: "/sys/fs/cgroup/system.slice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.ProcPath = "testdata/proc"
+			cfg.Cgroup = CgroupConfig{Root: "testdata/cgroup", Path: tt.path}
+			p, err := newCgroupPlugin(time.Second, &cfg)
+			require.NoError(t, err)
+
+			dir, err := p.(*cgroupPlugin).cgroupDir()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, dir)
+		})
+	}
+}
+
+func Test_cgroupPlugin_Collect(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cgroup.controllers", "memory.current", "memory.max", "pids.current", "cpu.stat", "io.stat"} {
+		data, err := os.ReadFile(filepath.Join("testdata/cgroup/app.slice/app.service", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+	}
+
+	cfg := NewConfig()
+	cfg.Cgroup.Path = dir
+	p, err := newCgroupPlugin(time.Second, &cfg)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	cgroup := p.(*cgroupPlugin)
+	cgroup.now = func() time.Time {
+		return now
+	}
+
+	m, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, m, 3, "first poll reports only gauges")
+
+	limit, found := findMetric(m, metrics.GaugeStrName, "CgroupMemoryLimit")
+	require.True(t, found)
+	assert.Equal(t, float64(536870912), limit.Float64Value())
+
+	now = now.Add(2 * time.Second)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "memory.max"), []byte("max\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.stat"),
+		[]byte("usage_usec 2000000\nnr_periods 120\nnr_throttled 9\nthrottled_usec 50000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "io.stat"),
+		[]byte("8:0 rbytes=1049576 wbytes=2097152 rios=110 wios=200 dbytes=0 dios=0\n"+
+			"253:0 rbytes=2024 wbytes=4096 rios=2 wios=1 dbytes=0 dios=0\n"), 0o644))
+
+	m, err = p.Collect(context.Background())
+	require.NoError(t, err)
+
+	_, found = findMetric(m, metrics.GaugeStrName, "CgroupMemoryLimit")
+	assert.False(t, found, "unlimited memory is not reported")
+
+	utilization, found := findMetric(m, metrics.GaugeStrName, "CgroupCPUutilization")
+	require.True(t, found)
+	assert.InDelta(t, 50, utilization.Float64Value(), 0.001)
+
+	want := map[string]int64{
+		"CgroupCPUPeriods":          20,
+		"CgroupCPUThrottledPeriods": 4,
+		"CgroupCPUThrottledUsec":    30000,
+		"CgroupIOReadBytes":         2000,
+		"CgroupIOWriteBytes":        4096,
+		"CgroupIOReadOps":           11,
+		"CgroupIOWriteOps":          1,
+	}
+	for id, value := range want {
+		metric, found := findMetric(m, metrics.CounterStrName, id)
+		require.True(t, found, id)
+		assert.Equal(t, value, metric.Int64Value(), id)
+	}
+}
+
+func Test_cgroupPlugin_NotFound(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Cgroup.Path = t.TempDir()
+	p, err := newCgroupPlugin(time.Second, &cfg)
+	require.NoError(t, err)
+
+	_, err = p.Collect(context.Background())
+	assert.ErrorIs(t, err, ErrCgroupNotFound)
+}
@@ -1,6 +1,9 @@
 package collector
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/sreway/yametrics/internal/metrics"
@@ -8,37 +11,123 @@ import (
 
 type (
 	Collector interface {
-		CollectRuntimeMetrics()
-		CollectUtilMetrics(cpuUtilization Gauge)
+		Collect(ctx context.Context, p Plugin) error
+		Update(m []metrics.Metric)
 		ExposeMetrics() []metrics.Metric
-		ClearPollCounter()
+		ResetCounters(sent []metrics.Metric)
+		Drop(plugin string)
 	}
 	collector struct {
-		metrics *Metrics
+		mu       sync.RWMutex
+		gauges   map[string]float64
+		counters map[string]int64
+		// sources maps the collected metrics to the plugin reporting them
+		sources map[metricKey]string
+	}
+
+	metricKey struct {
+		mType string
+		id    string
 	}
 )
 
-func (c *collector) CollectRuntimeMetrics() {
-	c.metrics.CollectRuntimeMetrics()
+func (c *collector) Collect(ctx context.Context, p Plugin) error {
+	collected, err := p.Collect(ctx)
+	if err != nil {
+		return fmt.Errorf("Collector_Collect: [%s] %w", p.Name(), err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update(collected)
+	for _, item := range collected {
+		c.sources[metricKey{mType: item.MType, id: item.ID}] = p.Name()
+	}
+
+	return nil
 }
 
-func (c *collector) CollectUtilMetrics(cpuUtilization Gauge) {
-	c.metrics.CollectMemmoryMetrics()
-	c.metrics.SetCPUutilization(cpuUtilization)
+// Update merges metrics into the collector state: gauges overwrite the stored value,
+// counters are added to the value accumulated since the last report.
+func (c *collector) Update(m []metrics.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update(m)
 }
 
-func (c *collector) ClearPollCounter() {
-	c.metrics.ClearPollCounter()
+// update is Update, the caller holds the lock.
+func (c *collector) update(m []metrics.Metric) {
+	for _, item := range m {
+		switch item.MType {
+		case metrics.GaugeStrName:
+			c.gauges[item.ID] = item.Float64Value()
+		case metrics.CounterStrName:
+			c.counters[item.ID] += item.Int64Value()
+		}
+	}
 }
 
 func (c *collector) ExposeMetrics() []metrics.Metric {
-	return c.metrics.ExposeMetrics()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	exposeMetrics := make([]metrics.Metric, 0, len(c.gauges)+len(c.counters))
+
+	for id, value := range c.gauges {
+		exposeMetrics = append(exposeMetrics, Gauge(value).Metric(id))
+	}
+
+	for id, value := range c.counters {
+		exposeMetrics = append(exposeMetrics, Counter(value).Metric(id))
+	}
+
+	sort.Slice(exposeMetrics, func(i, j int) bool {
+		return exposeMetrics[i].ID < exposeMetrics[j].ID
+	})
+
+	return exposeMetrics
+}
+
+// ResetCounters subtracts the reported counter deltas, so increments collected
+// while the report was in flight are kept for the next one.
+func (c *collector) ResetCounters(sent []metrics.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, item := range sent {
+		if !item.IsCounter() {
+			continue
+		}
+		if _, exist := c.counters[item.ID]; exist {
+			c.counters[item.ID] -= item.Int64Value()
+		}
+	}
+}
+
+// Drop removes the metrics collected by the plugin, including the counter increments not
+// reported yet, so a disabled plugin stops being reported.
+func (c *collector) Drop(plugin string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, source := range c.sources {
+		if source != plugin {
+			continue
+		}
+
+		if key.mType == metrics.GaugeStrName {
+			delete(c.gauges, key.id)
+		} else {
+			delete(c.counters, key.id)
+		}
+		delete(c.sources, key)
+	}
 }
 
 func NewCollector() Collector {
 	return &collector{
-		&Metrics{
-			mu: sync.RWMutex{},
-		},
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+		sources:  make(map[metricKey]string),
 	}
 }
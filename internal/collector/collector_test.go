@@ -1,71 +1,128 @@
 package collector
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
 )
 
-func Test_collector_ExposeMetrics(t *testing.T) {
-	type fields struct {
-		metrics *Metrics
+var errTestPlugin = errors.New("test plugin error")
+
+type testPlugin struct {
+	plugin
+	metrics []metrics.Metric
+	err     error
+}
+
+func (p *testPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	return p.metrics, p.err
+}
+
+func newTestPlugin(m []metrics.Metric, err error) Plugin {
+	return &testPlugin{
+		plugin:  plugin{name: "test", interval: time.Second},
+		metrics: m,
+		err:     err,
 	}
+}
+
+func findMetric(m []metrics.Metric, metricType, metricID string) (metrics.Metric, bool) {
+	for _, item := range m {
+		if item.MType == metricType && item.ID == metricID {
+			return item, true
+		}
+	}
+	return metrics.Metric{}, false
+}
+
+func Test_collector_ExposeMetrics(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields fields
+		name       string
+		collected  []metrics.Metric
+		metricType string
+		metricID   string
 	}{
 		{
-			name: "expose counter metric",
-			fields: fields{
-				metrics: &Metrics{
-					PollCount: 10,
-				},
-			},
+			name:       "expose counter metric",
+			collected:  []metrics.Metric{Counter(10).Metric("PollCount")},
+			metricType: metrics.CounterStrName,
+			metricID:   "PollCount",
 		},
 
 		{
-			name: "expose gause metric",
-			fields: fields{
-				metrics: &Metrics{
-					OtherSys: 884128,
-				},
-			},
+			name:       "expose gause metric",
+			collected:  []metrics.Metric{Gauge(884128).Metric("OtherSys")},
+			metricType: metrics.GaugeStrName,
+			metricID:   "OtherSys",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := &collector{
-				metrics: tt.fields.metrics,
-			}
-			assert.NotZero(t, c.ExposeMetrics())
+			c := NewCollector()
+			err := c.Collect(context.Background(), newTestPlugin(tt.collected, nil))
+			require.NoError(t, err)
+			_, found := findMetric(c.ExposeMetrics(), tt.metricType, tt.metricID)
+			assert.True(t, found)
 		})
 	}
 }
 
-func Test_collector_CollectMetrics(t *testing.T) {
-	type fields struct {
-		metrics *Metrics
-	}
-	tests := []struct {
-		name   string
-		fields fields
-	}{
-		{
-			name: "collect metric",
-			fields: fields{
-				metrics: new(Metrics),
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			c := &collector{
-				metrics: tt.fields.metrics,
-			}
-			c.CollectRuntimeMetrics()
-			assert.NotZero(t, c.metrics.PollCount)
-			assert.NotZero(t, c.metrics.RandomValue)
-			assert.NotZero(t, c.metrics.OtherSys)
-		})
+func Test_collector_Collect(t *testing.T) {
+	c := NewCollector()
+	ctx := context.Background()
+	p := newTestPlugin([]metrics.Metric{
+		Counter(2).Metric("testCounter"),
+		Gauge(1.5).Metric("testGauge"),
+	}, nil)
+
+	require.NoError(t, c.Collect(ctx, p))
+	require.NoError(t, c.Collect(ctx, p))
+
+	counter, found := findMetric(c.ExposeMetrics(), metrics.CounterStrName, "testCounter")
+	require.True(t, found)
+	assert.Equal(t, int64(4), counter.Int64Value())
+
+	gauge, found := findMetric(c.ExposeMetrics(), metrics.GaugeStrName, "testGauge")
+	require.True(t, found)
+	assert.Equal(t, 1.5, gauge.Float64Value())
+
+	err := c.Collect(ctx, newTestPlugin(nil, errTestPlugin))
+	assert.ErrorIs(t, err, errTestPlugin)
+}
+
+func Test_collector_ResetCounters(t *testing.T) {
+	c := NewCollector()
+	ctx := context.Background()
+	p := newTestPlugin([]metrics.Metric{Counter(3).Metric("testCounter")}, nil)
+
+	require.NoError(t, c.Collect(ctx, p))
+	sent := c.ExposeMetrics()
+	require.NoError(t, c.Collect(ctx, p))
+	c.ResetCounters(sent)
+
+	counter, found := findMetric(c.ExposeMetrics(), metrics.CounterStrName, "testCounter")
+	require.True(t, found)
+	assert.Equal(t, int64(3), counter.Int64Value())
+}
+
+func Test_collector_Drop(t *testing.T) {
+	c := NewCollector()
+	ctx := context.Background()
+	kept := newTestPlugin([]metrics.Metric{Gauge(1).Metric("Kept")}, nil)
+	dropped := &testPlugin{
+		plugin:  plugin{name: "dropped", interval: time.Second},
+		metrics: []metrics.Metric{Gauge(2).Metric("Alloc"), Counter(3).Metric("Alloc")},
 	}
+	require.NoError(t, c.Collect(ctx, kept))
+	require.NoError(t, c.Collect(ctx, dropped))
+
+	c.Drop("dropped")
+	assert.Equal(t, []metrics.Metric{Gauge(1).Metric("Kept")}, c.ExposeMetrics())
 }
@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const CPUPluginName = "cpu"
+
+type (
+	// cpuTimes holds the jiffies counters of a single /proc/stat cpu line.
+	cpuTimes struct {
+		user    uint64
+		nice    uint64
+		system  uint64
+		idle    uint64
+		iowait  uint64
+		irq     uint64
+		softirq uint64
+		steal   uint64
+	}
+
+	cpuPlugin struct {
+		plugin
+		procPath string
+		previous map[string]cpuTimes
+	}
+)
+
+func init() {
+	Register(CPUPluginName, newCPUPlugin)
+}
+
+func newCPUPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	return &cpuPlugin{
+		plugin: plugin{
+			name:     CPUPluginName,
+			interval: interval,
+		},
+		procPath: cfg.ProcPath,
+	}, nil
+}
+
+func (t cpuTimes) total() uint64 {
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq + t.steal
+}
+
+// percent returns the share of value growth in the total time growth between two snapshots.
+func percent(previous, current uint64, totalDelta uint64) Gauge {
+	if totalDelta == 0 || current < previous {
+		return 0
+	}
+	return Gauge(float64(current-previous) / float64(totalDelta) * 100)
+}
+
+// Collect computes utilization from the difference of two consecutive /proc/stat
+// snapshots, so the first poll only records the baseline and load averages.
+func (p *cpuPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	current, err := p.readStat()
+	if err != nil {
+		return nil, fmt.Errorf("cpuPlugin_Collect: %w", err)
+	}
+
+	collected, err := p.loadAverage()
+	if err != nil {
+		return nil, fmt.Errorf("cpuPlugin_Collect: %w", err)
+	}
+
+	for name, times := range current {
+		previous, exist := p.previous[name]
+		if !exist || times.total() <= previous.total() {
+			continue
+		}
+
+		totalDelta := times.total() - previous.total()
+		idle := percent(previous.idle+previous.iowait, times.idle+times.iowait, totalDelta)
+
+		if name == "cpu" {
+			collected = append(collected,
+				(100 - idle).Metric("CPUutilization"),
+				percent(previous.user+previous.nice, times.user+times.nice, totalDelta).Metric("CPUUser"),
+				percent(previous.system+previous.irq+previous.softirq,
+					times.system+times.irq+times.softirq, totalDelta).Metric("CPUSystem"),
+				percent(previous.iowait, times.iowait, totalDelta).Metric("CPUIowait"),
+				percent(previous.steal, times.steal, totalDelta).Metric("CPUSteal"),
+			)
+			continue
+		}
+
+		// cores are reported 1-based, so cpu0 becomes CPUutilization1
+		core, err := strconv.Atoi(strings.TrimPrefix(name, "cpu"))
+		if err != nil {
+			continue
+		}
+		collected = append(collected, (100 - idle).Metric(fmt.Sprintf("CPUutilization%d", core+1)))
+	}
+
+	p.previous = current
+	return collected, nil
+}
+
+func (p *cpuPlugin) readStat() (map[string]cpuTimes, error) {
+	lines, err := readFields(procFile(p.procPath, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]cpuTimes)
+	for _, fields := range lines {
+		if !strings.HasPrefix(fields[0], "cpu") || len(fields) < 9 {
+			continue
+		}
+
+		stat[fields[0]] = cpuTimes{
+			user:    parseUint(fields, 1),
+			nice:    parseUint(fields, 2),
+			system:  parseUint(fields, 3),
+			idle:    parseUint(fields, 4),
+			iowait:  parseUint(fields, 5),
+			irq:     parseUint(fields, 6),
+			softirq: parseUint(fields, 7),
+			steal:   parseUint(fields, 8),
+		}
+	}
+
+	return stat, nil
+}
+
+func (p *cpuPlugin) loadAverage() ([]metrics.Metric, error) {
+	lines, err := readFields(procFile(p.procPath, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || len(lines[0]) < 3 {
+		return nil, fmt.Errorf("loadAverage: unexpected format")
+	}
+
+	collected := make([]metrics.Metric, 0, 3)
+	for i, name := range []string{"LoadAverage1", "LoadAverage5", "LoadAverage15"} {
+		value, err := ParseGause(lines[0][i])
+		if err != nil {
+			return nil, fmt.Errorf("loadAverage: %w", err)
+		}
+		collected = append(collected, value.Metric(name))
+	}
+
+	return collected, nil
+}
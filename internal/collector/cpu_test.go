@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_cpuPlugin_Collect(t *testing.T) {
+	procPath := t.TempDir()
+	for _, name := range []string{"stat", "loadavg"} {
+		data, err := os.ReadFile(filepath.Join("testdata/proc", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(procPath, name), data, 0o644))
+	}
+
+	cfg := NewConfig()
+	cfg.ProcPath = procPath
+	p, err := NewPlugin(CPUPluginName, time.Second, &cfg)
+	require.NoError(t, err)
+
+	m, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, m, 3, "first poll reports only load averages")
+
+	load, found := findMetric(m, metrics.GaugeStrName, "LoadAverage5")
+	require.True(t, found)
+	assert.Equal(t, 0.58, load.Float64Value())
+
+	// cpu0 spends 80 of 100 jiffies busy, cpu1 stays idle.
+	next := "cpu  1060 100 520 8100 220 50 50 100 0 0\n" +
+		"cpu0 560 50 270 4000 120 25 25 50 0 0\n" +
+		"cpu1 500 50 250 4100 100 25 25 50 0 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "stat"), []byte(next), 0o644))
+
+	m, err = p.Collect(context.Background())
+	require.NoError(t, err)
+
+	want := map[string]float64{
+		"CPUutilization":  40,
+		"CPUutilization1": 80,
+		"CPUutilization2": 0,
+		"CPUUser":         30,
+		"CPUSystem":       10,
+		"CPUIowait":       10,
+		"CPUSteal":        0,
+		"LoadAverage1":    0.52,
+	}
+	for id, value := range want {
+		metric, found := findMetric(m, metrics.GaugeStrName, id)
+		require.True(t, found, id)
+		assert.InDelta(t, value, metric.Float64Value(), 0.001, id)
+	}
+}
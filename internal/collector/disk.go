@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const (
+	DiskPluginName = "disk"
+	diskSectorSize = 512
+)
+
+var (
+	DiskFSTypeExcludeDefault = []string{
+		"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs", "devpts",
+		"devtmpfs", "fusectl", "hugetlbfs", "mqueue", "nsfs", "proc", "pstore", "rpc_pipefs",
+		"securityfs", "squashfs", "sysfs", "tmpfs", "tracefs",
+	}
+	DiskDeviceExcludeDefault = []string{"loop*", "ram*"}
+)
+
+type (
+	DiskConfig struct {
+		MountInclude  []string `env:"MOUNT_INCLUDE" envSeparator:"," yaml:"mount_include"`
+		MountExclude  []string `env:"MOUNT_EXCLUDE" envSeparator:"," yaml:"mount_exclude"`
+		DeviceInclude []string `env:"DEVICE_INCLUDE" envSeparator:"," yaml:"device_include"`
+		DeviceExclude []string `env:"DEVICE_EXCLUDE" envSeparator:"," yaml:"device_exclude"`
+		FSTypeExclude []string `env:"FS_TYPE_EXCLUDE" envSeparator:"," yaml:"fs_type_exclude"`
+	}
+
+	fsStat struct {
+		total     uint64
+		free      uint64
+		available uint64
+		files     uint64
+		filesFree uint64
+	}
+
+	diskIOStat struct {
+		readOps    uint64
+		readBytes  uint64
+		writeOps   uint64
+		writeBytes uint64
+	}
+
+	diskPlugin struct {
+		plugin
+		procPath string
+		mounts   filter
+		devices  filter
+		fsTypes  filter
+		statfs   func(path string) (fsStat, error)
+		previous map[string]diskIOStat
+	}
+)
+
+func init() {
+	Register(DiskPluginName, newDiskPlugin)
+}
+
+func newDiskPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	return &diskPlugin{
+		plugin: plugin{
+			name:     DiskPluginName,
+			interval: interval,
+		},
+		procPath: cfg.ProcPath,
+		mounts:   newFilter(cfg.Disk.MountInclude, cfg.Disk.MountExclude),
+		devices:  newFilter(cfg.Disk.DeviceInclude, cfg.Disk.DeviceExclude),
+		fsTypes:  newFilter(nil, cfg.Disk.FSTypeExclude),
+		statfs:   statfs,
+	}, nil
+}
+
+func (p *diskPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	usage, err := p.collectUsage()
+	if err != nil {
+		return nil, fmt.Errorf("diskPlugin_Collect: %w", err)
+	}
+
+	ioMetrics, err := p.collectIO()
+	if err != nil {
+		return nil, fmt.Errorf("diskPlugin_Collect: %w", err)
+	}
+
+	return append(usage, ioMetrics...), nil
+}
+
+func (p *diskPlugin) collectUsage() ([]metrics.Metric, error) {
+	lines, err := readFields(procFile(p.procPath, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+
+	type mountStat struct {
+		mountPoint string
+		stat       fsStat
+	}
+
+	var mounts []mountStat
+	seen := make(map[string]struct{})
+
+	for _, fields := range lines {
+		if len(fields) < 3 {
+			continue
+		}
+
+		mountPoint, fsType := unescapeOctal(fields[1]), fields[2]
+		if _, exist := seen[mountPoint]; exist || !p.fsTypes.Match(fsType) || !p.mounts.Match(mountPoint) {
+			continue
+		}
+		seen[mountPoint] = struct{}{}
+
+		stat, err := p.statfs(mountPoint)
+		if err != nil {
+			// mount points the agent can't access are skipped rather than failing the whole poll
+			continue
+		}
+		mounts = append(mounts, mountStat{mountPoint: mountPoint, stat: stat})
+	}
+
+	mountPoints := make([]string, len(mounts))
+	for i, mount := range mounts {
+		mountPoints[i] = mount.mountPoint
+	}
+	names := labelNames(mountPoints)
+
+	collected := make([]metrics.Metric, 0, 4*len(mounts))
+	for _, mount := range mounts {
+		name, stat := names[mount.mountPoint], mount.stat
+		collected = append(collected,
+			Gauge(stat.total-stat.free).Metric("DiskUsed_"+name),
+			Gauge(stat.available).Metric("DiskFree_"+name),
+			Gauge(stat.files-stat.filesFree).Metric("DiskInodesUsed_"+name),
+			Gauge(stat.filesFree).Metric("DiskInodesFree_"+name),
+		)
+	}
+
+	return collected, nil
+}
+
+func (p *diskPlugin) collectIO() ([]metrics.Metric, error) {
+	lines, err := readFields(procFile(p.procPath, "diskstats"))
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]diskIOStat, len(lines))
+	var devices []string
+
+	// /proc/diskstats: major minor name reads merged sectors_read ms writes merged sectors_written ...
+	for _, fields := range lines {
+		if len(fields) < 10 || !p.devices.Match(fields[2]) {
+			continue
+		}
+
+		device := fields[2]
+		current[device] = diskIOStat{
+			readOps:    parseUint(fields, 3),
+			readBytes:  parseUint(fields, 5) * diskSectorSize,
+			writeOps:   parseUint(fields, 7),
+			writeBytes: parseUint(fields, 9) * diskSectorSize,
+		}
+		devices = append(devices, device)
+	}
+
+	var collected []metrics.Metric
+	names := labelNames(devices)
+
+	for _, device := range devices {
+		stat := current[device]
+		previous, exist := p.previous[device]
+		if !exist {
+			continue
+		}
+
+		name := names[device]
+		collected = append(collected,
+			counterDelta(previous.readBytes, stat.readBytes).Metric("DiskReadBytes_"+name),
+			counterDelta(previous.writeBytes, stat.writeBytes).Metric("DiskWriteBytes_"+name),
+			counterDelta(previous.readOps, stat.readOps).Metric("DiskReadOps_"+name),
+			counterDelta(previous.writeOps, stat.writeOps).Metric("DiskWriteOps_"+name),
+		)
+	}
+
+	p.previous = current
+	return collected, nil
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func newTestDiskPlugin(t *testing.T, procPath string, cfg DiskConfig) *diskPlugin {
+	config := NewConfig()
+	config.ProcPath = procPath
+	if cfg.FSTypeExclude == nil {
+		cfg.FSTypeExclude = config.Disk.FSTypeExclude
+	}
+	if cfg.DeviceExclude == nil {
+		cfg.DeviceExclude = config.Disk.DeviceExclude
+	}
+	config.Disk = cfg
+
+	p, err := newDiskPlugin(time.Second, &config)
+	require.NoError(t, err)
+	disk := p.(*diskPlugin)
+	disk.statfs = func(path string) (fsStat, error) {
+		return fsStat{
+			total:     1000,
+			free:      400,
+			available: 300,
+			files:     100,
+			filesFree: 60,
+		}, nil
+	}
+	return disk
+}
+
+func Test_diskPlugin_collectUsage(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        DiskConfig
+		wantMounts []string
+	}{
+		{
+			name:       "default filters",
+			wantMounts: []string{"root", "var_lib_data", "mnt_backup_disk"},
+		},
+
+		{
+			name: "include mounts",
+			cfg: DiskConfig{
+				MountInclude: []string{"/var/lib/*"},
+			},
+			wantMounts: []string{"var_lib_data"},
+		},
+
+		{
+			name: "exclude mounts",
+			cfg: DiskConfig{
+				MountExclude: []string{"/mnt/*"},
+			},
+			wantMounts: []string{"root", "var_lib_data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestDiskPlugin(t, "testdata/proc", tt.cfg)
+			m, err := p.collectUsage()
+			require.NoError(t, err)
+			assert.Len(t, m, len(tt.wantMounts)*4)
+
+			for _, mount := range tt.wantMounts {
+				used, found := findMetric(m, metrics.GaugeStrName, "DiskUsed_"+mount)
+				require.True(t, found, mount)
+				assert.Equal(t, float64(600), used.Float64Value())
+
+				free, found := findMetric(m, metrics.GaugeStrName, "DiskFree_"+mount)
+				require.True(t, found, mount)
+				assert.Equal(t, float64(300), free.Float64Value())
+
+				inodes, found := findMetric(m, metrics.GaugeStrName, "DiskInodesUsed_"+mount)
+				require.True(t, found, mount)
+				assert.Equal(t, float64(40), inodes.Float64Value())
+			}
+		})
+	}
+}
+
+func Test_diskPlugin_collectIO(t *testing.T) {
+	procPath := t.TempDir()
+	data, err := os.ReadFile("testdata/proc/diskstats")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "diskstats"), data, 0o644))
+
+	p := newTestDiskPlugin(t, procPath, DiskConfig{DeviceExclude: []string{"loop*", "sd?[0-9]"}})
+
+	m, err := p.collectIO()
+	require.NoError(t, err)
+	assert.Empty(t, m, "first poll only stores the baseline")
+
+	next := "   8       0 sda 1010 10 20100 500 2020 30 40400 900 0 1200 1400 0 0 0 0 0 0\n" +
+		"   8       1 sda1 910 10 18100 450 1920 30 38400 850 0 1100 1300 0 0 0 0 0 0\n" +
+		"   8      16 sdb 301 0 6008 100 100 0 800 50 0 120 150 0 0 0 0 0 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "diskstats"), []byte(next), 0o644))
+
+	m, err = p.collectIO()
+	require.NoError(t, err)
+	assert.Len(t, m, 8)
+
+	want := map[string]int64{
+		"DiskReadBytes_sda":  100 * diskSectorSize,
+		"DiskWriteBytes_sda": 400 * diskSectorSize,
+		"DiskReadOps_sda":    10,
+		"DiskWriteOps_sda":   20,
+		"DiskReadBytes_sdb":  8 * diskSectorSize,
+		"DiskWriteBytes_sdb": 0,
+		"DiskReadOps_sdb":    1,
+		"DiskWriteOps_sdb":   0,
+	}
+	for id, value := range want {
+		metric, found := findMetric(m, metrics.CounterStrName, id)
+		require.True(t, found, id)
+		assert.Equal(t, value, metric.Int64Value(), id)
+	}
+}
+
+func Test_labelName(t *testing.T) {
+	assert.Equal(t, "DiskUsed_root", labelName("DiskUsed", "/"))
+	assert.Equal(t, "DiskUsed_mnt_backup_disk", labelName("DiskUsed", "/mnt/backup disk"))
+	assert.Equal(t, "NetRxBytes_eth0.100", labelName("NetRxBytes", "eth0.100"))
+}
+
+func Test_labelNames(t *testing.T) {
+	names := labelNames([]string{"/", "/var/log", "/var_log", "/var log", "/data", "/data"})
+
+	assert.Equal(t, "root", names["/"])
+	assert.Equal(t, "data", names["/data"])
+
+	colliding := []string{names["/var/log"], names["/var_log"], names["/var log"]}
+	for _, name := range colliding {
+		assert.Regexp(t, `^var_log_[0-9a-f]{8}$`, name)
+	}
+	assert.NotEqual(t, colliding[0], colliding[1])
+	assert.NotEqual(t, colliding[0], colliding[2])
+	assert.NotEqual(t, colliding[1], colliding[2])
+	assert.Equal(t, colliding[0], labelNames([]string{"/var_log", "/var/log"})["/var/log"], "the suffix doesn't depend on the order")
+}
+
+func Test_diskPlugin_collectUsageCollision(t *testing.T) {
+	procPath := t.TempDir()
+	mounts := "/dev/sda1 /var/log ext4 rw 0 0\n/dev/sdb1 /var_log ext4 rw 0 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "mounts"), []byte(mounts), 0o644))
+
+	p := newTestDiskPlugin(t, procPath, DiskConfig{})
+	m, err := p.collectUsage()
+	require.NoError(t, err)
+	require.Len(t, m, 8, "both mount points are reported")
+
+	ids := make(map[string]struct{}, len(m))
+	for _, metric := range m {
+		ids[metric.ID] = struct{}{}
+	}
+	assert.Len(t, ids, 8, "no metric overwrites another")
+}
@@ -0,0 +1,243 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const (
+	ExecPluginName   = "exec"
+	ExecFormatSimple = "simple"
+	ExecFormatProm   = "prometheus"
+)
+
+var (
+	ExecTimeoutDefault   = 10 * time.Second
+	ErrInvalidExecScript = errors.New("invalid exec script")
+)
+
+type (
+	ExecConfig struct {
+		// Scripts is a JSON array of ExecScript.
+		Scripts ExecScripts   `env:"SCRIPTS" yaml:"scripts"`
+		Timeout time.Duration `env:"TIMEOUT" yaml:"timeout"`
+	}
+
+	// ExecScript is a command run by the exec collector. Its stdout is parsed either as
+	// "type name value" lines (simple), where counters are increments, or as the
+	// Prometheus text format, where counters are cumulative.
+	ExecScript struct {
+		Name     string   `json:"name" yaml:"name"`
+		Command  []string `json:"command" yaml:"command"`
+		Interval Duration `json:"interval,omitempty" yaml:"interval"`
+		Timeout  Duration `json:"timeout,omitempty" yaml:"timeout"`
+		Format   string   `json:"format,omitempty" yaml:"format"`
+	}
+
+	ExecScripts []ExecScript
+
+	// Duration is a time.Duration read from strings such as "30s".
+	Duration time.Duration
+
+	execScript struct {
+		ExecScript
+		converter *metrics.SampleConverter
+		nextRun   time.Time
+	}
+
+	execPlugin struct {
+		plugin
+		scripts []*execScript
+		now     func() time.Time
+	}
+)
+
+func init() {
+	Register(ExecPluginName, newExecPlugin)
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("Duration_UnmarshalText: %w", err)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (s *ExecScripts) UnmarshalText(text []byte) error {
+	var scripts []ExecScript
+	if err := json.Unmarshal(text, &scripts); err != nil {
+		return fmt.Errorf("ExecScripts_UnmarshalText: %w: %v", ErrInvalidExecScript, err)
+	}
+
+	*s = scripts
+	return nil
+}
+
+func (s ExecScript) Valid() error {
+	switch {
+	case s.Name == "":
+		return fmt.Errorf("ExecScript_Valid: %w: missing name", ErrInvalidExecScript)
+	case len(s.Command) == 0:
+		return fmt.Errorf("ExecScript_Valid: %w: %s: missing command", ErrInvalidExecScript, s.Name)
+	case s.Interval < 0 || s.Timeout < 0:
+		return fmt.Errorf("ExecScript_Valid: %w: %s: negative duration", ErrInvalidExecScript, s.Name)
+	}
+
+	switch s.Format {
+	case "", ExecFormatSimple, ExecFormatProm:
+		return nil
+	default:
+		return fmt.Errorf("ExecScript_Valid: %w: %s: unknown format %s", ErrInvalidExecScript, s.Name, s.Format)
+	}
+}
+
+func newExecPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	timeout := cfg.Exec.Timeout
+	if timeout <= 0 {
+		timeout = ExecTimeoutDefault
+	}
+
+	scripts := make([]*execScript, 0, len(cfg.Exec.Scripts))
+	for _, item := range cfg.Exec.Scripts {
+		if err := item.Valid(); err != nil {
+			return nil, fmt.Errorf("newExecPlugin: %w", err)
+		}
+
+		if item.Interval == 0 {
+			item.Interval = Duration(interval)
+		}
+		if item.Timeout == 0 {
+			item.Timeout = Duration(timeout)
+		}
+		if item.Format == "" {
+			item.Format = ExecFormatSimple
+		}
+
+		scripts = append(scripts, &execScript{
+			ExecScript: item,
+			converter:  metrics.NewSampleConverter(),
+		})
+	}
+
+	return &execPlugin{
+		plugin: plugin{
+			name:     ExecPluginName,
+			interval: interval,
+		},
+		scripts: scripts,
+		now:     time.Now,
+	}, nil
+}
+
+// Collect runs every script whose interval has elapsed concurrently. A failed
+// script increments its ExecErrors_<name> counter instead of failing the poll.
+func (p *execPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := p.now()
+	results := make([][]metrics.Metric, len(p.scripts))
+	wg := new(sync.WaitGroup)
+
+	for i, script := range p.scripts {
+		if now.Before(script.nextRun) {
+			continue
+		}
+		script.nextRun = now.Add(time.Duration(script.Interval))
+
+		wg.Add(1)
+		go func(i int, script *execScript) {
+			defer wg.Done()
+
+			collected, err := script.run(ctx)
+			if err != nil {
+				logging.Warnf("execPlugin_Collect: [%s] %v", script.Name, err)
+				results[i] = []metrics.Metric{Counter(1).Metric(labelName("ExecErrors", script.Name))}
+				return
+			}
+
+			results[i] = append(collected, Counter(0).Metric(labelName("ExecErrors", script.Name)))
+		}(i, script)
+	}
+
+	wg.Wait()
+
+	var collected []metrics.Metric
+	for _, result := range results {
+		collected = append(collected, result...)
+	}
+
+	return collected, nil
+}
+
+func (s *execScript) run(ctx context.Context) ([]metrics.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout))
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	// #nosec G204 -- commands come from the agent configuration
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("run: timeout %s exceeded", time.Duration(s.Timeout))
+		}
+		return nil, fmt.Errorf("run: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if s.Format == ExecFormatProm {
+		samples, err := metrics.ParsePrometheusText(&stdout)
+		if err != nil {
+			return nil, fmt.Errorf("run: %w", err)
+		}
+		return s.converter.Convert(samples), nil
+	}
+
+	return parseSimpleOutput(&stdout)
+}
+
+// parseSimpleOutput parses "type name value" lines, empty lines and lines starting with # are ignored.
+func parseSimpleOutput(r *bytes.Buffer) ([]metrics.Metric, error) {
+	var collected []metrics.Metric
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("parseSimpleOutput: %w: %q", metrics.ErrInvalidTextFormat, line)
+		}
+
+		m, err := metrics.NewMetric(fields[1], fields[0], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parseSimpleOutput: %w", err)
+		}
+		collected = append(collected, m)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("parseSimpleOutput: %w", err)
+	}
+
+	return collected, nil
+}
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func TestExecScripts_UnmarshalText(t *testing.T) {
+	var scripts ExecScripts
+	err := scripts.UnmarshalText([]byte(`[{"name":"queue","command":["/bin/queue-len","-q","jobs"],"interval":"30s","format":"prometheus"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, ExecScripts{{
+		Name:     "queue",
+		Command:  []string{"/bin/queue-len", "-q", "jobs"},
+		Interval: Duration(30 * time.Second),
+		Format:   ExecFormatProm,
+	}}, scripts)
+
+	assert.ErrorIs(t, scripts.UnmarshalText([]byte(`{"name":"queue"}`)), ErrInvalidExecScript)
+}
+
+func Test_newExecPlugin_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		script ExecScript
+	}{
+		{
+			name:   "missing name",
+			script: ExecScript{Command: []string{"true"}},
+		},
+
+		{
+			name:   "missing command",
+			script: ExecScript{Name: "check"},
+		},
+
+		{
+			name:   "unknown format",
+			script: ExecScript{Name: "check", Command: []string{"true"}, Format: "xml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Exec.Scripts = ExecScripts{tt.script}
+			_, err := newExecPlugin(time.Second, &cfg)
+			assert.ErrorIs(t, err, ErrInvalidExecScript)
+		})
+	}
+}
+
+func Test_execPlugin_Collect(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Exec.Scripts = ExecScripts{
+		{
+			Name:    "simple",
+			Command: []string{"sh", "-c", "echo '# comment'; echo 'gauge QueueLength 17.5'; echo 'counter Jobs 3'"},
+		},
+		{
+			Name:    "prom",
+			Command: []string{"sh", "-c", "printf '# TYPE done_total counter\\ndone_total $(cat \"$0\")\\n'", t.TempDir() + "/value"},
+			Format:  ExecFormatProm,
+		},
+		{
+			Name:    "failed",
+			Command: []string{"sh", "-c", "exit 1"},
+		},
+		{
+			Name:    "slow",
+			Command: []string{"sleep", "5"},
+			Timeout: Duration(50 * time.Millisecond),
+		},
+		{
+			Name:     "hourly",
+			Command:  []string{"sh", "-c", "echo 'gauge Hourly 1'"},
+			Interval: Duration(time.Hour),
+		},
+	}
+
+	p, err := newExecPlugin(time.Second, &cfg)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	p.(*execPlugin).now = func() time.Time { return now }
+
+	collected, err := p.Collect(context.Background())
+	require.NoError(t, err)
+
+	gauges := map[string]float64{"QueueLength": 17.5, "Hourly": 1}
+	for id, want := range gauges {
+		metric, found := findMetric(collected, metrics.GaugeStrName, id)
+		require.True(t, found, id)
+		assert.Equal(t, want, metric.Float64Value(), id)
+	}
+
+	// the prometheus script fails to read the missing value file
+	counters := map[string]int64{
+		"Jobs": 3, "ExecErrors_simple": 0, "ExecErrors_hourly": 0,
+		"ExecErrors_failed": 1, "ExecErrors_slow": 1, "ExecErrors_prom": 1,
+	}
+	for id, want := range counters {
+		metric, found := findMetric(collected, metrics.CounterStrName, id)
+		require.True(t, found, id)
+		assert.Equal(t, want, metric.Int64Value(), id)
+	}
+
+	now = now.Add(time.Second)
+	collected, err = p.Collect(context.Background())
+	require.NoError(t, err)
+	for _, m := range collected {
+		assert.NotEqual(t, "Hourly", m.ID, "script must not run before its interval elapsed")
+	}
+}
+
+func Test_execScript_run_Prometheus(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Exec.Scripts = ExecScripts{{
+		Name:    "prom",
+		Command: []string{"sh", "-c", "printf '# TYPE done_total counter\\ndone_total %s\\nqueue 2\\n' \"$0\"", "10"},
+		Format:  ExecFormatProm,
+	}}
+	p, err := newExecPlugin(time.Second, &cfg)
+	require.NoError(t, err)
+	script := p.(*execPlugin).scripts[0]
+
+	collected, err := script.run(context.Background())
+	require.NoError(t, err)
+	require.Len(t, collected, 1, "first run records the counter baseline")
+	queue, found := findMetric(collected, metrics.GaugeStrName, "queue")
+	require.True(t, found)
+	assert.Equal(t, float64(2), queue.Float64Value())
+
+	script.Command[3] = "15"
+	collected, err = script.run(context.Background())
+	require.NoError(t, err)
+	done, found := findMetric(collected, metrics.CounterStrName, "done_total")
+	require.True(t, found)
+	assert.Equal(t, int64(5), done.Int64Value())
+}
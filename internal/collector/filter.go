@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strings"
+)
+
+// filter selects names by shell glob patterns. An empty include list matches everything,
+// exclude patterns are applied after include.
+type filter struct {
+	include []string
+	exclude []string
+}
+
+func newFilter(include, exclude []string) filter {
+	return filter{
+		include: include,
+		exclude: exclude,
+	}
+}
+
+func (f filter) Match(name string) bool {
+	if len(f.include) != 0 && !matchAny(f.include, name) {
+		return false
+	}
+
+	return !matchAny(f.exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// labelName converts an arbitrary label (mount point, device, interface) into
+// a metric ID suffix safe to use in URL paths.
+func labelName(prefix, label string) string {
+	return prefix + "_" + sanitizeLabel(label)
+}
+
+func sanitizeLabel(label string) string {
+	label = strings.Trim(label, "/")
+	if label == "" {
+		label = "root"
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, label)
+}
+
+// labelNames maps the labels reported together to their metric ID suffixes, see labelName.
+// The labels sanitized to the same suffix, e.g. the mount points /var/log and /var_log, get
+// the FNV-1a hash of the label appended, so neither overwrites the other.
+func labelNames(labels []string) map[string]string {
+	groups := make(map[string]map[string]struct{}, len(labels))
+	for _, label := range labels {
+		name := sanitizeLabel(label)
+		if groups[name] == nil {
+			groups[name] = make(map[string]struct{}, 1)
+		}
+		groups[name][label] = struct{}{}
+	}
+
+	names := make(map[string]string, len(labels))
+	for name, group := range groups {
+		for label := range group {
+			if len(group) == 1 {
+				names[label] = name
+				continue
+			}
+
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(label))
+			names[label] = fmt.Sprintf("%s_%08x", name, h.Sum32())
+		}
+	}
+
+	return names
+}
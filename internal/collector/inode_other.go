@@ -0,0 +1,11 @@
+//go:build !linux
+
+package collector
+
+import "os"
+
+// inode is unknown on this platform, so rotation is only detected by truncation.
+func inode(fi os.FileInfo) uint64 {
+	_ = fi
+	return 0
+}
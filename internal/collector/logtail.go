@@ -0,0 +1,366 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const LogtailPluginName = "logtail"
+
+var (
+	// LogtailStateFileDefault keeps no state, a restarted agent skips the lines written meanwhile
+	LogtailStateFileDefault string
+	ErrInvalidLogFile       = errors.New("invalid log file")
+)
+
+type (
+	LogtailConfig struct {
+		// Files is a JSON array of LogFile.
+		Files LogFiles `env:"FILES" yaml:"files"`
+		// StateFile keeps the read offsets between restarts, empty disables it.
+		StateFile string `env:"STATE_FILE" yaml:"state_file"`
+	}
+
+	// LogFile is a file tailed by the logtail collector. Every matched line increments
+	// LogMatches_<name>_<pattern>, numeric named capture groups of the last match are
+	// reported as LogValue_<name>_<pattern>_<group> gauges.
+	LogFile struct {
+		Name     string       `json:"name" yaml:"name"`
+		Path     string       `json:"path" yaml:"path"`
+		Patterns []LogPattern `json:"patterns" yaml:"patterns"`
+	}
+
+	LogPattern struct {
+		Name  string `json:"name" yaml:"name"`
+		Regex string `json:"regex" yaml:"regex"`
+	}
+
+	LogFiles []LogFile
+
+	logState struct {
+		Inode  uint64 `json:"inode" yaml:"inode"`
+		Offset int64  `json:"offset" yaml:"offset"`
+	}
+
+	logPattern struct {
+		name  string
+		regex *regexp.Regexp
+	}
+
+	logTail struct {
+		LogFile
+		patterns []logPattern
+		file     *os.File
+		state    logState
+	}
+
+	logtailPlugin struct {
+		plugin
+		stateFile string
+		tails     []*logTail
+		saved     map[string]logState
+	}
+)
+
+func init() {
+	Register(LogtailPluginName, newLogtailPlugin)
+}
+
+func (f *LogFiles) UnmarshalText(text []byte) error {
+	var files []LogFile
+	if err := json.Unmarshal(text, &files); err != nil {
+		return fmt.Errorf("LogFiles_UnmarshalText: %w: %v", ErrInvalidLogFile, err)
+	}
+
+	*f = files
+	return nil
+}
+
+func (f LogFile) Valid() error {
+	switch {
+	case f.Name == "":
+		return fmt.Errorf("LogFile_Valid: %w: missing name", ErrInvalidLogFile)
+	case f.Path == "":
+		return fmt.Errorf("LogFile_Valid: %w: %s: missing path", ErrInvalidLogFile, f.Name)
+	case len(f.Patterns) == 0:
+		return fmt.Errorf("LogFile_Valid: %w: %s: missing patterns", ErrInvalidLogFile, f.Name)
+	}
+
+	for _, pattern := range f.Patterns {
+		if pattern.Name == "" || pattern.Regex == "" {
+			return fmt.Errorf("LogFile_Valid: %w: %s: pattern requires name and regex", ErrInvalidLogFile, f.Name)
+		}
+	}
+
+	return nil
+}
+
+func newLogtailPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	p := &logtailPlugin{
+		plugin: plugin{
+			name:     LogtailPluginName,
+			interval: interval,
+		},
+		stateFile: cfg.Logtail.StateFile,
+		saved:     make(map[string]logState),
+	}
+
+	for _, item := range cfg.Logtail.Files {
+		if err := item.Valid(); err != nil {
+			return nil, fmt.Errorf("newLogtailPlugin: %w", err)
+		}
+
+		tail := &logTail{LogFile: item}
+		for _, pattern := range item.Patterns {
+			regex, err := regexp.Compile(pattern.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("newLogtailPlugin: %w: %s: %v", ErrInvalidLogFile, item.Name, err)
+			}
+			tail.patterns = append(tail.patterns, logPattern{name: pattern.Name, regex: regex})
+		}
+
+		p.tails = append(p.tails, tail)
+	}
+
+	if err := p.loadState(); err != nil {
+		return nil, fmt.Errorf("newLogtailPlugin: %w", err)
+	}
+
+	return p, nil
+}
+
+func (p *logtailPlugin) Collect(_ context.Context) ([]metrics.Metric, error) {
+	var collected []metrics.Metric
+
+	for _, tail := range p.tails {
+		m, err := tail.poll(p.saved[tail.Path])
+		if err != nil {
+			logging.Warnf("logtailPlugin_Collect: [%s] %v", tail.Name, err)
+		}
+		collected = append(collected, m...)
+		if tail.file != nil {
+			p.saved[tail.Path] = tail.state
+		}
+	}
+
+	if err := p.saveState(); err != nil {
+		return collected, fmt.Errorf("logtailPlugin_Collect: %w", err)
+	}
+
+	return collected, nil
+}
+
+// Close closes the tailed files, the next Collect opens them again at the saved offsets.
+func (p *logtailPlugin) Close() error {
+	var err error
+	for _, tail := range p.tails {
+		if tail.file == nil {
+			continue
+		}
+		if closeErr := tail.file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("logtailPlugin_Close: %w", closeErr)
+		}
+		tail.file = nil
+	}
+
+	return err
+}
+
+func (p *logtailPlugin) loadState() error {
+	if p.stateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loadState: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &p.saved); err != nil {
+		return fmt.Errorf("loadState: %s: %w", p.stateFile, err)
+	}
+
+	return nil
+}
+
+// saveState replaces the state file atomically, so a crash never leaves a partial file behind.
+func (p *logtailPlugin) saveState() error {
+	if p.stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(p.saved)
+	if err != nil {
+		return fmt.Errorf("saveState: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.stateFile), filepath.Base(p.stateFile)+".*")
+	if err != nil {
+		return fmt.Errorf("saveState: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saveState: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("saveState: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), p.stateFile); err != nil {
+		return fmt.Errorf("saveState: %w", err)
+	}
+
+	return nil
+}
+
+// poll reads the lines appended since the previous poll. The file is kept open, so the
+// rest of a rotated file is read before switching to the new file at the same path.
+func (t *logTail) poll(saved logState) ([]metrics.Metric, error) {
+	counts := make(map[string]int64, len(t.patterns))
+	values := make(map[string]float64)
+	collected := func() []metrics.Metric {
+		m := make([]metrics.Metric, 0, len(counts)+len(values))
+		for _, pattern := range t.patterns {
+			m = append(m, Counter(counts[pattern.name]).Metric(labelName("LogMatches", t.Name+"_"+pattern.name)))
+		}
+		for id, value := range values {
+			m = append(m, Gauge(value).Metric(id))
+		}
+		return m
+	}
+
+	if t.file == nil {
+		if err := t.open(saved, true); err != nil {
+			return collected(), fmt.Errorf("poll: %w", err)
+		}
+	}
+
+	if err := t.read(counts, values); err != nil {
+		return collected(), fmt.Errorf("poll: %w", err)
+	}
+
+	fi, err := os.Stat(t.Path)
+	if err != nil {
+		// the file may be missing for a moment while it is rotated
+		if errors.Is(err, os.ErrNotExist) {
+			return collected(), nil
+		}
+		return collected(), fmt.Errorf("poll: %w", err)
+	}
+
+	if inode(fi) != t.state.Inode {
+		t.file.Close()
+		t.file = nil
+		if err = t.open(logState{}, false); err != nil {
+			return collected(), fmt.Errorf("poll: %w", err)
+		}
+		if err = t.read(counts, values); err != nil {
+			return collected(), fmt.Errorf("poll: %w", err)
+		}
+	}
+
+	return collected(), nil
+}
+
+// open opens the file at the saved offset if the saved state belongs to the same file.
+// Without a saved state the initial open starts at the end of the file, so old lines are
+// not counted, while a file that appears after rotation is read from the beginning.
+func (t *logTail) open(saved logState, initial bool) error {
+	file, err := os.Open(t.Path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("open: %w", err)
+	}
+
+	t.file = file
+	t.state = logState{Inode: inode(fi)}
+
+	switch {
+	case saved != logState{}:
+		if saved.Inode == t.state.Inode && saved.Offset <= fi.Size() {
+			t.state.Offset = saved.Offset
+		}
+	case initial:
+		t.state.Offset = fi.Size()
+	}
+
+	return nil
+}
+
+// read matches the complete lines after the current offset, a partial last line is
+// left for the next poll.
+func (t *logTail) read(counts map[string]int64, values map[string]float64) error {
+	fi, err := t.file.Stat()
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	if fi.Size() < t.state.Offset {
+		// truncated in place, e.g. by copytruncate
+		t.state.Offset = 0
+	}
+
+	if _, err = t.file.Seek(t.state.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	reader := bufio.NewReader(t.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+
+		t.state.Offset += int64(len(line))
+		t.match(line, counts, values)
+	}
+}
+
+func (t *logTail) match(line []byte, counts map[string]int64, values map[string]float64) {
+	for _, pattern := range t.patterns {
+		submatches := pattern.regex.FindSubmatch(line)
+		if submatches == nil {
+			continue
+		}
+
+		counts[pattern.name]++
+
+		for i, group := range pattern.regex.SubexpNames() {
+			if group == "" || submatches[i] == nil {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(string(submatches[i]), 64)
+			if err != nil {
+				continue
+			}
+			values[labelName("LogValue", t.Name+"_"+pattern.name+"_"+group)] = value
+		}
+	}
+}
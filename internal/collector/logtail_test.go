@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func TestLogFiles_UnmarshalText(t *testing.T) {
+	var files LogFiles
+	err := files.UnmarshalText([]byte(`[{"name":"nginx","path":"/var/log/nginx/access.log",` +
+		`"patterns":[{"name":"errors","regex":"\" 5\\d\\d "}]}]`))
+	require.NoError(t, err)
+	assert.Equal(t, LogFiles{{
+		Name:     "nginx",
+		Path:     "/var/log/nginx/access.log",
+		Patterns: []LogPattern{{Name: "errors", Regex: `" 5\d\d `}},
+	}}, files)
+
+	assert.ErrorIs(t, files.UnmarshalText([]byte(`nginx`)), ErrInvalidLogFile)
+}
+
+func Test_newLogtailPlugin_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file LogFile
+	}{
+		{
+			name: "missing path",
+			file: LogFile{Name: "app", Patterns: []LogPattern{{Name: "errors", Regex: "ERROR"}}},
+		},
+
+		{
+			name: "missing patterns",
+			file: LogFile{Name: "app", Path: "/var/log/app.log"},
+		},
+
+		{
+			name: "invalid regex",
+			file: LogFile{Name: "app", Path: "/var/log/app.log", Patterns: []LogPattern{{Name: "errors", Regex: "("}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Logtail = LogtailConfig{Files: LogFiles{tt.file}}
+			_, err := newLogtailPlugin(time.Second, &cfg)
+			assert.ErrorIs(t, err, ErrInvalidLogFile)
+		})
+	}
+}
+
+func Test_logtailPlugin_Collect(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writeLog(t, path, os.O_CREATE|os.O_WRONLY, "ERROR old line before start\n")
+
+	cfg := NewConfig()
+	cfg.Logtail = LogtailConfig{
+		StateFile: filepath.Join(dir, "state.json"),
+		Files: LogFiles{{
+			Name: "app",
+			Path: path,
+			Patterns: []LogPattern{
+				{Name: "errors", Regex: "ERROR"},
+				{Name: "requests", Regex: `took=(?P<seconds>[0-9.]+)s`},
+			},
+		}},
+	}
+	newPlugin := func() Plugin {
+		p, err := newLogtailPlugin(time.Second, &cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+		return p
+	}
+	collect := func(p Plugin, wantErrors, wantRequests int64) []metrics.Metric {
+		t.Helper()
+		m, err := p.Collect(context.Background())
+		require.NoError(t, err)
+
+		matched, found := findMetric(m, metrics.CounterStrName, "LogMatches_app_errors")
+		require.True(t, found)
+		assert.Equal(t, wantErrors, matched.Int64Value())
+
+		requests, found := findMetric(m, metrics.CounterStrName, "LogMatches_app_requests")
+		require.True(t, found)
+		assert.Equal(t, wantRequests, requests.Int64Value())
+		return m
+	}
+
+	p := newPlugin()
+	collect(p, 0, 0)
+
+	writeLog(t, path, os.O_APPEND|os.O_WRONLY, "GET / took=0.5s\nERROR GET /a took=1.5s\nERROR partial")
+	m := collect(p, 1, 2)
+	seconds, found := findMetric(m, metrics.GaugeStrName, "LogValue_app_requests_seconds")
+	require.True(t, found)
+	assert.Equal(t, 1.5, seconds.Float64Value())
+
+	writeLog(t, path, os.O_APPEND|os.O_WRONLY, " line\n")
+	collect(p, 1, 0)
+
+	// copytruncate
+	writeLog(t, path, os.O_TRUNC|os.O_WRONLY, "ERROR after truncate\n")
+	collect(p, 1, 0)
+
+	// rename and create, lines written to the old file before reopening are still read
+	require.NoError(t, os.Rename(path, path+".1"))
+	writeLog(t, path+".1", os.O_APPEND|os.O_WRONLY, "ERROR late write to the rotated file\n")
+	writeLog(t, path, os.O_CREATE|os.O_WRONLY, "ERROR first line of the new file\n")
+	collect(p, 2, 0)
+
+	// a restarted plugin continues from the persisted offset
+	require.NoError(t, p.Close())
+	p = newPlugin()
+	collect(p, 0, 0)
+	writeLog(t, path, os.O_APPEND|os.O_WRONLY, "ERROR after restart\n")
+	collect(p, 1, 0)
+
+	// a closed plugin holds no file and reopens it at the offset it reached
+	require.NoError(t, p.Close())
+	assert.Nil(t, p.(*logtailPlugin).tails[0].file)
+	writeLog(t, path, os.O_APPEND|os.O_WRONLY, "ERROR while closed\n")
+	collect(p, 1, 0)
+}
+
+func writeLog(t *testing.T, path string, flag int, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, flag, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
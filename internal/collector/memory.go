@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const MemoryPluginName = "memory"
+
+type memoryPlugin struct {
+	plugin
+}
+
+func init() {
+	Register(MemoryPluginName, newMemoryPlugin)
+}
+
+func newMemoryPlugin(interval time.Duration, _ *Config) (Plugin, error) {
+	return &memoryPlugin{
+		plugin{
+			name:     MemoryPluginName,
+			interval: interval,
+		},
+	}, nil
+}
+
+func (p *memoryPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	memStats, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("memoryPlugin_Collect: %w", err)
+	}
+
+	return []metrics.Metric{
+		Gauge(memStats.Total).Metric("TotalMemory"),
+		Gauge(memStats.Free).Metric("FreeMemory"),
+	}, nil
+}
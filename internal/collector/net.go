@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const NetPluginName = "net"
+
+var (
+	NetInterfaceExcludeDefault = []string{"lo"}
+
+	// netCounters maps /proc/net/dev columns (after the interface name) to metric prefixes.
+	netCounters = []struct {
+		prefix string
+		column int
+	}{
+		{"NetRxBytes", 0},
+		{"NetRxPackets", 1},
+		{"NetRxErrors", 2},
+		{"NetRxDrops", 3},
+		{"NetTxBytes", 8},
+		{"NetTxPackets", 9},
+		{"NetTxErrors", 10},
+		{"NetTxDrops", 11},
+	}
+)
+
+type (
+	NetConfig struct {
+		InterfaceInclude []string `env:"INTERFACE_INCLUDE" envSeparator:"," yaml:"interface_include"`
+		InterfaceExclude []string `env:"INTERFACE_EXCLUDE" envSeparator:"," yaml:"interface_exclude"`
+	}
+
+	netPlugin struct {
+		plugin
+		procPath   string
+		interfaces filter
+		previous   map[string][]uint64
+	}
+)
+
+func init() {
+	Register(NetPluginName, newNetPlugin)
+}
+
+func newNetPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	return &netPlugin{
+		plugin: plugin{
+			name:     NetPluginName,
+			interval: interval,
+		},
+		procPath:   cfg.ProcPath,
+		interfaces: newFilter(cfg.Net.InterfaceInclude, cfg.Net.InterfaceExclude),
+	}, nil
+}
+
+// Collect reports interface counters as increments since the previous poll, so the
+// server accumulates them correctly with IncrementCounter.
+func (p *netPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	lines, err := readFields(procFile(p.procPath, "net", "dev"))
+	if err != nil {
+		return nil, fmt.Errorf("netPlugin_Collect: %w", err)
+	}
+
+	current := make(map[string][]uint64, len(lines))
+	var interfaces []string
+
+	for _, fields := range lines {
+		// "eth0:123" is written without a space once the counter gets wide enough
+		name, first, found := strings.Cut(strings.Join(fields, " "), ":")
+		if !found {
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		if !p.interfaces.Match(name) {
+			continue
+		}
+
+		values := strings.Fields(first)
+		if len(values) < 16 {
+			continue
+		}
+
+		stat := make([]uint64, len(values))
+		for i := range values {
+			stat[i] = parseUint(values, i)
+		}
+		current[name] = stat
+		interfaces = append(interfaces, name)
+	}
+
+	var collected []metrics.Metric
+	names := labelNames(interfaces)
+
+	for _, name := range interfaces {
+		stat := current[name]
+		previous, exist := p.previous[name]
+		if !exist {
+			continue
+		}
+
+		for _, counter := range netCounters {
+			collected = append(collected,
+				counterDelta(previous[counter.column], stat[counter.column]).Metric(counter.prefix+"_"+names[name]))
+		}
+	}
+
+	p.previous = current
+	return collected, nil
+}
@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_netPlugin_Collect(t *testing.T) {
+	procPath := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(procPath, "net"), 0o755))
+	data, err := os.ReadFile("testdata/proc/net/dev")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "net", "dev"), data, 0o644))
+
+	tests := []struct {
+		name           string
+		cfg            NetConfig
+		wantInterfaces []string
+	}{
+		{
+			name:           "default filters",
+			cfg:            NetConfig{InterfaceExclude: NetInterfaceExcludeDefault},
+			wantInterfaces: []string{"eth0", "wlan0"},
+		},
+
+		{
+			name:           "include interfaces",
+			cfg:            NetConfig{InterfaceInclude: []string{"eth*"}},
+			wantInterfaces: []string{"eth0"},
+		},
+
+		{
+			name: "exclude interfaces",
+			cfg: NetConfig{
+				InterfaceExclude: []string{"lo", "wlan*"},
+			},
+			wantInterfaces: []string{"eth0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(filepath.Join(procPath, "net", "dev"), data, 0o644))
+			cfg := NewConfig()
+			cfg.ProcPath = procPath
+			cfg.Net = tt.cfg
+			p, err := NewPlugin(NetPluginName, time.Second, &cfg)
+			require.NoError(t, err)
+
+			m, err := p.Collect(context.Background())
+			require.NoError(t, err)
+			assert.Empty(t, m, "first poll only stores the baseline")
+
+			next := "Inter-|   Receive                                                |  Transmit\n" +
+				" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n" +
+				"    lo:  6874600    1218    0    0    0     0          0         0  6874600    1218    0    0    0     0       0          0\n" +
+				"  eth0:1048577000  900010    3    1    0     0          0        10 52429800  400005    0    5    0     0       0          0\n" +
+				" wlan0:      100       1    0    0    0     0          0         0     1000      10    0    0    0     0       0          0\n"
+			require.NoError(t, os.WriteFile(filepath.Join(procPath, "net", "dev"), []byte(next), 0o644))
+
+			m, err = p.Collect(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, m, len(tt.wantInterfaces)*len(netCounters))
+
+			for _, name := range tt.wantInterfaces {
+				_, found := findMetric(m, metrics.CounterStrName, "NetRxBytes_"+name)
+				assert.True(t, found, name)
+			}
+		})
+	}
+}
+
+func Test_netPlugin_Deltas(t *testing.T) {
+	procPath := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(procPath, "net"), 0o755))
+	data, err := os.ReadFile("testdata/proc/net/dev")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "net", "dev"), data, 0o644))
+
+	cfg := NewConfig()
+	cfg.ProcPath = procPath
+	p, err := NewPlugin(NetPluginName, time.Second, &cfg)
+	require.NoError(t, err)
+
+	_, err = p.Collect(context.Background())
+	require.NoError(t, err)
+
+	next := "  eth0:1048577000  900010    3    1    0     0          0        10 52429800  400005    0    5    0     0       0          0\n" +
+		" wlan0:      100       1    0    0    0     0          0         0     1000      10    0    0    0     0       0          0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "net", "dev"), []byte(next), 0o644))
+
+	m, err := p.Collect(context.Background())
+	require.NoError(t, err)
+
+	want := map[string]int64{
+		"NetRxBytes_eth0":   1000,
+		"NetRxPackets_eth0": 10,
+		"NetRxErrors_eth0":  1,
+		"NetRxDrops_eth0":   0,
+		"NetTxBytes_eth0":   1000,
+		"NetTxPackets_eth0": 5,
+		"NetTxErrors_eth0":  0,
+		"NetTxDrops_eth0":   2,
+		"NetRxBytes_wlan0":  100,
+		"NetTxBytes_wlan0":  0,
+	}
+	for id, value := range want {
+		metric, found := findMetric(m, metrics.CounterStrName, id)
+		require.True(t, found, id)
+		assert.Equal(t, value, metric.Int64Value(), id)
+	}
+}
@@ -0,0 +1,218 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+var (
+	ErrUnknownPlugin   = errors.New("unknown collector")
+	ErrInvalidInterval = errors.New("invalid collector interval")
+	ErrNotSupported    = errors.New("collector not supported on this platform")
+
+	ProcPathDefault = "/proc"
+
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+type (
+	// Plugin is a single source of metrics polled by the agent on its own interval.
+	// Gauges returned by Collect replace the previous values, counters are deltas
+	// since the previous call and are accumulated until they are reported. Close
+	// releases what the plugin keeps open between the calls, once it's no longer polled.
+	Plugin interface {
+		io.Closer
+		Name() string
+		Interval() time.Duration
+		Collect(ctx context.Context) ([]metrics.Metric, error)
+	}
+
+	Factory func(interval time.Duration, cfg *Config) (Plugin, error)
+
+	Config struct {
+		Enabled   []string      `env:"COLLECTORS" envSeparator:"," yaml:"enabled"`
+		Intervals Intervals     `env:"COLLECTOR_INTERVALS" yaml:"intervals"`
+		ProcPath  string        `env:"PROC_PATH" yaml:"proc_path"`
+		Disk      DiskConfig    `envPrefix:"DISK_" yaml:"disk"`
+		Net       NetConfig     `envPrefix:"NET_" yaml:"net"`
+		Process   ProcessConfig `envPrefix:"PROCESS_" yaml:"process"`
+		Cgroup    CgroupConfig  `envPrefix:"CGROUP_" yaml:"cgroup"`
+		Runtime   RuntimeConfig `envPrefix:"RUNTIME_" yaml:"runtime"`
+		Exec      ExecConfig    `envPrefix:"EXEC_" yaml:"exec"`
+		Logtail   LogtailConfig `envPrefix:"LOGTAIL_" yaml:"logtail"`
+	}
+
+	// Intervals overrides the poll interval of individual collectors, e.g. "cpu:10s,memory:5s".
+	Intervals map[string]time.Duration
+
+	plugin struct {
+		name     string
+		interval time.Duration
+	}
+)
+
+func (p *plugin) Name() string {
+	return p.name
+}
+
+func (p *plugin) Interval() time.Duration {
+	return p.interval
+}
+
+func (p *plugin) Close() error {
+	return nil
+}
+
+func NewConfig() Config {
+	return Config{
+		ProcPath: ProcPathDefault,
+		Runtime: RuntimeConfig{
+			AllSamples: RuntimeAllSamplesDefault,
+		},
+		Disk: DiskConfig{
+			DeviceExclude: DiskDeviceExcludeDefault,
+			FSTypeExclude: DiskFSTypeExcludeDefault,
+		},
+		Net: NetConfig{
+			InterfaceExclude: NetInterfaceExcludeDefault,
+		},
+		Cgroup: CgroupConfig{
+			Root: CgroupRootDefault,
+		},
+		Exec: ExecConfig{
+			Timeout: ExecTimeoutDefault,
+		},
+		Logtail: LogtailConfig{
+			StateFile: LogtailStateFileDefault,
+		},
+	}
+}
+
+func (i *Intervals) UnmarshalText(text []byte) error {
+	intervals := make(Intervals)
+
+	for _, item := range strings.Split(string(text), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(item, ":")
+		if !found {
+			return fmt.Errorf("Intervals_UnmarshalText: %w: %s", ErrInvalidInterval, item)
+		}
+
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("Intervals_UnmarshalText: %w: %s", ErrInvalidInterval, item)
+		}
+
+		intervals[strings.TrimSpace(name)] = interval
+	}
+
+	*i = intervals
+	return nil
+}
+
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exist := registry[name]; exist {
+		panic(fmt.Sprintf("collector: Register called twice for %s", name))
+	}
+
+	registry[name] = factory
+}
+
+func Plugins() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func NewPlugin(name string, interval time.Duration, cfg *Config) (Plugin, error) {
+	registryMu.RLock()
+	factory, exist := registry[name]
+	registryMu.RUnlock()
+
+	if !exist {
+		return nil, fmt.Errorf("NewPlugin: %w: %s", ErrUnknownPlugin, name)
+	}
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("NewPlugin: %w: %s", ErrInvalidInterval, name)
+	}
+
+	p, err := factory(interval, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("NewPlugin: %w", err)
+	}
+
+	return p, nil
+}
+
+// EnabledPlugins creates every collector listed in cfg.Enabled. Collectors without
+// an explicit interval are polled every defaultInterval.
+func EnabledPlugins(cfg *Config, defaultInterval time.Duration) ([]Plugin, error) {
+	registryMu.RLock()
+	for name := range cfg.Intervals {
+		if _, exist := registry[name]; !exist {
+			registryMu.RUnlock()
+			return nil, fmt.Errorf("EnabledPlugins: %w: %s", ErrUnknownPlugin, name)
+		}
+	}
+	registryMu.RUnlock()
+
+	plugins := make([]Plugin, 0, len(cfg.Enabled))
+	seen := make(map[string]struct{}, len(cfg.Enabled))
+
+	for _, name := range cfg.Enabled {
+		if _, exist := seen[name]; exist {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		interval, ok := cfg.Intervals[name]
+		if !ok {
+			interval = defaultInterval
+		}
+
+		p, err := NewPlugin(name, interval, cfg)
+		if err != nil {
+			for _, created := range plugins {
+				_ = created.Close()
+			}
+			return nil, fmt.Errorf("EnabledPlugins: %w", err)
+		}
+
+		plugins = append(plugins, p)
+	}
+
+	return plugins, nil
+}
+
+func (c *Config) IsEnabled(name string) bool {
+	for _, item := range c.Enabled {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntervals_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    Intervals
+		wantErr bool
+	}{
+		{
+			name: "valid intervals",
+			text: "cpu:10s, memory:1m",
+			want: Intervals{
+				"cpu":    10 * time.Second,
+				"memory": time.Minute,
+			},
+			wantErr: false,
+		},
+
+		{
+			name:    "missing separator",
+			text:    "cpu10s",
+			wantErr: true,
+		},
+
+		{
+			name:    "invalid duration",
+			text:    "cpu:invalid",
+			wantErr: true,
+		},
+
+		{
+			name:    "zero duration",
+			text:    "cpu:0s",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Intervals
+			err := got.UnmarshalText([]byte(tt.text))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEnabledPlugins(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           Config
+		wantIntervals map[string]time.Duration
+		wantErr       error
+	}{
+		{
+			name: "default interval",
+			cfg: Config{
+				Enabled: []string{RuntimePluginName, MemoryPluginName},
+			},
+			wantIntervals: map[string]time.Duration{
+				RuntimePluginName: 2 * time.Second,
+				MemoryPluginName:  2 * time.Second,
+			},
+		},
+
+		{
+			name: "custom interval",
+			cfg: Config{
+				Enabled:   []string{RuntimePluginName, CPUPluginName},
+				Intervals: Intervals{CPUPluginName: 10 * time.Second},
+			},
+			wantIntervals: map[string]time.Duration{
+				RuntimePluginName: 2 * time.Second,
+				CPUPluginName:     10 * time.Second,
+			},
+		},
+
+		{
+			name: "unknown collector",
+			cfg: Config{
+				Enabled: []string{"unknown"},
+			},
+			wantErr: ErrUnknownPlugin,
+		},
+
+		{
+			name: "interval for unknown collector",
+			cfg: Config{
+				Enabled:   []string{RuntimePluginName},
+				Intervals: Intervals{"unknown": time.Second},
+			},
+			wantErr: ErrUnknownPlugin,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugins, err := EnabledPlugins(&tt.cfg, 2*time.Second)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			got := make(map[string]time.Duration, len(plugins))
+			for _, p := range plugins {
+				got[p.Name()] = p.Interval()
+			}
+			assert.Equal(t, tt.wantIntervals, got)
+		})
+	}
+}
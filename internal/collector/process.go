@@ -0,0 +1,341 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const (
+	ProcessPluginName = "process"
+	// clockTicks is USER_HZ, the unit of the time fields in /proc/<pid>/stat.
+	clockTicks = 100
+)
+
+var ErrInvalidProcessTarget = errors.New("invalid process target")
+
+type (
+	ProcessConfig struct {
+		Targets ProcessTargets `env:"TARGETS" yaml:"targets"`
+	}
+
+	// ProcessTarget selects the processes reported under Name. Exactly one of
+	// PIDFile, Exe or Cmdline is set. Exe is the base name of the executable or of argv[0],
+	// not the comm which the kernel truncates to 15 bytes.
+	ProcessTarget struct {
+		Name    string `json:"name" yaml:"name"`
+		PIDFile string `json:"pid_file,omitempty" yaml:"pid_file"`
+		Exe     string `json:"exe,omitempty" yaml:"exe"`
+		Cmdline string `json:"cmdline,omitempty" yaml:"cmdline"`
+	}
+
+	// ProcessTargets is parsed from "name=kind:value" items separated by ";",
+	// where kind is one of pidfile, exe or cmdline,
+	// e.g. "nginx=exe:nginx;api=pidfile:/run/api.pid;worker=cmdline:worker\s+--queue".
+	ProcessTargets []ProcessTarget
+
+	processStat struct {
+		rss       uint64
+		cpuTicks  uint64
+		threads   uint64
+		startTime uint64
+		openFDs   int
+	}
+
+	processTarget struct {
+		ProcessTarget
+		cmdline *regexp.Regexp
+	}
+
+	processPlugin struct {
+		plugin
+		procPath string
+		targets  []processTarget
+	}
+)
+
+func init() {
+	Register(ProcessPluginName, newProcessPlugin)
+}
+
+func (t *ProcessTargets) UnmarshalText(text []byte) error {
+	var targets ProcessTargets
+
+	for _, item := range strings.Split(string(text), ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, selector, found := strings.Cut(item, "=")
+		if !found {
+			return fmt.Errorf("ProcessTargets_UnmarshalText: %w: %s", ErrInvalidProcessTarget, item)
+		}
+
+		kind, value, found := strings.Cut(selector, ":")
+		if !found {
+			return fmt.Errorf("ProcessTargets_UnmarshalText: %w: %s", ErrInvalidProcessTarget, item)
+		}
+
+		target := ProcessTarget{Name: strings.TrimSpace(name)}
+		switch kind {
+		case "pidfile":
+			target.PIDFile = value
+		case "exe":
+			target.Exe = value
+		case "cmdline":
+			target.Cmdline = value
+		default:
+			return fmt.Errorf("ProcessTargets_UnmarshalText: %w: %s", ErrInvalidProcessTarget, item)
+		}
+
+		targets = append(targets, target)
+	}
+
+	*t = targets
+	return nil
+}
+
+func (t ProcessTarget) Valid() error {
+	selectors := 0
+	for _, value := range []string{t.PIDFile, t.Exe, t.Cmdline} {
+		if value != "" {
+			selectors++
+		}
+	}
+
+	if t.Name == "" || selectors != 1 {
+		return fmt.Errorf("ProcessTarget_Valid: %w: %s", ErrInvalidProcessTarget, t.Name)
+	}
+
+	return nil
+}
+
+func newProcessPlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	targets := make([]processTarget, 0, len(cfg.Process.Targets))
+
+	for _, item := range cfg.Process.Targets {
+		if err := item.Valid(); err != nil {
+			return nil, fmt.Errorf("newProcessPlugin: %w", err)
+		}
+
+		target := processTarget{ProcessTarget: item}
+		if item.Cmdline != "" {
+			re, err := regexp.Compile(item.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("newProcessPlugin: %w: %s: %v", ErrInvalidProcessTarget, item.Name, err)
+			}
+			target.cmdline = re
+		}
+
+		targets = append(targets, target)
+	}
+
+	return &processPlugin{
+		plugin: plugin{
+			name:     ProcessPluginName,
+			interval: interval,
+		},
+		procPath: cfg.ProcPath,
+		targets:  targets,
+	}, nil
+}
+
+// Collect resolves the target processes on every poll, so a restarted process is
+// picked up under its new PID. Values of several matching processes are summed.
+func (p *processPlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	uptime, err := p.systemUptime()
+	if err != nil {
+		return nil, fmt.Errorf("processPlugin_Collect: %w", err)
+	}
+
+	pids, err := p.listPIDs()
+	if err != nil {
+		return nil, fmt.Errorf("processPlugin_Collect: %w", err)
+	}
+
+	var collected []metrics.Metric
+	pageSize := uint64(os.Getpagesize())
+
+	for _, target := range p.targets {
+		var (
+			total      processStat
+			count      int
+			oldestTime uint64
+		)
+
+		for _, pid := range p.resolve(target, pids) {
+			stat, err := p.readProcess(pid)
+			if err != nil {
+				// the process exited between listing and reading
+				continue
+			}
+
+			count++
+			total.rss += stat.rss
+			total.cpuTicks += stat.cpuTicks
+			total.threads += stat.threads
+			total.openFDs += stat.openFDs
+			if oldestTime == 0 || stat.startTime < oldestTime {
+				oldestTime = stat.startTime
+			}
+		}
+
+		collected = append(collected, Gauge(count).Metric(labelName("ProcessCount", target.Name)))
+		if count == 0 {
+			continue
+		}
+
+		collected = append(collected,
+			Gauge(total.rss*pageSize).Metric(labelName("ProcessRSS", target.Name)),
+			Gauge(float64(total.cpuTicks)/clockTicks).Metric(labelName("ProcessCPUSeconds", target.Name)),
+			Gauge(total.openFDs).Metric(labelName("ProcessOpenFDs", target.Name)),
+			Gauge(total.threads).Metric(labelName("ProcessThreads", target.Name)),
+			Gauge(uptime-float64(oldestTime)/clockTicks).Metric(labelName("ProcessUptime", target.Name)),
+		)
+	}
+
+	return collected, nil
+}
+
+func (p *processPlugin) resolve(target processTarget, pids []int) []int {
+	if target.PIDFile != "" {
+		data, err := os.ReadFile(target.PIDFile)
+		if err != nil {
+			return nil
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil
+		}
+
+		return []int{pid}
+	}
+
+	var matched []int
+	for _, pid := range pids {
+		if target.Exe != "" {
+			for _, name := range p.exeNames(pid) {
+				if name == target.Exe {
+					matched = append(matched, pid)
+					break
+				}
+			}
+			continue
+		}
+
+		cmdline, err := os.ReadFile(procFile(p.procPath, strconv.Itoa(pid), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+
+		args := strings.TrimRight(strings.ReplaceAll(string(cmdline), "\x00", " "), " ")
+		if target.cmdline.MatchString(args) {
+			matched = append(matched, pid)
+		}
+	}
+
+	return matched
+}
+
+// exeNames are the names an exe target matches: the base names of the executable and of argv[0].
+// comm is only the fallback as the kernel truncates it to 15 bytes.
+func (p *processPlugin) exeNames(pid int) []string {
+	var names []string
+	if exe, err := os.Readlink(procFile(p.procPath, strconv.Itoa(pid), "exe")); err == nil {
+		names = append(names, filepath.Base(strings.TrimSuffix(exe, " (deleted)")))
+	}
+
+	if cmdline, err := os.ReadFile(procFile(p.procPath, strconv.Itoa(pid), "cmdline")); err == nil && len(cmdline) != 0 {
+		argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+		names = append(names, filepath.Base(argv0))
+	}
+
+	if len(names) == 0 {
+		if comm, err := os.ReadFile(procFile(p.procPath, strconv.Itoa(pid), "comm")); err == nil {
+			names = append(names, strings.TrimSpace(string(comm)))
+		}
+	}
+
+	return names
+}
+
+func (p *processPlugin) listPIDs() ([]int, error) {
+	entries, err := os.ReadDir(procFile(p.procPath))
+	if err != nil {
+		return nil, fmt.Errorf("listPIDs: %w", err)
+	}
+
+	pids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+func (p *processPlugin) readProcess(pid int) (processStat, error) {
+	data, err := os.ReadFile(procFile(p.procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return processStat{}, fmt.Errorf("readProcess: %w", err)
+	}
+
+	// comm may contain spaces and parentheses, the fields start after the last ")"
+	index := strings.LastIndexByte(string(data), ')')
+	if index < 0 {
+		return processStat{}, fmt.Errorf("readProcess: unexpected format of %d/stat", pid)
+	}
+
+	// fields[0] is field 3 (state) of proc(5)
+	fields := strings.Fields(string(data[index+1:]))
+	if len(fields) < 22 {
+		return processStat{}, fmt.Errorf("readProcess: unexpected format of %d/stat", pid)
+	}
+
+	stat := processStat{
+		cpuTicks:  parseUint(fields, 11) + parseUint(fields, 12),
+		threads:   parseUint(fields, 17),
+		startTime: parseUint(fields, 19),
+		rss:       parseUint(fields, 21),
+	}
+
+	fds, err := os.ReadDir(procFile(p.procPath, strconv.Itoa(pid), "fd"))
+	if err == nil {
+		stat.openFDs = len(fds)
+	}
+
+	return stat, nil
+}
+
+func (p *processPlugin) systemUptime() (float64, error) {
+	data, err := os.ReadFile(procFile(p.procPath, "uptime"))
+	if err != nil {
+		return 0, fmt.Errorf("systemUptime: %w", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("systemUptime: unexpected format")
+	}
+
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("systemUptime: %w", err)
+	}
+
+	return uptime, nil
+}
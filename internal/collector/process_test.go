@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func TestProcessTargets_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    ProcessTargets
+		wantErr bool
+	}{
+		{
+			name: "valid targets",
+			text: `nginx=exe:nginx; api=pidfile:/run/api.pid;worker=cmdline:worker\s+--queue`,
+			want: ProcessTargets{
+				{Name: "nginx", Exe: "nginx"},
+				{Name: "api", PIDFile: "/run/api.pid"},
+				{Name: "worker", Cmdline: `worker\s+--queue`},
+			},
+		},
+
+		{
+			name:    "missing name",
+			text:    "exe:nginx",
+			wantErr: true,
+		},
+
+		{
+			name:    "unknown kind",
+			text:    "nginx=name:nginx",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ProcessTargets
+			err := got.UnmarshalText([]byte(tt.text))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_newProcessPlugin(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets ProcessTargets
+	}{
+		{
+			name:    "invalid regexp",
+			targets: ProcessTargets{{Name: "app", Cmdline: "app("}},
+		},
+
+		{
+			name:    "several selectors",
+			targets: ProcessTargets{{Name: "app", Exe: "app", PIDFile: "/run/app.pid"}},
+		},
+
+		{
+			name:    "missing name",
+			targets: ProcessTargets{{Exe: "app"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Process.Targets = tt.targets
+			_, err := NewPlugin(ProcessPluginName, time.Second, &cfg)
+			assert.ErrorIs(t, err, ErrInvalidProcessTarget)
+		})
+	}
+}
+
+func Test_processPlugin_Collect(t *testing.T) {
+	pageSize := float64(os.Getpagesize())
+	cfg := NewConfig()
+	cfg.ProcPath = "testdata/proc"
+	cfg.Process.Targets = ProcessTargets{
+		{Name: "nginx", Exe: "nginx"},
+		{Name: "app", PIDFile: "testdata/app.pid"},
+		{Name: "queue", Cmdline: `--queue\s+default`},
+		{Name: "missing", Exe: "missing"},
+	}
+	p, err := NewPlugin(ProcessPluginName, time.Second, &cfg)
+	require.NoError(t, err)
+
+	m, err := p.Collect(context.Background())
+	require.NoError(t, err)
+
+	want := map[string]float64{
+		"ProcessCount_nginx":      2,
+		"ProcessRSS_nginx":        3072 * pageSize,
+		"ProcessCPUSeconds_nginx": 4,
+		"ProcessOpenFDs_nginx":    5,
+		"ProcessThreads_nginx":    3,
+		"ProcessUptime_nginx":     900,
+		"ProcessCount_app":        1,
+		"ProcessRSS_app":          4096 * pageSize,
+		"ProcessThreads_app":      8,
+		"ProcessUptime_app":       950,
+		"ProcessCount_queue":      1,
+		"ProcessOpenFDs_queue":    4,
+		"ProcessCount_missing":    0,
+	}
+	for id, value := range want {
+		metric, found := findMetric(m, metrics.GaugeStrName, id)
+		require.True(t, found, id)
+		assert.Equal(t, value, metric.Float64Value(), id)
+	}
+
+	_, found := findMetric(m, metrics.GaugeStrName, "ProcessRSS_missing")
+	assert.False(t, found)
+}
+
+func Test_processPlugin_resolveExe(t *testing.T) {
+	p := &processPlugin{procPath: "testdata/proc"}
+	pids, err := p.listPIDs()
+	require.NoError(t, err)
+
+	// the comm of 2468 is truncated to "metrics-exporte" and its executable is deleted
+	target := processTarget{ProcessTarget: ProcessTarget{Name: "exporter", Exe: "metrics-exporter-daemon"}}
+	assert.Equal(t, []int{2468}, p.resolve(target, pids))
+
+	target.Exe = "metrics-exporte"
+	assert.Empty(t, p.resolve(target, pids), "the truncated comm doesn't match")
+}
@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func procFile(procPath string, elem ...string) string {
+	if procPath == "" {
+		procPath = ProcPathDefault
+	}
+	return filepath.Join(append([]string{procPath}, elem...)...)
+}
+
+// readFields reads a proc file and returns the whitespace separated fields of each non-empty line.
+func readFields(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("readFields: %w", err)
+	}
+	defer file.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		lines = append(lines, fields)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("readFields: %w", err)
+	}
+
+	return lines, nil
+}
+
+func parseUint(fields []string, index int) uint64 {
+	if index >= len(fields) {
+		return 0
+	}
+	n, _ := strconv.ParseUint(fields[index], 10, 64)
+	return n
+}
+
+// unescapeOctal decodes the \NNN escapes used by the kernel for spaces and
+// other special characters in /proc/mounts.
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// counterDelta returns the increase of a monotonic kernel counter between two polls.
+// A counter that went backwards was reset, so its current value is the increase.
+func counterDelta(previous, current uint64) Counter {
+	if current < previous {
+		return Counter(current)
+	}
+	return Counter(current - previous)
+}
@@ -0,0 +1,265 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"runtime"
+	"runtime/debug"
+	runtimemetrics "runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const RuntimePluginName = "runtime"
+
+type (
+	RuntimeConfig struct {
+		// AllSamples exposes every runtime/metrics sample in addition to the MemStats names.
+		AllSamples bool `env:"ALL_SAMPLES" yaml:"all_samples"`
+	}
+
+	runtimePlugin struct {
+		plugin
+		allSamples bool
+		samples    []runtimemetrics.Sample
+		index      map[string]int
+		histograms map[string][]uint64
+	}
+)
+
+var (
+	RuntimeAllSamplesDefault = true
+
+	// memStatsNames maps the historical runtime.MemStats field names reported by the
+	// agent to the sum of runtime/metrics samples that replaces them.
+	memStatsNames = map[string][]string{
+		"Alloc":        {"/memory/classes/heap/objects:bytes"},
+		"BuckHashSys":  {"/memory/classes/profiling/buckets:bytes"},
+		"Frees":        {"/gc/heap/frees:objects", "/gc/heap/tiny/allocs:objects"},
+		"GCSys":        {"/memory/classes/metadata/other:bytes"},
+		"HeapAlloc":    {"/memory/classes/heap/objects:bytes"},
+		"HeapIdle":     {"/memory/classes/heap/released:bytes", "/memory/classes/heap/free:bytes"},
+		"HeapInuse":    {"/memory/classes/heap/objects:bytes", "/memory/classes/heap/unused:bytes"},
+		"HeapObjects":  {"/gc/heap/objects:objects"},
+		"HeapReleased": {"/memory/classes/heap/released:bytes"},
+		"HeapSys": {
+			"/memory/classes/heap/objects:bytes", "/memory/classes/heap/unused:bytes",
+			"/memory/classes/heap/released:bytes", "/memory/classes/heap/free:bytes",
+		},
+		"MCacheInuse": {"/memory/classes/metadata/mcache/inuse:bytes"},
+		"MCacheSys":   {"/memory/classes/metadata/mcache/inuse:bytes", "/memory/classes/metadata/mcache/free:bytes"},
+		"MSpanInuse":  {"/memory/classes/metadata/mspan/inuse:bytes"},
+		"MSpanSys":    {"/memory/classes/metadata/mspan/inuse:bytes", "/memory/classes/metadata/mspan/free:bytes"},
+		"Mallocs":     {"/gc/heap/allocs:objects", "/gc/heap/tiny/allocs:objects"},
+		"NextGC":      {"/gc/heap/goal:bytes"},
+		"NumForcedGC": {"/gc/cycles/forced:gc-cycles"},
+		"NumGC":       {"/gc/cycles/total:gc-cycles"},
+		"OtherSys":    {"/memory/classes/other:bytes"},
+		"StackInuse":  {"/memory/classes/heap/stacks:bytes"},
+		"StackSys":    {"/memory/classes/heap/stacks:bytes", "/memory/classes/os-stacks:bytes"},
+		"Sys":         {"/memory/classes/total:bytes"},
+		"TotalAlloc":  {"/gc/heap/allocs:bytes"},
+	}
+
+	histogramQuantiles = []struct {
+		suffix   string
+		quantile float64
+	}{
+		{"_p50", 0.5},
+		{"_p90", 0.9},
+		{"_p99", 0.99},
+	}
+)
+
+func init() {
+	Register(RuntimePluginName, newRuntimePlugin)
+}
+
+func newRuntimePlugin(interval time.Duration, cfg *Config) (Plugin, error) {
+	descriptions := runtimemetrics.All()
+	samples := make([]runtimemetrics.Sample, 0, len(descriptions))
+	index := make(map[string]int, len(descriptions))
+
+	for _, description := range descriptions {
+		// per-setting GODEBUG counters are not runtime health metrics
+		if strings.HasPrefix(description.Name, "/godebug/") || description.Kind == runtimemetrics.KindBad {
+			continue
+		}
+		index[description.Name] = len(samples)
+		samples = append(samples, runtimemetrics.Sample{Name: description.Name})
+	}
+
+	return &runtimePlugin{
+		plugin: plugin{
+			name:     RuntimePluginName,
+			interval: interval,
+		},
+		allSamples: cfg.Runtime.AllSamples,
+		samples:    samples,
+		index:      index,
+		histograms: make(map[string][]uint64),
+	}, nil
+}
+
+// Collect reads the runtime/metrics samples, which unlike runtime.ReadMemStats
+// does not stop the world.
+func (p *runtimePlugin) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	_ = ctx
+	runtimemetrics.Read(p.samples)
+
+	collected := make([]metrics.Metric, 0, len(memStatsNames)+len(p.samples)+6)
+
+	for id, names := range memStatsNames {
+		var value float64
+		for _, name := range names {
+			value += p.value(name)
+		}
+		collected = append(collected, Gauge(value).Metric(id))
+	}
+
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+
+	collected = append(collected,
+		Gauge(gcStats.LastGC.UnixNano()).Metric("LastGC"),
+		Gauge(gcStats.PauseTotal.Nanoseconds()).Metric("PauseTotalNs"),
+		Gauge(p.gcCPUFraction()).Metric("GCCPUFraction"),
+		// MemStats.Lookups has always been zero and has no runtime/metrics counterpart
+		Gauge(0).Metric("Lookups"),
+		Counter(1).Metric("PollCount"),
+		Gauge(rand.Float64()).Metric("RandomValue"),
+	)
+
+	if p.allSamples {
+		collected = append(collected, p.exposeSamples()...)
+	}
+
+	return collected, nil
+}
+
+func (p *runtimePlugin) exposeSamples() []metrics.Metric {
+	collected := make([]metrics.Metric, 0, len(p.samples))
+
+	for _, sample := range p.samples {
+		id := sampleName(sample.Name)
+
+		switch sample.Value.Kind() {
+		case runtimemetrics.KindUint64:
+			collected = append(collected, Gauge(sample.Value.Uint64()).Metric(id))
+		case runtimemetrics.KindFloat64:
+			collected = append(collected, Gauge(sample.Value.Float64()).Metric(id))
+		case runtimemetrics.KindFloat64Histogram:
+			collected = append(collected, p.histogramQuantiles(id, sample.Value.Float64Histogram())...)
+		}
+	}
+
+	return collected
+}
+
+// histogramQuantiles reports quantiles of the observations made since the previous
+// poll, the runtime histograms themselves are cumulative since the process start.
+func (p *runtimePlugin) histogramQuantiles(id string, h *runtimemetrics.Float64Histogram) []metrics.Metric {
+	previous := p.histograms[id]
+	counts := make([]uint64, len(h.Counts))
+	copy(counts, h.Counts)
+	p.histograms[id] = counts
+
+	var total uint64
+	delta := make([]uint64, len(counts))
+	for i := range counts {
+		if len(previous) == len(counts) && counts[i] >= previous[i] {
+			delta[i] = counts[i] - previous[i]
+		} else {
+			delta[i] = counts[i]
+		}
+		total += delta[i]
+	}
+
+	collected := make([]metrics.Metric, 0, len(histogramQuantiles))
+	for _, q := range histogramQuantiles {
+		collected = append(collected, Gauge(quantile(h.Buckets, delta, total, q.quantile)).Metric(id+q.suffix))
+	}
+
+	return collected
+}
+
+// quantile estimates the q-quantile of a histogram as the upper bound of the bucket holding it.
+func quantile(buckets []float64, counts []uint64, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cumulative uint64
+	for i, count := range counts {
+		cumulative += count
+		if cumulative < rank {
+			continue
+		}
+
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		if lower := buckets[i]; !math.IsInf(lower, -1) {
+			return lower
+		}
+		return 0
+	}
+
+	return 0
+}
+
+func (p *runtimePlugin) value(name string) float64 {
+	i, exist := p.index[name]
+	if !exist {
+		return 0
+	}
+
+	value := p.samples[i].Value
+	switch value.Kind() {
+	case runtimemetrics.KindUint64:
+		return float64(value.Uint64())
+	case runtimemetrics.KindFloat64:
+		return value.Float64()
+	default:
+		return 0
+	}
+}
+
+// gcCPUFraction is read from the /cpu/classes/ samples, which Go 1.20 added. Older runtimes
+// report them as KindBad and the fraction comes from runtime.ReadMemStats instead.
+func (p *runtimePlugin) gcCPUFraction() float64 {
+	if !p.sampled("/cpu/classes/total:cpu-seconds") || !p.sampled("/cpu/classes/gc/total:cpu-seconds") {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return stats.GCCPUFraction
+	}
+
+	total := p.value("/cpu/classes/total:cpu-seconds")
+	if total == 0 {
+		return 0
+	}
+	return p.value("/cpu/classes/gc/total:cpu-seconds") / total
+}
+
+// sampled reports whether the runtime supports the sample.
+func (p *runtimePlugin) sampled(name string) bool {
+	i, exist := p.index[name]
+	return exist && p.samples[i].Value.Kind() != runtimemetrics.KindBad
+}
+
+// sampleName converts a runtime/metrics key such as "/gc/heap/allocs:bytes"
+// into a metric ID like "go_gc_heap_allocs_bytes".
+func sampleName(name string) string {
+	return "go_" + strings.Map(func(r rune) rune {
+		switch r {
+		case '/', ':', '-':
+			return '_'
+		default:
+			return r
+		}
+	}, strings.TrimPrefix(name, "/"))
+}
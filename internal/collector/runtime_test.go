@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_runtimePlugin_Collect(t *testing.T) {
+	tests := []struct {
+		name       string
+		allSamples bool
+	}{
+		{
+			name:       "memstats names",
+			allSamples: false,
+		},
+
+		{
+			name:       "all samples",
+			allSamples: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Runtime.AllSamples = tt.allSamples
+			p, err := NewPlugin(RuntimePluginName, time.Second, &cfg)
+			require.NoError(t, err)
+
+			m, err := p.Collect(context.Background())
+			require.NoError(t, err)
+
+			pollCount, found := findMetric(m, metrics.CounterStrName, "PollCount")
+			assert.True(t, found)
+			assert.Equal(t, int64(1), pollCount.Int64Value())
+
+			for _, id := range []string{"RandomValue", "LastGC", "Lookups", "GCCPUFraction", "PauseTotalNs"} {
+				_, found = findMetric(m, metrics.GaugeStrName, id)
+				assert.True(t, found, id)
+			}
+
+			for id := range memStatsNames {
+				_, found = findMetric(m, metrics.GaugeStrName, id)
+				assert.True(t, found, id)
+			}
+
+			for _, id := range []string{"HeapAlloc", "Sys", "TotalAlloc", "OtherSys"} {
+				metric, _ := findMetric(m, metrics.GaugeStrName, id)
+				assert.NotZero(t, metric.Float64Value(), id)
+			}
+
+			_, found = findMetric(m, metrics.GaugeStrName, "go_sched_latencies_seconds_p99")
+			assert.Equal(t, tt.allSamples, found)
+			_, found = findMetric(m, metrics.GaugeStrName, "go_memory_classes_total_bytes")
+			assert.Equal(t, tt.allSamples, found)
+		})
+	}
+}
+
+func Test_runtimePlugin_gcCPUFraction(t *testing.T) {
+	cfg := NewConfig()
+	p, err := NewPlugin(RuntimePluginName, time.Second, &cfg)
+	require.NoError(t, err)
+	runtimePlugin := p.(*runtimePlugin)
+
+	// runtimes before Go 1.20 have no /cpu/classes/ samples
+	runtimePlugin.samples[runtimePlugin.index["/cpu/classes/total:cpu-seconds"]].Name = "/cpu/classes/missing:cpu-seconds"
+	runtime.GC()
+
+	m, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	fraction, found := findMetric(m, metrics.GaugeStrName, "GCCPUFraction")
+	require.True(t, found)
+	assert.NotZero(t, fraction.Float64Value(), "falls back to runtime.ReadMemStats")
+}
+
+func Test_quantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 5, math.Inf(1)}
+	tests := []struct {
+		name   string
+		counts []uint64
+		q      float64
+		want   float64
+	}{
+		{
+			name:   "empty histogram",
+			counts: []uint64{0, 0, 0, 0},
+			q:      0.5,
+			want:   0,
+		},
+
+		{
+			name:   "median",
+			counts: []uint64{0, 5, 4, 1},
+			q:      0.5,
+			want:   2,
+		},
+
+		{
+			name:   "p90",
+			counts: []uint64{0, 5, 4, 1},
+			q:      0.9,
+			want:   5,
+		},
+
+		{
+			name:   "overflow bucket",
+			counts: []uint64{0, 1, 0, 1},
+			q:      0.99,
+			want:   5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total uint64
+			for _, count := range tt.counts {
+				total += count
+			}
+			assert.Equal(t, tt.want, quantile(buckets, tt.counts, total, tt.q))
+		})
+	}
+}
+
+func Test_sampleName(t *testing.T) {
+	assert.Equal(t, "go_gc_heap_allocs_bytes", sampleName("/gc/heap/allocs:bytes"))
+	assert.Equal(t, "go_memory_classes_os_stacks_bytes", sampleName("/memory/classes/os-stacks:bytes"))
+}
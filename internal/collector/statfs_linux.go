@@ -0,0 +1,24 @@
+//go:build linux
+
+package collector
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func statfs(path string) (fsStat, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return fsStat{}, fmt.Errorf("statfs: %w", err)
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return fsStat{
+		total:     stat.Blocks * blockSize,
+		free:      stat.Bfree * blockSize,
+		available: stat.Bavail * blockSize,
+		files:     stat.Files,
+		filesFree: stat.Ffree,
+	}, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package collector
+
+func statfs(path string) (fsStat, error) {
+	_ = path
+	return fsStat{}, ErrNotSupported
+}
@@ -0,0 +1,58 @@
+// Package config loads the configuration file shared by the server and the agent.
+//
+// Settings are applied in the order of precedence: defaults < config file <
+// environment variables < command line flags, so a later source overrides an earlier one.
+// The file is given by the -c flag or the CONFIG environment variable, JSON and YAML are
+// both accepted with the same keys.
+//
+// On SIGHUP the server and the agent load the configuration again from the same sources and
+// apply the changes live. Changes of settings bound at startup, such as listen addresses or
+// the database DSN, are rejected and the running configuration is kept.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPath is the environment variable with the config file path.
+const EnvPath = "CONFIG"
+
+var ErrInvalidFile = errors.New("invalid config file")
+
+// Path returns the config file given by the flag, or by the environment when the flag is empty.
+func Path(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	return os.Getenv(EnvPath)
+}
+
+// Load decodes the file at path into v, keeping the values of v that the file doesn't set.
+// An empty path is a no-op. Unknown keys are rejected so typos don't go unnoticed.
+func Load(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Load: %w", err)
+	}
+
+	// YAML is a superset of JSON, so a single decoder reads both formats
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	if err = decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("Load: %w: %s: %v", ErrInvalidFile, path, err)
+	}
+
+	return nil
+}
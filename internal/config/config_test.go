@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Address  string        `yaml:"address"`
+	Interval time.Duration `yaml:"interval"`
+	Restore  bool          `yaml:"restore"`
+	Nested   struct {
+		Targets []string `yaml:"targets"`
+	} `yaml:"nested"`
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "config.yaml",
+			content: "address: 10.0.0.1:8080\ninterval: 30s\nnested:\n  targets: [a, b]\n",
+		},
+
+		{
+			name:    "config.json",
+			content: `{"address": "10.0.0.1:8080", "interval": "30s", "nested": {"targets": ["a", "b"]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig{Address: "127.0.0.1:8080", Restore: true}
+			require.NoError(t, Load(writeFile(t, tt.name, tt.content), &cfg))
+
+			assert.Equal(t, "10.0.0.1:8080", cfg.Address)
+			assert.Equal(t, 30*time.Second, cfg.Interval)
+			assert.True(t, cfg.Restore, "values missing in the file keep their defaults")
+			assert.Equal(t, []string{"a", "b"}, cfg.Nested.Targets)
+		})
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		field   string
+	}{
+		{
+			name:    "unknown field",
+			content: "adress: 10.0.0.1:8080\n",
+			field:   "adress",
+		},
+
+		{
+			name:    "invalid duration",
+			content: "interval: often\n",
+			field:   "often",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg testConfig
+			err := Load(writeFile(t, "config.yaml", tt.content), &cfg)
+			assert.ErrorIs(t, err, ErrInvalidFile)
+			assert.ErrorContains(t, err, tt.field)
+		})
+	}
+}
+
+func TestLoad_Empty(t *testing.T) {
+	cfg := testConfig{Address: "127.0.0.1:8080"}
+	require.NoError(t, Load("", &cfg))
+	require.NoError(t, Load(writeFile(t, "config.yaml", ""), &cfg))
+	assert.Equal(t, "127.0.0.1:8080", cfg.Address)
+
+	assert.Error(t, Load(filepath.Join(t.TempDir(), "missing.yaml"), &cfg))
+}
+
+func TestPath(t *testing.T) {
+	t.Setenv(EnvPath, "/etc/yametrics/env.yaml")
+	assert.Equal(t, "/etc/yametrics/flag.yaml", Path("/etc/yametrics/flag.yaml"))
+	assert.Equal(t, "/etc/yametrics/env.yaml", Path(""))
+}
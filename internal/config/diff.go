@@ -0,0 +1,51 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Diff returns the keys of the fields that differ between two configurations of the same
+// struct type, nested keys are joined with dots, e.g. "scrape.interval". Keys are taken from
+// the yaml tags, fields without a tag are compared under their lowercase name and
+// unexported fields are skipped.
+func Diff(previous, current any) []string {
+	return diff(reflect.ValueOf(previous), reflect.ValueOf(current), "")
+}
+
+func diff(previous, current reflect.Value, prefix string) []string {
+	for previous.Kind() == reflect.Pointer {
+		previous, current = previous.Elem(), current.Elem()
+	}
+
+	var changed []string
+	t := previous.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		key := strings.ToLower(field.Name)
+		if tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ","); tag != "" {
+			key = tag
+		}
+		if key == "-" {
+			continue
+		}
+		key = prefix + key
+
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			changed = append(changed, diff(previous.Field(i), current.Field(i), key+".")...)
+			continue
+		}
+
+		if !reflect.DeepEqual(previous.Field(i).Interface(), current.Field(i).Interface()) {
+			changed = append(changed, key)
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	type nested struct {
+		Targets []string `yaml:"targets"`
+		Enabled bool
+	}
+	type testConfig struct {
+		Address  string        `yaml:"address"`
+		Interval time.Duration `yaml:"interval,omitempty"`
+		Ignored  string        `yaml:"-"`
+		internal int
+		Nested   nested `yaml:"nested"`
+	}
+
+	previous := testConfig{Address: "127.0.0.1:8080", Interval: time.Second, Nested: nested{Targets: []string{"a"}}}
+
+	current := previous
+	assert.Empty(t, Diff(&previous, &current))
+
+	current.Interval = time.Minute
+	current.Ignored = "changed"
+	current.internal = 1
+	current.Nested = nested{Targets: []string{"a", "b"}, Enabled: true}
+	assert.Equal(t, []string{"interval", "nested.targets", "nested.enabled"}, Diff(&previous, &current))
+}
@@ -0,0 +1,75 @@
+// Package logging filters the messages of the standard logger by level. The level is
+// process wide, the server and the agent set it from their configuration and again on SIGHUP.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	LevelDebug Level = "debug"
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+var (
+	ErrInvalidLevel = errors.New("invalid log level")
+
+	levels = map[Level]int32{LevelDebug: -1, LevelInfo: 0, LevelWarn: 1, LevelError: 2}
+	// current is the rank of the level, the zero value is info
+	current int32
+)
+
+type Level string
+
+func (l Level) Valid() error {
+	if _, exist := levels[l]; !exist {
+		return fmt.Errorf("%w: %s", ErrInvalidLevel, l)
+	}
+
+	return nil
+}
+
+// SetLevel drops the messages below the level from now on.
+func SetLevel(l Level) error {
+	if err := l.Valid(); err != nil {
+		return fmt.Errorf("SetLevel: %w", err)
+	}
+
+	atomic.StoreInt32(&current, levels[l])
+	return nil
+}
+
+// Enabled reports whether the messages of the level are logged.
+func Enabled(l Level) bool {
+	return levels[l] >= atomic.LoadInt32(&current)
+}
+
+func Debugf(format string, v ...any) {
+	logf(LevelDebug, format, v...)
+}
+
+func Infof(format string, v ...any) {
+	logf(LevelInfo, format, v...)
+}
+
+func Warnf(format string, v ...any) {
+	logf(LevelWarn, format, v...)
+}
+
+func Errorf(format string, v ...any) {
+	logf(LevelError, format, v...)
+}
+
+func logf(l Level, format string, v ...any) {
+	if !Enabled(l) {
+		return
+	}
+
+	// the depth skips logf and the exported wrapper, so Lshortfile names the caller
+	_ = log.Output(3, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLevel(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		_ = SetLevel(LevelInfo)
+	})
+
+	Debugf("debug %d", 1)
+	Infof("info %d", 1)
+	require.NoError(t, SetLevel(LevelWarn))
+	Infof("info %d", 2)
+	Warnf("warn %d", 1)
+	Errorf("error %d", 1)
+	require.NoError(t, SetLevel(LevelDebug))
+	Debugf("debug %d", 2)
+
+	assert.Equal(t, "info 1\nwarn 1\nerror 1\ndebug 2\n", out.String())
+
+	assert.ErrorIs(t, SetLevel("verbose"), ErrInvalidLevel)
+	assert.True(t, Enabled(LevelDebug), "an invalid level keeps the current one")
+}
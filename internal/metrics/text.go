@@ -0,0 +1,336 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	PromCounter   = "counter"
+	PromGauge     = "gauge"
+	PromHistogram = "histogram"
+	PromSummary   = "summary"
+	PromUntyped   = "untyped"
+)
+
+var ErrInvalidTextFormat = errors.New("invalid text format")
+
+type (
+	Label struct {
+		Name  string
+		Value string
+	}
+
+	// Sample is a single series value of the Prometheus text exposition format.
+	Sample struct {
+		Name   string
+		Labels []Label
+		Type   string
+		Value  float64
+	}
+)
+
+// ParsePrometheusText parses the Prometheus text exposition format. The type of
+// each sample is taken from the "# TYPE" line of its family, histogram and summary
+// series (_bucket, _sum, _count) get the type of their family.
+func ParsePrometheusText(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		sample, err := parseSampleLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("ParsePrometheusText: line %d: %w", lineNumber, err)
+		}
+
+		sample.Type = sampleType(types, sample.Name)
+		samples = append(samples, sample)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ParsePrometheusText: %w", err)
+	}
+
+	return samples, nil
+}
+
+func sampleType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if t, ok := types[strings.TrimSuffix(name, suffix)]; ok && (t == PromHistogram || t == PromSummary) {
+			return t
+		}
+	}
+
+	return PromUntyped
+}
+
+func parseSampleLine(line string) (Sample, error) {
+	var sample Sample
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return sample, fmt.Errorf("%w: %q", ErrInvalidTextFormat, line)
+	}
+	sample.Name = line[:end]
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, tail, err := parseLabels(rest[1:])
+		if err != nil {
+			return sample, fmt.Errorf("%w: %q", err, line)
+		}
+		sample.Labels = labels
+		rest = tail
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("%w: %q", ErrInvalidTextFormat, line)
+	}
+
+	value, err := parsePromFloat(fields[0])
+	if err != nil {
+		return sample, fmt.Errorf("%w: %q", ErrInvalidTextFormat, line)
+	}
+	sample.Value = value
+
+	return sample, nil
+}
+
+// parseLabels parses `name="value",...}` and returns the labels sorted by name
+// and the rest of the line after the closing brace.
+func parseLabels(s string) ([]Label, string, error) {
+	var labels []Label
+
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			break
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, "", ErrInvalidTextFormat
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s):
+				i++
+				if s[i] == 'n' {
+					value.WriteByte('\n')
+				} else {
+					value.WriteByte(s[i])
+				}
+			case s[i] == '"':
+				s = s[i+1:]
+				closed = true
+			default:
+				value.WriteByte(s[i])
+			}
+			if closed {
+				break
+			}
+		}
+		if !closed {
+			return nil, "", ErrInvalidTextFormat
+		}
+
+		labels = append(labels, Label{Name: name, Value: value.String()})
+
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	return labels, s[1:], nil
+}
+
+func parsePromFloat(s string) (float64, error) {
+	switch s {
+	case "+Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	default:
+		return strconv.ParseFloat(s, 64)
+	}
+}
+
+// ID flattens the sample name and labels into a metric ID,
+// e.g. `http_requests_total{code="200"}` becomes "http_requests_total_code_200".
+func (s Sample) ID() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+
+	var b strings.Builder
+	b.WriteString(s.Name)
+	for _, label := range s.Labels {
+		b.WriteByte('_')
+		b.WriteString(sanitizeID(label.Name))
+		b.WriteByte('_')
+		b.WriteString(sanitizeID(label.Value))
+	}
+
+	return b.String()
+}
+
+// IsCumulative reports whether the sample is a monotonic counter, summary
+// quantiles are gauges even though they belong to a summary family.
+func (s Sample) IsCumulative() bool {
+	switch s.Type {
+	case PromCounter, PromHistogram:
+		return true
+	case PromSummary:
+		return strings.HasSuffix(s.Name, "_sum") || strings.HasSuffix(s.Name, "_count")
+	default:
+		return false
+	}
+}
+
+func sanitizeID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// SampleConverter turns text format samples into metrics. Cumulative counters are
+// converted into increments since the previous conversion, so the first sample of
+// a series only records its baseline.
+type SampleConverter struct {
+	previous map[string]float64
+}
+
+func NewSampleConverter() *SampleConverter {
+	return &SampleConverter{
+		previous: make(map[string]float64),
+	}
+}
+
+func (c *SampleConverter) Convert(samples []Sample) []Metric {
+	converted := make([]Metric, 0, len(samples))
+
+	for _, sample := range samples {
+		// NaN and infinities can't be represented in the JSON API
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+
+		id := sample.ID()
+		if !sample.IsCumulative() {
+			m := Metric{ID: id, MType: GaugeStrName}
+			m.SetFloat64(sample.Value)
+			converted = append(converted, m)
+			continue
+		}
+
+		previous, exist := c.previous[id]
+		if !exist {
+			c.previous[id] = sample.Value
+			continue
+		}
+
+		if sample.Value < previous {
+			// the counter was reset by a restart of its source
+			previous = 0
+		}
+
+		// fractional increments are carried over to the next conversion
+		delta := math.Floor(sample.Value - previous)
+		c.previous[id] = previous + delta
+
+		m := Metric{ID: id, MType: CounterStrName}
+		m.SetInt64(int64(delta))
+		converted = append(converted, m)
+	}
+
+	return converted
+}
+
+// WritePrometheusText writes metrics in the Prometheus text exposition format.
+// IDs are sanitized into valid metric names, counters must be cumulative.
+func WritePrometheusText(w io.Writer, m []Metric) error {
+	bw := bufio.NewWriter(w)
+
+	for _, item := range m {
+		name := promName(item.ID)
+		typ := PromGauge
+		if item.IsCounter() {
+			typ = PromCounter
+		}
+
+		if _, err := fmt.Fprintf(bw, "# TYPE %s %s\n%s %s\n", name, typ, name, item.GetStrValue()); err != nil {
+			return fmt.Errorf("WritePrometheusText: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("WritePrometheusText: %w", err)
+	}
+
+	return nil
+}
+
+func promName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, id)
+
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
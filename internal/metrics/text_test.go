@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrometheusText = `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{code="400", method="post"} 3
+# TYPE queue_length gauge
+queue_length 17.5
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.05
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+# TYPE request_size_bytes histogram
+request_size_bytes_bucket{le="+Inf"} 144
+untyped_value{path="/a \"b\", c"} NaN
+`
+
+func TestParsePrometheusText(t *testing.T) {
+	samples, err := ParsePrometheusText(strings.NewReader(testPrometheusText))
+	require.NoError(t, err)
+	require.Len(t, samples, 8)
+
+	tests := []struct {
+		index      int
+		id         string
+		typ        string
+		value      float64
+		cumulative bool
+	}{
+		{0, "http_requests_total_code_200_method_post", PromCounter, 1027, true},
+		{1, "http_requests_total_code_400_method_post", PromCounter, 3, true},
+		{2, "queue_length", PromGauge, 17.5, false},
+		{3, "rpc_duration_seconds_quantile_0.5", PromSummary, 0.05, false},
+		{4, "rpc_duration_seconds_sum", PromSummary, 1.7560473e+07, true},
+		{5, "rpc_duration_seconds_count", PromSummary, 2693, true},
+		{6, "request_size_bytes_bucket_le__Inf", PromHistogram, 144, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			sample := samples[tt.index]
+			assert.Equal(t, tt.id, sample.ID())
+			assert.Equal(t, tt.typ, sample.Type)
+			assert.Equal(t, tt.value, sample.Value)
+			assert.Equal(t, tt.cumulative, sample.IsCumulative())
+		})
+	}
+
+	assert.Equal(t, PromUntyped, samples[7].Type)
+	assert.Equal(t, []Label{{Name: "path", Value: `/a "b", c`}}, samples[7].Labels)
+	assert.True(t, math.IsNaN(samples[7].Value))
+}
+
+func TestParsePrometheusText_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{
+			name: "missing value",
+			text: "queue_length\n",
+		},
+
+		{
+			name: "invalid value",
+			text: "queue_length none\n",
+		},
+
+		{
+			name: "unterminated label",
+			text: `queue_length{name="a} 1` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePrometheusText(strings.NewReader(tt.text))
+			assert.ErrorIs(t, err, ErrInvalidTextFormat)
+		})
+	}
+}
+
+func TestSampleConverter_Convert(t *testing.T) {
+	c := NewSampleConverter()
+	counter := Sample{Name: "jobs_total", Type: PromCounter}
+	gauge := Sample{Name: "queue_length", Type: PromGauge, Value: 3}
+
+	counter.Value = 10
+	converted := c.Convert([]Sample{counter, gauge, {Name: "nan", Value: math.NaN()}})
+	require.Len(t, converted, 1, "counters only record the baseline on the first conversion")
+	assert.Equal(t, GaugeStrName, converted[0].MType)
+	assert.Equal(t, float64(3), converted[0].Float64Value())
+
+	counter.Value = 12.5
+	converted = c.Convert([]Sample{counter})
+	require.Len(t, converted, 1)
+	assert.Equal(t, CounterStrName, converted[0].MType)
+	assert.Equal(t, int64(2), converted[0].Int64Value())
+
+	counter.Value = 13
+	converted = c.Convert([]Sample{counter})
+	assert.Equal(t, int64(1), converted[0].Int64Value(), "fractional increments are carried over")
+
+	counter.Value = 4
+	converted = c.Convert([]Sample{counter})
+	assert.Equal(t, int64(4), converted[0].Int64Value(), "reset counter reports its new value")
+}
+
+func TestWritePrometheusText(t *testing.T) {
+	delta := int64(42)
+	value := 17.5
+	var b strings.Builder
+
+	err := WritePrometheusText(&b, []Metric{
+		{ID: "PollCount", MType: CounterStrName, Delta: &delta},
+		{ID: "DiskUsed_var.lib-docker", MType: GaugeStrName, Value: &value},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE PollCount counter\nPollCount 42\n"+
+		"# TYPE DiskUsed_var_lib_docker gauge\nDiskUsed_var_lib_docker 17.5\n", b.String())
+
+	samples, err := ParsePrometheusText(strings.NewReader(b.String()))
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{
+		{Name: "PollCount", Type: PromCounter, Value: 42},
+		{Name: "DiskUsed_var_lib_docker", Type: PromGauge, Value: 17.5},
+	}, samples)
+}
@@ -0,0 +1,321 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+type (
+	Manager interface {
+		Start(ctx context.Context, wg *sync.WaitGroup)
+	}
+
+	// TargetGroup is an item of the targets file, the file is a JSON or YAML list of groups:
+	//
+	//	- targets: ["10.0.0.5:9100", "https://node.example.com/metrics"]
+	//	  interval: 30s
+	//	  timeout: 5s
+	//
+	// A zero interval or timeout is taken from the Config.
+	TargetGroup struct {
+		Targets  []string      `yaml:"targets"`
+		Interval time.Duration `yaml:"interval"`
+		Timeout  time.Duration `yaml:"timeout"`
+	}
+
+	targetSpec struct {
+		url      string
+		interval time.Duration
+		timeout  time.Duration
+	}
+
+	runningTarget struct {
+		targetSpec
+		target *target
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+
+	manager struct {
+		cfg     Config
+		key     string
+		ingest  IngestFunc
+		client  *http.Client
+		content []byte
+		running map[string]*runningTarget
+	}
+)
+
+// NewManager creates a scrape manager of the configured targets, when key is set the
+// metrics of JSON targets must be signed with it.
+func NewManager(cfg Config, key string, ingest IngestFunc) (Manager, error) {
+	if err := cfg.Valid(); err != nil {
+		return nil, fmt.Errorf("NewManager: %w", err)
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = IntervalDefault
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = TimeoutDefault
+	}
+
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = RefreshIntervalDefault
+	}
+
+	return &manager{
+		cfg:     cfg,
+		key:     key,
+		ingest:  ingest,
+		client:  &http.Client{},
+		running: make(map[string]*runningTarget),
+	}, nil
+}
+
+// Start scrapes the static targets and the targets of the file, the file is checked for
+// changes on the refresh interval and the set of scraped targets is updated accordingly.
+func (m *manager) Start(ctx context.Context, wg *sync.WaitGroup) {
+	groups, err := m.loadFile()
+	if err != nil {
+		logging.Errorf("scrape targets file error: %v", err)
+	}
+	m.sync(ctx, groups)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer m.stopAll()
+
+		if m.cfg.File == "" {
+			<-ctx.Done()
+			return
+		}
+
+		tick := time.NewTicker(m.cfg.RefreshInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				m.refresh(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *manager) refresh(ctx context.Context) {
+	content := m.content
+	groups, err := m.loadFile()
+	if err != nil {
+		// keep scraping the previous targets until the file is fixed
+		logging.Errorf("scrape targets file error: %v", err)
+		return
+	}
+
+	if bytes.Equal(content, m.content) {
+		return
+	}
+
+	logging.Infof("scrape targets file %s changed", m.cfg.File)
+	m.sync(ctx, groups)
+}
+
+// loadFile reads the targets file, YAML is a superset of JSON so both are decoded as YAML.
+func (m *manager) loadFile() ([]TargetGroup, error) {
+	if m.cfg.File == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(m.cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("loadFile: %w", err)
+	}
+
+	groups, err := parseTargetGroups(content)
+	if err != nil {
+		return nil, fmt.Errorf("loadFile: %s: %w", m.cfg.File, err)
+	}
+
+	m.content = content
+	return groups, nil
+}
+
+func parseTargetGroups(content []byte) ([]TargetGroup, error) {
+	var groups []TargetGroup
+	if err := yaml.Unmarshal(content, &groups); err != nil {
+		return nil, fmt.Errorf("parseTargetGroups: %w: %v", ErrInvalidTarget, err)
+	}
+
+	for _, group := range groups {
+		if group.Interval < 0 || group.Timeout < 0 {
+			return nil, fmt.Errorf("parseTargetGroups: %w: negative interval or timeout", ErrInvalidTarget)
+		}
+
+		for _, item := range group.Targets {
+			if _, err := targetURL(item); err != nil {
+				return nil, fmt.Errorf("parseTargetGroups: %w", err)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// specs merges the static targets with the file groups, a target listed twice is scraped once
+// with the settings of its first occurrence.
+func (m *manager) specs(groups []TargetGroup) map[string]targetSpec {
+	groups = append([]TargetGroup{{Targets: m.cfg.Targets}}, groups...)
+	specs := make(map[string]targetSpec)
+
+	for _, group := range groups {
+		spec := targetSpec{interval: group.Interval, timeout: group.Timeout}
+		if spec.interval == 0 {
+			spec.interval = m.cfg.Interval
+		}
+		if spec.timeout == 0 {
+			spec.timeout = m.cfg.Timeout
+		}
+
+		for _, item := range group.Targets {
+			u, err := targetURL(item)
+			if err != nil {
+				continue
+			}
+			if _, exist := specs[u]; exist {
+				continue
+			}
+
+			spec.url = u
+			specs[u] = spec
+		}
+	}
+
+	return specs
+}
+
+// sync stops the targets that are gone and starts the new ones. A target whose settings
+// changed is restarted with its state, so counter baselines are kept.
+func (m *manager) sync(ctx context.Context, groups []TargetGroup) {
+	specs := m.specs(groups)
+
+	for u, running := range m.running {
+		spec, exist := specs[u]
+		if exist && spec == running.targetSpec {
+			continue
+		}
+
+		running.stop()
+		if !exist {
+			delete(m.running, u)
+			logging.Infof("scrape target %s removed", u)
+		}
+	}
+
+	for u, spec := range specs {
+		running, exist := m.running[u]
+		if exist && running.targetSpec == spec {
+			continue
+		}
+
+		t := newTarget(u, m.key, m.client)
+		if exist {
+			t = running.target
+		} else {
+			logging.Infof("scrape target %s added", u)
+		}
+
+		m.running[u] = m.run(ctx, spec, t)
+	}
+}
+
+func (m *manager) run(ctx context.Context, spec targetSpec, t *target) *runningTarget {
+	ctx, cancel := context.WithCancel(ctx)
+	running := &runningTarget{
+		targetSpec: spec,
+		target:     t,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	go func() {
+		defer close(running.done)
+		tick := time.NewTicker(spec.interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				m.scrape(ctx, running)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return running
+}
+
+// scrape ingests the metrics of the target together with its up gauge,
+// which is 1 when the scrape succeeded and 0 otherwise.
+func (m *manager) scrape(ctx context.Context, running *runningTarget) {
+	scrapeCtx, cancel := context.WithTimeout(ctx, running.timeout)
+	defer cancel()
+
+	up := 1.0
+	collected, err := running.target.scrape(scrapeCtx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		logging.Errorf("scrape error: %v", err)
+		up = 0
+	}
+
+	upMetric := metrics.Metric{ID: running.target.upID(), MType: metrics.GaugeStrName}
+	upMetric.SetFloat64(up)
+
+	if err = m.ingest(ctx, append(collected, upMetric)); err != nil && !errors.Is(err, context.Canceled) {
+		logging.Errorf("scrape ingest error: [%s] %v", running.url, err)
+	}
+}
+
+func (m *manager) stopAll() {
+	for u, running := range m.running {
+		running.stop()
+		delete(m.running, u)
+	}
+}
+
+func (r *runningTarget) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// upID is the ID of the up gauge, e.g. "up_instance_10.0.0.5_9100".
+func (t *target) upID() string {
+	instance := t.url
+	if u, err := url.Parse(t.url); err == nil {
+		instance = u.Host
+		if u.Path != MetricsPathDefault {
+			instance += u.Path
+		}
+	}
+
+	return metrics.Sample{Name: "up", Labels: []metrics.Label{{Name: "instance", Value: instance}}}.ID()
+}
@@ -0,0 +1,157 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_parseTargetGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []TargetGroup
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			content: `
+- targets: ["10.0.0.5:9100", "https://node.example.com/metrics"]
+  interval: 30s
+  timeout: 2s
+- targets:
+    - 10.0.0.6:9100
+`,
+			want: []TargetGroup{
+				{
+					Targets:  []string{"10.0.0.5:9100", "https://node.example.com/metrics"},
+					Interval: 30 * time.Second,
+					Timeout:  2 * time.Second,
+				},
+				{Targets: []string{"10.0.0.6:9100"}},
+			},
+		},
+
+		{
+			name:    "json",
+			content: `[{"targets": ["10.0.0.5:9100"], "interval": "1m"}]`,
+			want:    []TargetGroup{{Targets: []string{"10.0.0.5:9100"}, Interval: time.Minute}},
+		},
+
+		{
+			name:    "invalid interval",
+			content: `[{"targets": ["10.0.0.5:9100"], "interval": "often"}]`,
+			wantErr: true,
+		},
+
+		{
+			name:    "invalid target",
+			content: `[{"targets": ["ftp://10.0.0.5"]}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTargetGroups([]byte(tt.content))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTarget)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_target_upID(t *testing.T) {
+	assert.Equal(t, "up_instance_10.0.0.5_9100", newTarget("http://10.0.0.5:9100/metrics/json", "", nil).upID())
+	assert.Equal(t, "up_instance_node.example.com_metrics",
+		newTarget("https://node.example.com/metrics", "", nil).upID())
+}
+
+type ingested struct {
+	mu sync.Mutex
+	up map[string]float64
+}
+
+func (i *ingested) ingest(_ context.Context, m []metrics.Metric) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, item := range m {
+		if item.MType == metrics.GaugeStrName && len(item.ID) > 3 && item.ID[:3] == "up_" {
+			i.up[item.ID] = item.Float64Value()
+		}
+	}
+	return nil
+}
+
+func (i *ingested) get(id string) (float64, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	value, exist := i.up[id]
+	return value, exist
+}
+
+func Test_manager_Start(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("queue 1\n"))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "targets.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("- targets: ["+server.URL+"/up]\n"), 0o644))
+
+	result := &ingested{up: make(map[string]float64)}
+	m, err := NewManager(Config{
+		Targets:         []string{server.URL + "/down"},
+		File:            file,
+		Interval:        10 * time.Millisecond,
+		RefreshInterval: 10 * time.Millisecond,
+	}, "", result.ingest)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := new(sync.WaitGroup)
+	m.Start(ctx, wg)
+
+	host := server.Listener.Addr().String()
+	upID := func(path string) string {
+		return newTarget(server.URL+path, "", nil).upID()
+	}
+	waitUp := func(path string, want float64) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			value, exist := result.get(upID(path))
+			return exist && value == want
+		}, time.Second, 5*time.Millisecond, host+path)
+	}
+
+	waitUp("/up", 1)
+	waitUp("/down", 0)
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"targets": ["`+server.URL+`/added"]}]`), 0o644))
+	waitUp("/added", 1)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestNewManager_Invalid(t *testing.T) {
+	_, err := NewManager(Config{Targets: []string{"ftp://10.0.0.5"}}, "", nil)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
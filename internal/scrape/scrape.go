@@ -0,0 +1,177 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// MetricsPathDefault is the JSON endpoint of an agent in pull mode.
+const MetricsPathDefault = "/metrics/json"
+
+var (
+	IntervalDefault        = 10 * time.Second
+	TimeoutDefault         = 5 * time.Second
+	RefreshIntervalDefault = 30 * time.Second
+
+	ErrInvalidTarget = errors.New("invalid scrape target")
+	ErrInvalidHash   = errors.New("invalid metric hash")
+	ErrUnexpected    = errors.New("unexpected scrape response")
+)
+
+type (
+	// Config of the endpoints polled by the server. A target is either "host:port"
+	// of an agent in pull mode or the URL of a JSON or Prometheus text endpoint.
+	// Targets may also be listed in File, which is reloaded when it changes.
+	Config struct {
+		Targets         []string      `env:"SCRAPE_TARGETS" envSeparator:"," yaml:"targets"`
+		File            string        `env:"SCRAPE_FILE" yaml:"file"`
+		RefreshInterval time.Duration `env:"SCRAPE_REFRESH_INTERVAL" yaml:"refresh_interval"`
+		Interval        time.Duration `env:"SCRAPE_INTERVAL" yaml:"interval"`
+		Timeout         time.Duration `env:"SCRAPE_TIMEOUT" yaml:"timeout"`
+	}
+
+	// IngestFunc stores the scraped metrics, counters as increments since the previous scrape.
+	IngestFunc func(ctx context.Context, m []metrics.Metric) error
+
+	target struct {
+		url       string
+		key       string
+		client    *http.Client
+		counters  map[string]int64
+		converter *metrics.SampleConverter
+	}
+)
+
+func (c Config) Enabled() bool {
+	return len(c.Targets) != 0 || c.File != ""
+}
+
+func (c Config) Valid() error {
+	if c.Interval < 0 || c.Timeout < 0 || c.RefreshInterval < 0 {
+		return fmt.Errorf("Config_Valid: %w: negative interval or timeout", ErrInvalidTarget)
+	}
+
+	for _, item := range c.Targets {
+		if _, err := targetURL(item); err != nil {
+			return fmt.Errorf("Config_Valid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func newTarget(u, key string, client *http.Client) *target {
+	return &target{
+		url:       u,
+		key:       key,
+		client:    client,
+		counters:  make(map[string]int64),
+		converter: metrics.NewSampleConverter(),
+	}
+}
+
+func targetURL(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("targetURL: %w: %s", ErrInvalidTarget, address)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = MetricsPathDefault
+	}
+
+	return u.String(), nil
+}
+
+// scrape fetches the target and converts cumulative counters into increments,
+// the first scrape of a counter only records its baseline.
+func (t *target) scrape(ctx context.Context) ([]metrics.Metric, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Target_scrape: [%s] %w", t.url, err)
+	}
+	request.Header.Set("Accept", "application/json, text/plain;q=0.9")
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Target_scrape: [%s] %w", t.url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Target_scrape: [%s] %w: %s", t.url, ErrUnexpected, response.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		m, err := t.convertJSON(response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Target_scrape: [%s] %w", t.url, err)
+		}
+		return m, nil
+	}
+
+	samples, err := metrics.ParsePrometheusText(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Target_scrape: [%s] %w", t.url, err)
+	}
+
+	return t.converter.Convert(samples), nil
+}
+
+func (t *target) convertJSON(r io.Reader) ([]metrics.Metric, error) {
+	var scraped []metrics.Metric
+	if err := json.NewDecoder(r).Decode(&scraped); err != nil {
+		return nil, fmt.Errorf("convertJSON: %w: %v", ErrUnexpected, err)
+	}
+
+	converted := make([]metrics.Metric, 0, len(scraped))
+	for _, item := range scraped {
+		if err := item.Valid(); err != nil || item.ID == "" {
+			return nil, fmt.Errorf("convertJSON: %w: invalid metric %s", ErrUnexpected, item.ID)
+		}
+
+		if t.key != "" && item.CalcHash(t.key) != item.Hash {
+			return nil, fmt.Errorf("convertJSON: %w: %s", ErrInvalidHash, item.ID)
+		}
+		item.Hash = ""
+
+		if !item.IsCounter() {
+			converted = append(converted, item)
+			continue
+		}
+
+		current := item.Int64Value()
+		previous, exist := t.counters[item.ID]
+		t.counters[item.ID] = current
+		if !exist {
+			continue
+		}
+
+		delta := current - previous
+		if current < previous {
+			// the agent was restarted
+			delta = current
+		}
+
+		item.SetInt64(delta)
+		converted = append(converted, item)
+	}
+
+	return converted, nil
+}
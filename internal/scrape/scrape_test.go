@@ -0,0 +1,133 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func newMetric(t *testing.T, id, metricType, value string) metrics.Metric {
+	m, err := metrics.NewMetric(id, metricType, value)
+	require.NoError(t, err)
+	return m
+}
+
+func Test_targetURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "agent address",
+			address: "10.0.0.5:9100",
+			want:    "http://10.0.0.5:9100/metrics/json",
+		},
+
+		{
+			name:    "url with path",
+			address: "https://node.example.com/metrics",
+			want:    "https://node.example.com/metrics",
+		},
+
+		{
+			name:    "unsupported scheme",
+			address: "ftp://10.0.0.5",
+			wantErr: true,
+		},
+
+		{
+			name:    "missing host",
+			address: "http:///metrics",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := targetURL(tt.address)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTarget)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_target_scrape_JSON(t *testing.T) {
+	var served []metrics.Metric
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, MetricsPathDefault, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(served))
+	}))
+	defer server.Close()
+
+	u, err := targetURL(server.URL)
+	require.NoError(t, err)
+	tg := newTarget(u, "secret", server.Client())
+
+	serve := func(pollCount string) {
+		served = []metrics.Metric{
+			newMetric(t, "PollCount", metrics.CounterStrName, pollCount),
+			newMetric(t, "Alloc", metrics.GaugeStrName, "1024"),
+		}
+		for i := range served {
+			served[i].Hash = served[i].CalcHash("secret")
+		}
+	}
+
+	serve("10")
+	m, err := tg.scrape(context.Background())
+	require.NoError(t, err)
+	require.Len(t, m, 1, "the first counter value is the baseline")
+	assert.Equal(t, "Alloc", m[0].ID)
+	assert.Empty(t, m[0].Hash)
+
+	serve("15")
+	m, err = tg.scrape(context.Background())
+	require.NoError(t, err)
+	require.Len(t, m, 2)
+	assert.Equal(t, int64(5), m[0].Int64Value())
+
+	serve("3")
+	m, err = tg.scrape(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), m[0].Int64Value(), "a restarted agent reports its new value")
+
+	serve("4")
+	served[0].Hash = "invalid"
+	_, err = tg.scrape(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func Test_target_scrape_Prometheus(t *testing.T) {
+	value := "7"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte("# TYPE jobs_total counter\njobs_total " + value + "\nqueue 2\n"))
+	}))
+	defer server.Close()
+
+	tg := newTarget(server.URL+"/metrics", "", server.Client())
+	m, err := tg.scrape(context.Background())
+	require.NoError(t, err)
+	require.Len(t, m, 1)
+	assert.Equal(t, "queue", m[0].ID)
+
+	value = "9"
+	m, err = tg.scrape(context.Background())
+	require.NoError(t, err)
+	require.Len(t, m, 2)
+	assert.Equal(t, "jobs_total", m[0].ID)
+	assert.Equal(t, int64(2), m[0].Int64Value())
+}
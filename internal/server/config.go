@@ -5,70 +5,223 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/sreway/yametrics/internal/config"
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/scrape"
+	"github.com/sreway/yametrics/internal/storage"
 )
 
 type (
 	serverConfig struct {
-		Address       string        `env:"ADDRESS"`
-		StoreInterval time.Duration `env:"STORE_INTERVAL"`
-		StoreFile     string        `env:"STORE_FILE"`
-		Restore       bool          `env:"RESTORE"`
-		compressLevel int
-		compressTypes []string
-		Key           string `env:"KEY"`
-		Dsn           string `env:"DATABASE_DSN"`
+		Address       string        `env:"ADDRESS" yaml:"address"`
+		StoreInterval time.Duration `env:"STORE_INTERVAL" yaml:"store_interval"`
+		StoreFile     string        `env:"STORE_FILE" yaml:"store_file"`
+		Restore       bool          `env:"RESTORE" yaml:"restore"`
+		StoreKeep     int           `env:"STORE_KEEP" yaml:"store_keep"`
+		// StoreShards splits the memory storage into shards when positive, see storage.NewShardedMemoryStorage.
+		StoreShards int `env:"STORE_SHARDS" yaml:"store_shards"`
+		// WalFile enables the write-ahead log of the memory storage, see storage.WithWAL.
+		WalFile         string                `env:"WAL_FILE" yaml:"wal_file"`
+		WalSync         storage.WALSyncPolicy `env:"WAL_SYNC" yaml:"wal_sync"`
+		WalSyncInterval time.Duration         `env:"WAL_SYNC_INTERVAL" yaml:"wal_sync_interval"`
+		compressLevel   int
+		compressTypes   []string
+		Key             string `env:"KEY" yaml:"key"`
+		Dsn             string `env:"DATABASE_DSN" yaml:"database_dsn"`
+		// StorageMode is what happens when the PostgreSQL database of Dsn is unavailable.
+		StorageMode StorageMode `env:"STORAGE_MODE" yaml:"storage_mode"`
+		// Database* tune the PostgreSQL connection pool, see storage.NewPgStorage.
+		DatabaseMaxConns          int32         `env:"DATABASE_MAX_CONNS" yaml:"database_max_conns"`
+		DatabaseMinConns          int32         `env:"DATABASE_MIN_CONNS" yaml:"database_min_conns"`
+		DatabaseHealthCheckPeriod time.Duration `env:"DATABASE_HEALTH_CHECK_PERIOD" yaml:"database_health_check_period"`
+		DatabaseStatementCache    int           `env:"DATABASE_STATEMENT_CACHE" yaml:"database_statement_cache"`
+		Scrape                    scrape.Config `yaml:"scrape"`
+		LogLevel                  logging.Level `env:"LOG_LEVEL" yaml:"log_level"`
 	}
 	OptionServer func(*serverConfig) error
+
+	StorageMode string
+)
+
+const (
+	// StorageModeStrict fails the startup when the database is unavailable.
+	StorageModeStrict StorageMode = "strict"
+	// StorageModeFallback uses the memory storage when the database is unavailable at startup,
+	// the database is not retried.
+	StorageModeFallback StorageMode = "fallback"
+	// StorageModeBuffered keeps the updates in memory while the database is unavailable and
+	// drains them into it once reachable, see storage.NewBufferedStorage.
+	StorageModeBuffered StorageMode = "buffered"
 )
 
 var (
-	AddressDefault       = "127.0.0.1:8080"
-	StoreIntervalDefault = 300 * time.Second
-	RestoreDefault       = true
-	StoreFileDefault     = "/tmp/devops-metrics-db.json"
-	KeyDefault           string
-	CompressLevelDefault = 5
-	CompressTypesDefault = []string{
+	AddressDefault         = "127.0.0.1:8080"
+	StoreIntervalDefault   = 300 * time.Second
+	RestoreDefault         = true
+	StoreFileDefault       = "/tmp/devops-metrics-db.json"
+	StoreKeepDefault       = storage.SnapshotKeepDefault
+	StoreShardsDefault     int
+	WalFileDefault         string
+	WalSyncDefault         = storage.WALSyncAlways
+	WalSyncIntervalDefault = time.Second
+	KeyDefault             string
+	CompressLevelDefault   = 5
+	CompressTypesDefault   = []string{
 		"text/html",
 		"text/plain",
 		"application/json",
 	}
-	DsnDefault          string
-	SourceMigrationsURL = "file://schema/"
-	ErrInvalidConfigOps = errors.New("invalid configuration option")
-	ErrInvalidConfig    = errors.New("invalid configuration")
+	DsnDefault                       string
+	StorageModeDefault               = StorageModeFallback
+	DatabaseMaxConnsDefault          = storage.PgMaxConnsDefault
+	DatabaseMinConnsDefault          = storage.PgMinConnsDefault
+	DatabaseHealthCheckPeriodDefault = storage.PgHealthCheckPeriodDefault
+	DatabaseStatementCacheDefault    = storage.PgStatementCacheDefault
+	LogLevelDefault                  = logging.LevelInfo
+	SourceMigrationsURL              = "file://schema/postgres/"
+	SQLiteMigrationsURL              = "file://schema/sqlite/"
+	ShutdownTimeout                  = 10 * time.Second
+	ErrInvalidConfigOps              = errors.New("invalid configuration option")
+	ErrInvalidConfig                 = errors.New("invalid configuration")
+	ErrInvalidStorageMode            = errors.New("invalid storage mode")
 )
 
-func newServerConfig() (*serverConfig, error) {
+// newServerConfig applies the config file over the defaults and the environment over the file,
+// see the config package for the precedence rules.
+func newServerConfig(configFile string) (*serverConfig, error) {
 	cfg := serverConfig{
-		Address:       AddressDefault,
-		StoreInterval: StoreIntervalDefault,
-		Restore:       RestoreDefault,
-		StoreFile:     StoreFileDefault,
-		compressLevel: CompressLevelDefault,
-		compressTypes: CompressTypesDefault,
-		Key:           KeyDefault,
-		Dsn:           DsnDefault,
+		Address:                   AddressDefault,
+		StoreInterval:             StoreIntervalDefault,
+		Restore:                   RestoreDefault,
+		StoreFile:                 StoreFileDefault,
+		StoreKeep:                 StoreKeepDefault,
+		StoreShards:               StoreShardsDefault,
+		WalFile:                   WalFileDefault,
+		WalSync:                   WalSyncDefault,
+		WalSyncInterval:           WalSyncIntervalDefault,
+		compressLevel:             CompressLevelDefault,
+		compressTypes:             CompressTypesDefault,
+		Key:                       KeyDefault,
+		Dsn:                       DsnDefault,
+		StorageMode:               StorageModeDefault,
+		DatabaseMaxConns:          DatabaseMaxConnsDefault,
+		DatabaseMinConns:          DatabaseMinConnsDefault,
+		DatabaseHealthCheckPeriod: DatabaseHealthCheckPeriodDefault,
+		DatabaseStatementCache:    DatabaseStatementCacheDefault,
+		LogLevel:                  LogLevelDefault,
+		Scrape: scrape.Config{
+			Interval:        scrape.IntervalDefault,
+			Timeout:         scrape.TimeoutDefault,
+			RefreshInterval: scrape.RefreshIntervalDefault,
+		},
+	}
+
+	if err := config.Load(configFile, &cfg); err != nil {
+		return nil, fmt.Errorf("newServerConfig: %w", err)
 	}
 
 	if err := env.Parse(&cfg); err != nil {
 		return nil, fmt.Errorf("newServerConfig: %w", err)
 	}
 
+	return &cfg, nil
+}
+
+// Valid checks the resulting configuration, errors name the invalid field by its config file key.
+func (cfg *serverConfig) Valid() error {
 	_, port, err := net.SplitHostPort(cfg.Address)
 	if err != nil {
-		return nil, fmt.Errorf("newServerConfig: %w invalid address %s", ErrInvalidConfig, cfg.Address)
+		return fmt.Errorf("%w: address: invalid host:port %s", ErrInvalidConfig, cfg.Address)
 	}
 
 	_, err = strconv.Atoi(port)
 	if err != nil {
-		return nil, fmt.Errorf("newServerConfig: %w invalid port %s", ErrInvalidConfigOps, cfg.Address)
+		return fmt.Errorf("%w: address: invalid port %s", ErrInvalidConfig, cfg.Address)
 	}
 
-	return &cfg, nil
+	if cfg.StoreInterval < 0 {
+		return fmt.Errorf("%w: store_interval: negative duration %s", ErrInvalidConfig, cfg.StoreInterval)
+	}
+
+	if cfg.Dsn == storage.BoltDSNPrefix || cfg.Dsn == storage.SQLiteDSNPrefix {
+		return fmt.Errorf("%w: database_dsn: missing database path", ErrInvalidConfig)
+	}
+
+	if err = cfg.StorageMode.Valid(); err != nil {
+		return fmt.Errorf("%w: storage_mode: %v", ErrInvalidConfig, err)
+	}
+
+	if cfg.StorageMode == StorageModeBuffered && !cfg.postgresDsn() {
+		return fmt.Errorf("%w: storage_mode: buffered requires a PostgreSQL database_dsn", ErrInvalidConfig)
+	}
+
+	if cfg.DatabaseMaxConns < 1 {
+		return fmt.Errorf("%w: database_max_conns: must be positive, got %d", ErrInvalidConfig, cfg.DatabaseMaxConns)
+	}
+
+	if cfg.DatabaseMinConns < 0 || cfg.DatabaseMinConns > cfg.DatabaseMaxConns {
+		return fmt.Errorf("%w: database_min_conns: must be between 0 and database_max_conns, got %d",
+			ErrInvalidConfig, cfg.DatabaseMinConns)
+	}
+
+	if cfg.DatabaseHealthCheckPeriod <= 0 {
+		return fmt.Errorf("%w: database_health_check_period: non-positive duration %s",
+			ErrInvalidConfig, cfg.DatabaseHealthCheckPeriod)
+	}
+
+	if cfg.DatabaseStatementCache < 0 {
+		return fmt.Errorf("%w: database_statement_cache: negative capacity %d", ErrInvalidConfig, cfg.DatabaseStatementCache)
+	}
+
+	if cfg.StoreKeep < 1 {
+		return fmt.Errorf("%w: store_keep: must keep at least one snapshot, got %d", ErrInvalidConfig, cfg.StoreKeep)
+	}
+
+	if cfg.StoreShards < 0 {
+		return fmt.Errorf("%w: store_shards: negative number of shards %d", ErrInvalidConfig, cfg.StoreShards)
+	}
+
+	if cfg.WalFile != "" && cfg.StoreFile == "" {
+		return fmt.Errorf("%w: wal_file: requires store_file for snapshots", ErrInvalidConfig)
+	}
+
+	if err = cfg.WalSync.Valid(); err != nil {
+		return fmt.Errorf("%w: wal_sync: %v", ErrInvalidConfig, err)
+	}
+
+	if cfg.WalSync == storage.WALSyncInterval && cfg.WalSyncInterval <= 0 {
+		return fmt.Errorf("%w: wal_sync_interval: non-positive duration %s", ErrInvalidConfig, cfg.WalSyncInterval)
+	}
+
+	if err = cfg.Scrape.Valid(); err != nil {
+		return fmt.Errorf("%w: scrape: %v", ErrInvalidConfig, err)
+	}
+
+	if err = cfg.LogLevel.Valid(); err != nil {
+		return fmt.Errorf("%w: log_level: %v", ErrInvalidConfig, err)
+	}
+
+	return nil
+}
+
+func (m StorageMode) Valid() error {
+	switch m {
+	case StorageModeStrict, StorageModeFallback, StorageModeBuffered:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidStorageMode, m)
+	}
+}
+
+// postgresDsn reports whether the DSN selects the PostgreSQL storage rather than an embedded one.
+func (cfg *serverConfig) postgresDsn() bool {
+	return cfg.Dsn != "" &&
+		!strings.HasPrefix(cfg.Dsn, storage.BoltDSNPrefix) && !strings.HasPrefix(cfg.Dsn, storage.SQLiteDSNPrefix)
 }
 
 func WithAddr(address string) OptionServer {
@@ -87,3 +240,66 @@ func WithAddr(address string) OptionServer {
 		return nil
 	}
 }
+
+func WithStoreInterval(storeInterval string) OptionServer {
+	return func(cfg *serverConfig) error {
+		storeIntervalDuration, err := time.ParseDuration(storeInterval)
+		if err != nil || storeIntervalDuration < 0 {
+			return fmt.Errorf("WithStoreInterval: %w: %s", ErrInvalidConfigOps, storeInterval)
+		}
+
+		cfg.StoreInterval = storeIntervalDuration
+		return nil
+	}
+}
+
+func WithStoreFile(storeFile string) OptionServer {
+	return func(cfg *serverConfig) error {
+		cfg.StoreFile = storeFile
+		return nil
+	}
+}
+
+func WithWalFile(walFile string) OptionServer {
+	return func(cfg *serverConfig) error {
+		cfg.WalFile = walFile
+		return nil
+	}
+}
+
+func WithRestore(restore string) OptionServer {
+	return func(cfg *serverConfig) error {
+		restoreValue, err := strconv.ParseBool(restore)
+		if err != nil {
+			return fmt.Errorf("WithRestore: %w: %s", ErrInvalidConfigOps, restore)
+		}
+
+		cfg.Restore = restoreValue
+		return nil
+	}
+}
+
+func WithKey(key string) OptionServer {
+	return func(cfg *serverConfig) error {
+		cfg.Key = key
+		return nil
+	}
+}
+
+func WithDsn(dsn string) OptionServer {
+	return func(cfg *serverConfig) error {
+		cfg.Dsn = dsn
+		return nil
+	}
+}
+
+func WithStorageMode(mode string) OptionServer {
+	return func(cfg *serverConfig) error {
+		if err := StorageMode(mode).Valid(); err != nil {
+			return fmt.Errorf("WithStorageMode: %w: %v", ErrInvalidConfigOps, err)
+		}
+
+		cfg.StorageMode = StorageMode(mode)
+		return nil
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWithAddr(t *testing.T) {
@@ -43,7 +46,7 @@ func TestWithAddr(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg, err := newServerConfig()
+			cfg, err := newServerConfig("")
 			assert.NoError(t, err)
 			ops := WithAddr(tt.args.address)
 			err = ops(cfg)
@@ -92,6 +95,60 @@ func Test_newServerConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+
+		{
+			name: "valid scrape targets",
+			args: args{
+				envName:  "SCRAPE_TARGETS",
+				envValue: "10.0.0.5:9100,https://node.example.com/metrics",
+			},
+			wantErr: false,
+		},
+
+		{
+			name: "valid wal sync",
+			args: args{
+				envName:  "WAL_SYNC",
+				envValue: "interval",
+			},
+			wantErr: false,
+		},
+
+		{
+			name: "invalid wal sync",
+			args: args{
+				envName:  "WAL_SYNC",
+				envValue: "sometimes",
+			},
+			wantErr: true,
+		},
+
+		{
+			name: "valid store shards",
+			args: args{
+				envName:  "STORE_SHARDS",
+				envValue: "16",
+			},
+			wantErr: false,
+		},
+
+		{
+			name: "invalid store shards",
+			args: args{
+				envName:  "STORE_SHARDS",
+				envValue: "-1",
+			},
+			wantErr: true,
+		},
+
+		{
+			name: "invalid scrape target",
+			args: args{
+				envName:  "SCRAPE_TARGETS",
+				envValue: "ftp://10.0.0.5",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -103,7 +160,10 @@ func Test_newServerConfig(t *testing.T) {
 				assert.NoError(t, err)
 			}()
 			assert.NoError(t, err)
-			_, err = newServerConfig()
+			cfg, err := newServerConfig("")
+			if err == nil {
+				err = cfg.Valid()
+			}
 			if !tt.wantErr {
 				assert.NoError(t, err)
 			} else {
@@ -112,3 +172,84 @@ func Test_newServerConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_newServerConfig_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"address": "0.0.0.0:8080",
+		"store_interval": "10s",
+		"restore": false,
+		"scrape": {"targets": ["10.0.0.5:9100"], "interval": "15s"}
+	}`), 0o644))
+	t.Setenv("STORE_INTERVAL", "20s")
+
+	cfg, err := newServerConfig(path)
+	require.NoError(t, err)
+	require.NoError(t, WithAddr("127.0.0.1:9090")(cfg))
+
+	assert.Equal(t, "127.0.0.1:9090", cfg.Address, "flags override file")
+	assert.Equal(t, 20*time.Second, cfg.StoreInterval, "environment overrides file")
+	assert.False(t, cfg.Restore)
+	assert.Equal(t, StoreFileDefault, cfg.StoreFile)
+	assert.Equal(t, []string{"10.0.0.5:9100"}, cfg.Scrape.Targets)
+	assert.Equal(t, 15*time.Second, cfg.Scrape.Interval)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"scrape": {"targets": ["ftp://10.0.0.5"]}}`), 0o644))
+	cfg, err = newServerConfig(path)
+	require.NoError(t, err)
+	err = cfg.Valid()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "scrape")
+}
+
+func TestNewServer_ValidAfterOptions(t *testing.T) {
+	t.Setenv("STORAGE_MODE", string(StorageModeBuffered))
+
+	_, err := NewServer("")
+	assert.ErrorIs(t, err, ErrInvalidConfig, "buffered needs a database")
+
+	_, err = NewServer("", WithDsn("postgres://localhost/metrics"))
+	assert.NoError(t, err, "the database is given by a flag")
+}
+
+func Test_serverConfig_ValidDatabasePool(t *testing.T) {
+	cfg, err := newServerConfig("")
+	require.NoError(t, err)
+
+	cfg.DatabaseMinConns = cfg.DatabaseMaxConns + 1
+	err = cfg.Valid()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "database_min_conns")
+}
+
+func Test_serverConfig_ValidStorageMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    StorageMode
+		dsn     string
+		wantErr bool
+	}{
+		{name: "strict", mode: StorageModeStrict, dsn: "postgres://localhost/metrics"},
+		{name: "buffered", mode: StorageModeBuffered, dsn: "postgres://localhost/metrics"},
+		{name: "fallback without database", mode: StorageModeFallback},
+		{name: "unknown", mode: "lazy", wantErr: true},
+		{name: "buffered without database", mode: StorageModeBuffered, wantErr: true},
+		{name: "buffered embedded database", mode: StorageModeBuffered, dsn: "bolt:///tmp/metrics.db", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newServerConfig("")
+			require.NoError(t, err)
+
+			cfg.StorageMode, cfg.Dsn = tt.mode, tt.dsn
+			err = cfg.Valid()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidConfig)
+				assert.ErrorContains(t, err, "storage_mode")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
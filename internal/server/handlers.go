@@ -6,12 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"html/template"
-	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/sreway/yametrics/internal/logging"
 	"github.com/sreway/yametrics/internal/metrics"
 	"github.com/sreway/yametrics/internal/storage"
 )
@@ -24,15 +25,29 @@ var (
 	}
 )
 
+// urlParam returns the decoded route parameter. chi routes on the raw path when the request escapes
+// characters that don't need it, e.g. ";", and such parameters are left escaped.
+func urlParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if r.URL.RawPath == "" {
+		return value
+	}
+
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
 func (s *server) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	metricType := chi.URLParam(r, "metricType")
-	metricName := chi.URLParam(r, "metricName")
-	metricValue := chi.URLParam(r, "metricValue")
+	metricType := urlParam(r, "metricType")
+	metricName := urlParam(r, "metricName")
+	metricValue := urlParam(r, "metricValue")
 
 	m, err := metrics.NewMetric(metricName, metricType, metricValue)
 	if err != nil {
-		log.Printf("Server_UpdateMetric: %s", err.Error())
+		logging.Warnf("Server_UpdateMetric: %v", err)
 		ErrHandel(w, err)
 		return
 	}
@@ -40,7 +55,7 @@ func (s *server) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	err = s.saveMetric(r.Context(), m, false)
 
 	if err != nil {
-		log.Printf("Server_UpdateMetric: %s", err.Error())
+		logging.Warnf("Server_UpdateMetric: %v", err)
 		ErrHandel(w, err)
 		return
 	}
@@ -66,26 +81,26 @@ func (s *server) Index(w http.ResponseWriter, r *http.Request) {
 
 	tmpl, err := template.ParseFS(templatesFS, templatePattern)
 	if err != nil {
-		log.Printf("Server_Index: parsing template error: %v", err)
+		logging.Errorf("Server_Index: parsing template error: %v", err)
 		w.WriteHeader(http.StatusNotImplemented)
 		return
 	}
 
 	err = tmpl.Execute(w, sMetrics)
 	if err != nil {
-		log.Printf("index error: %v", err)
+		logging.Errorf("index error: %v", err)
 		w.WriteHeader(http.StatusNotImplemented)
 	}
 }
 
 func (s *server) MetricValue(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
-	metricName := chi.URLParam(r, "metricName")
-	metricType := chi.URLParam(r, "metricType")
+	metricName := urlParam(r, "metricName")
+	metricType := urlParam(r, "metricType")
 
 	metric, err := s.getMetric(r.Context(), metricType, metricName, false)
 	if err != nil {
-		log.Printf("Server_MetricValue: %s", err.Error())
+		logging.Warnf("Server_MetricValue: %v", err)
 		ErrHandel(w, err)
 		return
 	}
@@ -93,7 +108,7 @@ func (s *server) MetricValue(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write([]byte(metric.GetStrValue()))
 	if err != nil {
 		w.WriteHeader(http.StatusNotImplemented)
-		log.Printf("Server_MetricValue: get metric value: error write bytes response: %v", err)
+		logging.Errorf("Server_MetricValue: get metric value: error write bytes response: %v", err)
 	}
 }
 
@@ -104,28 +119,28 @@ func (s *server) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields()
 
 	if err := decoder.Decode(&m); err != nil {
-		log.Printf("Server_UpdateMetricJSON: can't decode body: %v", err)
+		logging.Warnf("Server_UpdateMetricJSON: can't decode body: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	err := s.saveMetric(r.Context(), m, s.cfg.Key != "")
+	err := s.saveMetric(r.Context(), m, s.config().Key != "")
 	if err != nil {
-		log.Printf("Server_UpdateMetricJSON: %s", err.Error())
+		logging.Warnf("Server_UpdateMetricJSON: %v", err)
 		ErrHandel(w, err)
 		return
 	}
 
-	storageMetric, err := s.getMetric(r.Context(), m.MType, m.ID, s.cfg.Key != "")
+	storageMetric, err := s.getMetric(r.Context(), m.MType, m.ID, s.config().Key != "")
 	if err != nil {
-		log.Printf("Server_UpdateMetricJSON: %s", err.Error())
+		logging.Errorf("Server_UpdateMetricJSON: %v", err)
 		ErrHandel(w, err)
 		return
 	}
 
 	if err := json.NewEncoder(w).Encode(&storageMetric); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("failed encode metric: %v", err)
+		logging.Errorf("failed encode metric: %v", err)
 		return
 	}
 }
@@ -137,37 +152,59 @@ func (s *server) MetricValueJSON(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields()
 
 	if err := decoder.Decode(&m); err != nil {
-		log.Printf("Server_MetricValueJSON: can't decode body: %v", err)
+		logging.Warnf("Server_MetricValueJSON: can't decode body: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	sMetric, err := s.getMetric(r.Context(), m.MType, m.ID, s.cfg.Key != "")
+	sMetric, err := s.getMetric(r.Context(), m.MType, m.ID, s.config().Key != "")
 	if err != nil {
-		log.Printf("Server_MetricValueJSON: %s", err.Error())
+		logging.Warnf("Server_MetricValueJSON: %v", err)
 		ErrHandel(w, err)
 		return
 	}
 
 	if err := json.NewEncoder(w).Encode(&sMetric); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("Server_MetricValueJSON: failed encode metric: %v", err)
+		logging.Errorf("Server_MetricValueJSON: failed encode metric: %v", err)
 		return
 	}
 }
 
+// Ping reports the state of each storage backend. The status is 500 when the backend the updates
+// go to is unavailable, and 200 with the degraded status in the body when the server serves from
+// the buffer or the memory fallback instead of the database.
 func (s *server) Ping(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
 
-	err := s.pingStorage(ctx)
+	w.Header().Set("Content-Type", "application/json")
+	states, err := s.pingStorage(ctx)
 	if err != nil {
-		log.Printf("Server_Ping: %s", err.Error())
-		ErrHandel(w, err)
+		logging.Errorf("Server_Ping: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	if states == nil {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	status := backendOK
+	for _, state := range states {
+		if state.Status == backendUnavailable {
+			status = backendDegraded
+		}
+	}
+	if err != nil {
+		status = backendUnavailable
+	}
+
+	if err = json.NewEncoder(w).Encode(struct {
+		Status   string         `json:"status"`
+		Backends []backendState `json:"backends"`
+	}{status, states}); err != nil {
+		logging.Errorf("Server_Ping: failed encode state: %v", err)
+	}
 }
 
 func (s *server) BatchMetrics(w http.ResponseWriter, r *http.Request) {
@@ -177,21 +214,21 @@ func (s *server) BatchMetrics(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields()
 
 	if err := decoder.Decode(&m); err != nil {
-		log.Printf("Server_BatchMetrics: can't decode body: %v", err)
+		logging.Warnf("Server_BatchMetrics: can't decode body: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	err := s.batchMetrics(r.Context(), m, s.cfg.Key != "")
+	err := s.batchMetrics(r.Context(), m, s.config().Key != "")
 	if err != nil {
-		log.Printf("Server_BatchMetrics: %s", err.Error())
+		logging.Warnf("Server_BatchMetrics: %v", err)
 		ErrHandel(w, err)
 		return
 	}
 
-	storageMetrics, err := s.getMetricsList(r.Context(), s.cfg.Key != "")
+	storageMetrics, err := s.getMetricsList(r.Context(), s.config().Key != "")
 	if err != nil {
-		log.Printf("Server_BatchMetrics: %s", err.Error())
+		logging.Errorf("Server_BatchMetrics: %v", err)
 		ErrHandel(w, err)
 		return
 	}
@@ -202,7 +239,7 @@ func (s *server) BatchMetrics(w http.ResponseWriter, r *http.Request) {
 	stdout.Metrics = storageMetrics
 	if err := json.NewEncoder(w).Encode(&stdout); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("failed encode metric: %v", err)
+		logging.Errorf("failed encode metric: %v", err)
 		return
 	}
 }
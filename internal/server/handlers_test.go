@@ -119,14 +119,13 @@ func Test_server_UpdateMetric(t *testing.T) {
 		},
 	}
 
-	cfg, err := newServerConfig()
+	cfg, err := newServerConfig("")
 	assert.NoError(t, err)
 	store, err := storage.NewMemoryStorage(cfg.StoreFile)
 	assert.NoError(t, err)
 	s := &server{
-		nil,
-		store,
-		cfg,
+		storage: store,
+		cfg:     cfg,
 	}
 
 	for _, tt := range tests {
@@ -256,14 +255,13 @@ func Test_server_MetricValue(t *testing.T) {
 			},
 		},
 	}
-	cfg, err := newServerConfig()
+	cfg, err := newServerConfig("")
 	assert.NoError(t, err)
 	store, err := storage.NewMemoryStorage(cfg.StoreFile)
 	assert.NoError(t, err)
 	s := &server{
-		nil,
-		store,
-		cfg,
+		storage: store,
+		cfg:     cfg,
 	}
 
 	for _, tt := range tests {
@@ -400,14 +398,13 @@ func Test_server_UpdateMetricJSON(t *testing.T) {
 			},
 		},
 	}
-	cfg, err := newServerConfig()
+	cfg, err := newServerConfig("")
 	assert.NoError(t, err)
 	store, err := storage.NewMemoryStorage(cfg.StoreFile)
 	assert.NoError(t, err)
 	s := &server{
-		nil,
-		store,
-		cfg,
+		storage: store,
+		cfg:     cfg,
 	}
 
 	for _, tt := range tests {
@@ -533,13 +530,11 @@ func Test_server_MetricValueJSON(t *testing.T) {
 			},
 		},
 	}
-	cfg, err := newServerConfig()
+	cfg, err := newServerConfig("")
 	assert.NoError(t, err)
 
 	s := &server{
-		nil,
-		nil,
-		cfg,
+		cfg: cfg,
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+	"github.com/sreway/yametrics/internal/storage"
+	"github.com/sreway/yametrics/internal/testutil"
+)
+
+// hostileNames are metric names that would break queries or pages built by string concatenation.
+var hostileNames = []string{
+	`'; DROP TABLE metrics; --`,
+	`x' OR '1'='1`,
+	`Robert'); DELETE FROM metrics WHERE ('1'='1`,
+	`name"with"quotes`,
+	`back\slash`,
+	`$1`,
+	`%s%d%%`,
+	`semi;colon,comma`,
+	`with space`,
+	`<script>alert(1)</script>`,
+	`unicode_метрика_✓`,
+	`escape\x00sequence`,
+}
+
+// testStorageBackends are the backends the HTTP regression tests run against. Postgres runs
+// against TEST_DATABASE_DSN when it's set and against the in-process stand-in otherwise.
+func testStorageBackends(t *testing.T) map[string]func(t *testing.T) storage.Storage {
+	return map[string]func(t *testing.T) storage.Storage{
+		"memory": func(t *testing.T) storage.Storage {
+			s, err := storage.NewMemoryStorage("")
+			require.NoError(t, err)
+			return s
+		},
+		"bolt": func(t *testing.T) storage.Storage {
+			s, err := storage.NewBoltStorage(filepath.Join(t.TempDir(), "metrics.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = s.Close(context.Background()) })
+			return s
+		},
+		"sqlite": func(t *testing.T) storage.Storage {
+			s, err := storage.NewSQLiteStorage(context.Background(), filepath.Join(t.TempDir(), "metrics.db"))
+			require.NoError(t, err)
+			require.NoError(t, s.ValidateSchema("file://../../schema/sqlite/"))
+			t.Cleanup(func() { _ = s.Close(context.Background()) })
+			return s
+		},
+		"postgres": func(t *testing.T) storage.Storage {
+			ctx := context.Background()
+			dsn, real := testutil.DatabaseDSN(t, "../../schema/postgres/000001_create_metrics_table.up.sql")
+			s, err := storage.NewPgStorage(ctx, dsn)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = s.Close(ctx) })
+			if !real {
+				return s
+			}
+			require.NoError(t, s.ValidateSchema("file://../../schema/postgres/"))
+
+			// the database outlives the test, the metrics of previous runs are deleted
+			conn, err := pgx.Connect(ctx, dsn)
+			require.NoError(t, err)
+			defer conn.Close(ctx)
+			_, err = conn.Exec(ctx, "DELETE FROM metrics WHERE name = ANY($1)", append([]string{"Canary"}, hostileNames...))
+			require.NoError(t, err)
+
+			return s
+		},
+	}
+}
+
+func doRequest(t *testing.T, ts *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(content)
+}
+
+func metricJSON(t *testing.T, m metrics.Metric) string {
+	t.Helper()
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func Test_server_HostileMetricNames(t *testing.T) {
+	for backend, newStorage := range testStorageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			cfg, err := newServerConfig("")
+			require.NoError(t, err)
+			s := &server{storage: newStorage(t), cfg: cfg}
+
+			r := chi.NewRouter()
+			s.initRoutes(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			canary := metrics.Metric{ID: "Canary", MType: metrics.CounterStrName}
+			canary.SetInt64(1)
+			require.NoError(t, s.storage.Save(ctx, canary))
+
+			for _, name := range hostileNames {
+				escaped := url.PathEscape(name)
+
+				code, _ := doRequest(t, ts, http.MethodPost, "/update/gauge/"+escaped+"/1.5", "")
+				require.Equal(t, http.StatusOK, code, "update %q", name)
+
+				code, body := doRequest(t, ts, http.MethodGet, "/value/gauge/"+escaped, "")
+				require.Equal(t, http.StatusOK, code, "value %q", name)
+				assert.Equal(t, "1.5", body, "value %q", name)
+
+				counter := metrics.Metric{ID: name, MType: metrics.CounterStrName}
+				counter.SetInt64(2)
+				code, _ = doRequest(t, ts, http.MethodPost, "/update/", metricJSON(t, counter))
+				require.Equal(t, http.StatusOK, code, "update json %q", name)
+
+				gauge := metrics.Metric{ID: name, MType: metrics.GaugeStrName}
+				gauge.SetFloat64(2.5)
+				counter.SetInt64(3)
+				batch, err := json.Marshal([]metrics.Metric{counter, gauge})
+				require.NoError(t, err)
+				code, _ = doRequest(t, ts, http.MethodPost, "/updates/", string(batch))
+				require.Equal(t, http.StatusOK, code, "updates %q", name)
+
+				code, body = doRequest(t, ts, http.MethodPost, "/value/",
+					metricJSON(t, metrics.Metric{ID: name, MType: metrics.CounterStrName}))
+				require.Equal(t, http.StatusOK, code, "value json %q", name)
+				var got metrics.Metric
+				require.NoError(t, json.Unmarshal([]byte(body), &got))
+				assert.Equal(t, name, got.ID)
+				assert.Equal(t, int64(5), got.Int64Value(), "value json %q", name)
+
+				code, body = doRequest(t, ts, http.MethodGet, "/value/counter/"+escaped, "")
+				require.Equal(t, http.StatusOK, code, "value %q", name)
+				assert.Equal(t, "5", body, "value %q", name)
+			}
+
+			// the Postgres database may hold other metrics, only the names of the test are counted
+			m, err := s.storage.GetMetrics(ctx)
+			require.NoError(t, err)
+			counters, gauges := 0, 0
+			for _, name := range append([]string{canary.ID}, hostileNames...) {
+				if _, exist := m.Counter[name]; exist {
+					counters++
+				}
+				if _, exist := m.Gauge[name]; exist {
+					gauges++
+				}
+			}
+			assert.Equal(t, len(hostileNames)+1, counters, "each name is a separate counter")
+			assert.Equal(t, len(hostileNames), gauges, "each name is a separate gauge")
+
+			stored, err := s.storage.GetMetric(ctx, metrics.CounterStrName, canary.ID)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), stored.Int64Value(), "other metrics are untouched")
+
+			code, body := doRequest(t, ts, http.MethodGet, "/", "")
+			require.Equal(t, http.StatusOK, code)
+			assert.NotContains(t, body, "<script>alert(1)</script>")
+			assert.Contains(t, body, "&lt;script&gt;")
+		})
+	}
+}
+
+func Test_urlParam(t *testing.T) {
+	for _, name := range hostileNames {
+		t.Run(fmt.Sprintf("%q", name), func(t *testing.T) {
+			var got string
+			r := chi.NewRouter()
+			r.Get("/{name}", func(w http.ResponseWriter, r *http.Request) {
+				got = urlParam(r, "name")
+			})
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			code, _ := doRequest(t, ts, http.MethodGet, "/"+url.PathEscape(name), "")
+			require.Equal(t, http.StatusOK, code)
+			assert.Equal(t, name, got)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+	"github.com/sreway/yametrics/internal/storage"
+)
+
+// PoolStatsInterval is how often the PostgreSQL pool stats are recorded as the server's own metrics.
+var PoolStatsInterval = 10 * time.Second
+
+func (s *server) startPoolStats(ctx context.Context) *worker {
+	store, ok := s.storage.(storage.PgStorage)
+	if !ok {
+		return nil
+	}
+
+	return startWorker(ctx, func(ctx context.Context, wg *sync.WaitGroup) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tick := time.NewTicker(PoolStatsInterval)
+			defer tick.Stop()
+
+			var previous storage.PoolStats
+			for {
+				select {
+				case <-tick.C:
+					current := store.Stats()
+					if err := s.batchMetrics(ctx, poolStatsMetrics(previous, current), false); err != nil {
+						logging.Errorf("Server_poolStats: %v", err)
+						continue
+					}
+					previous = current
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	})
+}
+
+// poolStatsMetrics converts the pool stats to gauges and to counters of the change since previous.
+func poolStatsMetrics(previous, current storage.PoolStats) []metrics.Metric {
+	gauges := map[string]float64{
+		"PgPoolTotalConns":             float64(current.TotalConns),
+		"PgPoolIdleConns":              float64(current.IdleConns),
+		"PgPoolAcquiredConns":          float64(current.AcquiredConns),
+		"PgPoolConstructingConns":      float64(current.ConstructingConns),
+		"PgPoolMaxConns":               float64(current.MaxConns),
+		"PgPoolAcquireDurationSeconds": current.AcquireDuration.Seconds(),
+	}
+	counters := map[string]int64{
+		"PgPoolAcquireCount":         current.AcquireCount - previous.AcquireCount,
+		"PgPoolEmptyAcquireCount":    current.EmptyAcquireCount - previous.EmptyAcquireCount,
+		"PgPoolCanceledAcquireCount": current.CanceledAcquireCount - previous.CanceledAcquireCount,
+	}
+
+	m := make([]metrics.Metric, 0, len(gauges)+len(counters))
+	for id, value := range gauges {
+		metric := metrics.Metric{ID: id, MType: metrics.GaugeStrName}
+		metric.SetFloat64(value)
+		m = append(m, metric)
+	}
+
+	for id, delta := range counters {
+		metric := metrics.Metric{ID: id, MType: metrics.CounterStrName}
+		metric.SetInt64(delta)
+		m = append(m, metric)
+	}
+
+	return m
+}
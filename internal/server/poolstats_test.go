@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sreway/yametrics/internal/metrics"
+	"github.com/sreway/yametrics/internal/storage"
+)
+
+func Test_poolStatsMetrics(t *testing.T) {
+	previous := storage.PoolStats{AcquireCount: 10, EmptyAcquireCount: 2}
+	current := storage.PoolStats{
+		TotalConns:        4,
+		IdleConns:         3,
+		AcquiredConns:     1,
+		MaxConns:          10,
+		AcquireCount:      15,
+		EmptyAcquireCount: 2,
+		AcquireDuration:   1500 * time.Millisecond,
+	}
+
+	got := make(map[string]metrics.Metric)
+	for _, metric := range poolStatsMetrics(previous, current) {
+		assert.NoError(t, metric.Valid())
+		got[metric.ID] = metric
+	}
+
+	gauge := got["PgPoolTotalConns"]
+	assert.Equal(t, 4.0, gauge.Float64Value())
+	gauge = got["PgPoolAcquireDurationSeconds"]
+	assert.Equal(t, 1.5, gauge.Float64Value())
+	counter := got["PgPoolAcquireCount"]
+	assert.Equal(t, int64(5), counter.Int64Value(), "counters are the change since the previous stats")
+	counter = got["PgPoolEmptyAcquireCount"]
+	assert.Equal(t, int64(0), counter.Int64Value())
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sreway/yametrics/internal/config"
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+	"github.com/sreway/yametrics/internal/scrape"
+	"github.com/sreway/yametrics/internal/storage"
+)
+
+var (
+	ErrRestartRequired = errors.New("configuration change requires restart")
+
+	// restartRequired are the config keys bound at startup: listeners, storage and restore.
+	restartRequired = []string{
+		"address", "database_dsn", "storage_mode", "store_file", "store_keep", "store_shards", "restore",
+		"wal_file", "wal_sync", "wal_sync_interval",
+		"database_max_conns", "database_min_conns", "database_health_check_period", "database_statement_cache",
+	}
+)
+
+// worker is a background task restarted when the configuration it depends on changes.
+type worker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func startWorker(ctx context.Context, start func(ctx context.Context, wg *sync.WaitGroup)) *worker {
+	w := new(worker)
+	ctx, w.cancel = context.WithCancel(ctx)
+	start(ctx, &w.wg)
+	return w
+}
+
+func (w *worker) stop() {
+	if w == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (s *server) config() *serverConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+func (s *server) startStore(ctx context.Context) *worker {
+	if _, ok := s.storage.(storage.MemoryStorage); !ok || s.config().StoreInterval == 0 {
+		return nil
+	}
+
+	return startWorker(ctx, func(ctx context.Context, wg *sync.WaitGroup) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.storeMetrics(ctx)
+		}()
+	})
+}
+
+func (s *server) startScrape(ctx context.Context) (*worker, error) {
+	cfg := s.config()
+	if !cfg.Scrape.Enabled() {
+		return nil, nil
+	}
+
+	scrapeManager, err := scrape.NewManager(cfg.Scrape, cfg.Key, func(ctx context.Context, m []metrics.Metric) error {
+		return s.batchMetrics(ctx, m, false)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Server_startScrape: %w", err)
+	}
+
+	return startWorker(ctx, scrapeManager.Start), nil
+}
+
+// reload re-reads the configuration the server was started with and applies it live.
+// The command line options are applied again, so flags keep their precedence. A change
+// of a setting bound at startup rejects the whole reload and the current config stays.
+func (s *server) reload(ctx context.Context) error {
+	cfg, err := newServerConfig(s.configFile)
+	if err != nil {
+		return fmt.Errorf("Server_reload: %w", err)
+	}
+
+	for _, opt := range s.opts {
+		if err = opt(cfg); err != nil {
+			return fmt.Errorf("Server_reload: %w", err)
+		}
+	}
+
+	if err = cfg.Valid(); err != nil {
+		return fmt.Errorf("Server_reload: %w", err)
+	}
+
+	changed := config.Diff(s.config(), cfg)
+	if len(changed) == 0 {
+		logging.Infof("config reloaded: no changes")
+		return nil
+	}
+
+	var rejected []string
+	for _, key := range changed {
+		for _, item := range restartRequired {
+			if key == item {
+				rejected = append(rejected, key)
+			}
+		}
+	}
+	if len(rejected) != 0 {
+		return fmt.Errorf("Server_reload: %w: %s", ErrRestartRequired, strings.Join(rejected, ", "))
+	}
+
+	if err = logging.SetLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("Server_reload: %w", err)
+	}
+
+	s.mu.Lock()
+	s.cfg = cfg
+	s.mu.Unlock()
+	logging.Infof("config reloaded, changed: %s", strings.Join(changed, ", "))
+
+	restartStore, restartScrape := false, false
+	for _, key := range changed {
+		switch {
+		case key == "store_interval":
+			restartStore = true
+		case key == "key", strings.HasPrefix(key, "scrape."):
+			restartScrape = true
+		}
+	}
+
+	if restartStore {
+		s.store.stop()
+		s.store = s.startStore(ctx)
+	}
+
+	if restartScrape {
+		s.scrape.stop()
+		if s.scrape, err = s.startScrape(ctx); err != nil {
+			return fmt.Errorf("Server_reload: %w", err)
+		}
+	}
+
+	return nil
+}
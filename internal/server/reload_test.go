@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/storage"
+)
+
+func Test_server_reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	writeConfig("store_interval: 300s\nstore_file: \"\"\nkey: old\n")
+
+	srv, err := NewServer(path, WithAddr("127.0.0.1:9090"))
+	require.NoError(t, err)
+	s := srv.(*server)
+	s.storage, err = storage.NewMemoryStorage("")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writeConfig("store_interval: 1s\nstore_file: \"\"\nkey: new\n")
+	require.NoError(t, s.reload(ctx))
+	assert.Equal(t, time.Second, s.config().StoreInterval)
+	assert.Equal(t, "new", s.config().Key)
+	assert.Equal(t, "127.0.0.1:9090", s.config().Address, "flags keep their precedence")
+	require.NotNil(t, s.store, "store worker is restarted with the new interval")
+
+	t.Cleanup(func() { _ = logging.SetLevel(logging.LevelInfo) })
+	writeConfig("store_interval: 1s\nstore_file: \"\"\nkey: new\nlog_level: warn\n")
+	require.NoError(t, s.reload(ctx))
+	assert.False(t, logging.Enabled(logging.LevelInfo), "the log level applies live")
+
+	writeConfig("store_interval: 2s\nstore_file: \"\"\ndatabase_dsn: postgres://localhost/metrics\n")
+	assert.ErrorIs(t, s.reload(ctx), ErrRestartRequired)
+	assert.Equal(t, time.Second, s.config().StoreInterval, "rejected reload keeps the current config")
+
+	writeConfig("store_interval: 1s\nstore_file: \"\"\nkey: new\nlog_level: verbose\n")
+	assert.ErrorIs(t, s.reload(ctx), ErrInvalidConfig)
+	assert.False(t, logging.Enabled(logging.LevelInfo))
+
+	writeConfig("store_interval: often\n")
+	assert.Error(t, s.reload(ctx))
+	assert.Equal(t, "new", s.config().Key)
+
+	s.store.stop()
+}
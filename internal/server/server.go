@@ -8,12 +8,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/sreway/yametrics/internal/logging"
 	"github.com/sreway/yametrics/internal/metrics"
 	"github.com/sreway/yametrics/internal/storage"
 )
@@ -32,11 +35,35 @@ type (
 		httpServer *http.Server
 		storage    storage.Storage
 		cfg        *serverConfig
+		configFile string
+		opts       []OptionServer
+		mu         sync.RWMutex
+		store      *worker
+		scrape     *worker
+		poolStats  *worker
+		// fallback is set when the database was unavailable at startup and the memory
+		// storage is used instead, see StorageModeFallback.
+		fallback bool
+	}
+
+	// backendState is the state of a storage backend reported by /ping.
+	backendState struct {
+		Name     string `json:"name"`
+		Status   string `json:"status"`
+		Buffered int    `json:"buffered,omitempty"`
 	}
 )
 
-func NewServer(opts ...OptionServer) (Server, error) {
-	srvCfg, err := newServerConfig()
+const (
+	backendOK          = "ok"
+	backendUnavailable = "unavailable"
+	backendDegraded    = "degraded"
+)
+
+// NewServer creates a server configured from the config file, the environment and then
+// the options, which take precedence over the other sources.
+func NewServer(configFile string, opts ...OptionServer) (Server, error) {
+	srvCfg, err := newServerConfig(configFile)
 	if err != nil {
 		return nil, err
 	}
@@ -47,38 +74,53 @@ func NewServer(opts ...OptionServer) (Server, error) {
 		}
 	}
 
+	if err = srvCfg.Valid(); err != nil {
+		return nil, fmt.Errorf("NewServer: %w", err)
+	}
+
 	return &server{
-		&http.Server{
+		httpServer: &http.Server{
 			Addr: srvCfg.Address,
 		},
-		nil,
-		srvCfg,
+		cfg:        srvCfg,
+		configFile: configFile,
+		opts:       opts,
 	}, nil
 }
 
 func (s *server) Start() {
 	ctx, cancel := context.WithCancel(context.Background())
 	systemSignals := make(chan os.Signal, 1)
-	signal.Notify(systemSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(systemSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 	exitChan := make(chan int)
 
+	if err := logging.SetLevel(s.config().LogLevel); err != nil {
+		log.Fatalln(err)
+	}
+
 	err := s.InitStorage(ctx)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	switch t := s.storage.(type) {
+	case storage.BufferedStorage:
+		logging.Infof("using database storage, buffering updates while it's unavailable")
+
 	case storage.MemoryStorage:
-		if s.cfg.Restore {
+		if s.config().Restore {
 			err = s.loadMetrics()
 			if err != nil {
-				log.Println(err)
+				logging.Errorf("%v", err)
 			}
 		}
 
-		if s.cfg.StoreInterval != 0 {
-			go s.storeMetrics(ctx)
-		}
+	// SQLiteStorage also has Path, so it's matched before BoltStorage
+	case storage.SQLiteStorage:
+		logging.Infof("using sqlite storage %s", t.Path())
+
+	case storage.BoltStorage:
+		logging.Infof("using bolt storage %s", t.Path())
 
 	case storage.PgStorage:
 		if err = t.ValidateSchema(SourceMigrationsURL); err != nil {
@@ -88,15 +130,21 @@ func (s *server) Start() {
 		log.Fatalln(ErrInvalidStorage)
 	}
 
+	s.store = s.startStore(ctx)
+	s.poolStats = s.startPoolStats(ctx)
+	if s.scrape, err = s.startScrape(ctx); err != nil {
+		log.Fatalln(err)
+	}
+
 	go func() {
 		r := chi.NewRouter()
-		r.Use(middleware.Compress(s.cfg.compressLevel, s.cfg.compressTypes...))
+		r.Use(middleware.Compress(s.config().compressLevel, s.config().compressTypes...))
 		s.initRoutes(r)
 		s.httpServer.Handler = r
 
 		err = s.httpServer.ListenAndServe()
 		if err != nil {
-			log.Printf("server start: %v", err)
+			logging.Errorf("server start: %v", err)
 			systemSignals <- syscall.SIGSTOP
 		}
 	}()
@@ -106,18 +154,22 @@ func (s *server) Start() {
 			systemSignal := <-systemSignals
 			switch systemSignal {
 			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
-				log.Println("signal triggered.")
+				logging.Infof("signal triggered.")
 				if store, ok := s.storage.(storage.MemoryStorage); ok {
-					if s.cfg.StoreFile != "" {
+					if s.config().StoreFile != "" {
 						err = store.StoreMetrics()
 						if err != nil {
-							log.Println(err)
+							logging.Errorf("%v", err)
 						}
 					}
 				}
 				exitChan <- 0
+			case syscall.SIGHUP:
+				if err := s.reload(ctx); err != nil {
+					logging.Errorf("config reload: %v", err)
+				}
 			default:
-				log.Println("unknown signal.")
+				logging.Warnf("unknown signal.")
 				exitChan <- 1
 			}
 		}
@@ -125,16 +177,30 @@ func (s *server) Start() {
 
 	exitCode := <-exitChan
 	cancel()
-
-	err = s.storage.Close(ctx)
-
-	if err != nil {
+	if err = s.shutdown(); err != nil {
 		log.Fatalln(err)
 	}
 
 	os.Exit(exitCode)
 }
 
+// shutdown stops the workers and closes the storage. The context of Start is cancelled by now,
+// the close gets its own so the buffered storage can still drain into the database.
+func (s *server) shutdown() error {
+	s.store.stop()
+	s.scrape.stop()
+	s.poolStats.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err := s.storage.Close(ctx); err != nil {
+		return fmt.Errorf("Server_shutdown: %w", err)
+	}
+
+	return nil
+}
+
 func (s *server) saveMetric(ctx context.Context, metric metrics.Metric, withHash bool) error {
 	err := metric.Valid()
 	if err != nil {
@@ -142,7 +208,7 @@ func (s *server) saveMetric(ctx context.Context, metric metrics.Metric, withHash
 	}
 
 	if withHash {
-		sign := metric.CalcHash(s.cfg.Key)
+		sign := metric.CalcHash(s.config().Key)
 
 		if sign != metric.Hash {
 			return fmt.Errorf("Server_saveMetric error:%w",
@@ -151,22 +217,7 @@ func (s *server) saveMetric(ctx context.Context, metric metrics.Metric, withHash
 	}
 	switch metric.IsCounter() {
 	case true:
-		_, err := s.storage.GetMetric(ctx, metric.MType, metric.ID)
-		if err != nil {
-			switch {
-			case errors.Is(err, storage.ErrNotFoundMetric):
-				err = s.storage.Save(ctx, metric)
-				if err != nil {
-					return fmt.Errorf("Server_saveMetric error:%w", err)
-				}
-				return nil
-			default:
-				return fmt.Errorf("Server_saveMetric error:%w", err)
-			}
-		}
-
 		err = s.storage.IncrementCounter(ctx, metric.ID, *metric.Delta)
-
 		if err != nil {
 			return fmt.Errorf("Server_saveMetric: %w", err)
 		}
@@ -178,8 +229,11 @@ func (s *server) saveMetric(ctx context.Context, metric metrics.Metric, withHash
 		}
 	}
 
-	if s.cfg != nil && s.cfg.StoreInterval == 0 {
-		_ = s.storage.(storage.MemoryStorage).StoreMetrics()
+	// with the WAL every update is already durable, the snapshot waits for the store interval
+	if cfg := s.config(); cfg.StoreInterval == 0 && cfg.WalFile == "" {
+		if store, ok := s.storage.(storage.MemoryStorage); ok {
+			_ = store.StoreMetrics()
+		}
 	}
 
 	return nil
@@ -192,7 +246,7 @@ func (s *server) getMetric(ctx context.Context, metricType, metricName string, w
 	}
 
 	if withHash {
-		sign := m.CalcHash(s.cfg.Key)
+		sign := m.CalcHash(s.config().Key)
 		m.Hash = sign
 	}
 
@@ -219,7 +273,7 @@ func (s *server) getMetricsList(ctx context.Context, withHash bool) ([]metrics.M
 
 	for _, item := range m.Counter {
 		if withHash {
-			sign := item.CalcHash(s.cfg.Key)
+			sign := item.CalcHash(s.config().Key)
 			item.Hash = sign
 		}
 		metricList = append(metricList, item)
@@ -227,7 +281,7 @@ func (s *server) getMetricsList(ctx context.Context, withHash bool) ([]metrics.M
 
 	for _, item := range m.Gauge {
 		if withHash {
-			sign := item.CalcHash(s.cfg.Key)
+			sign := item.CalcHash(s.config().Key)
 			item.Hash = sign
 		}
 		metricList = append(metricList, item)
@@ -237,14 +291,14 @@ func (s *server) getMetricsList(ctx context.Context, withHash bool) ([]metrics.M
 }
 
 func (s *server) storeMetrics(ctx context.Context) {
-	tick := time.NewTicker(s.cfg.StoreInterval)
+	tick := time.NewTicker(s.config().StoreInterval)
 	defer tick.Stop()
 	for {
 		select {
 		case <-tick.C:
 			err := s.storage.(storage.MemoryStorage).StoreMetrics()
 			if err != nil {
-				log.Println(err)
+				logging.Errorf("%v", err)
 			}
 		case <-ctx.Done():
 			return
@@ -261,17 +315,85 @@ func (s *server) loadMetrics() error {
 }
 
 func (s *server) InitStorage(ctx context.Context) error {
-	if s.cfg.Dsn != "" {
-		storageObj, err := storage.NewPgStorage(ctx, s.cfg.Dsn)
+	if strings.HasPrefix(s.config().Dsn, storage.BoltDSNPrefix) {
+		storageObj, err := storage.NewBoltStorage(strings.TrimPrefix(s.config().Dsn, storage.BoltDSNPrefix))
+		if err != nil {
+			return fmt.Errorf("Server_InitStorage: %w", err)
+		}
+		s.storage = storageObj
+		return nil
+	}
+
+	if strings.HasPrefix(s.config().Dsn, storage.SQLiteDSNPrefix) {
+		storageObj, err := storage.NewSQLiteStorage(ctx, strings.TrimPrefix(s.config().Dsn, storage.SQLiteDSNPrefix))
+		if err != nil {
+			return fmt.Errorf("Server_InitStorage: %w", err)
+		}
+
+		if err = storageObj.ValidateSchema(SQLiteMigrationsURL); err != nil {
+			_ = storageObj.Close(ctx)
+			return fmt.Errorf("Server_InitStorage: %w", err)
+		}
+		s.storage = storageObj
+		return nil
+	}
+
+	if cfg := s.config(); cfg.Dsn != "" {
+		pgOpts := []storage.PgStorageOption{
+			storage.WithPoolSize(cfg.DatabaseMinConns, cfg.DatabaseMaxConns),
+			storage.WithHealthCheckPeriod(cfg.DatabaseHealthCheckPeriod),
+			storage.WithStatementCache(cfg.DatabaseStatementCache),
+		}
+
+		if cfg.StorageMode == StorageModeBuffered {
+			storageObj, err := storage.NewBufferedStorage(ctx, func(ctx context.Context) (storage.DatabaseStorage, error) {
+				pgStorage, err := storage.NewPgStorage(ctx, cfg.Dsn, pgOpts...)
+				if err != nil {
+					return nil, err
+				}
+
+				if err = pgStorage.ValidateSchema(SourceMigrationsURL); err != nil {
+					_ = pgStorage.Close(ctx)
+					return nil, err
+				}
+				return pgStorage, nil
+			})
+			if err != nil {
+				return fmt.Errorf("Server_InitStorage: %w", err)
+			}
+			s.storage = storageObj
+			return nil
+		}
+
+		storageObj, err := storage.NewPgStorage(ctx, cfg.Dsn, pgOpts...)
 		if err == nil {
 			s.storage = storageObj
 			return nil
 		}
 
-		log.Printf("Server_InitStorage: %v", err)
+		if cfg.StorageMode == StorageModeStrict {
+			return fmt.Errorf("Server_InitStorage: %w", err)
+		}
+
+		logging.Warnf("Server_InitStorage: database unavailable, falling back to memory storage: %v", err)
+		s.fallback = true
 	}
 
-	memStorage, err := storage.NewMemoryStorage(s.cfg.StoreFile)
+	cfg := s.config()
+	opts := []storage.MemoryStorageOption{storage.WithSnapshotKeep(cfg.StoreKeep)}
+	if cfg.WalFile != "" {
+		opts = append(opts, storage.WithWAL(cfg.WalFile, cfg.WalSync, cfg.WalSyncInterval))
+	}
+
+	var (
+		memStorage storage.MemoryStorage
+		err        error
+	)
+	if cfg.StoreShards > 0 {
+		memStorage, err = storage.NewShardedMemoryStorage(cfg.StoreFile, cfg.StoreShards, opts...)
+	} else {
+		memStorage, err = storage.NewMemoryStorage(cfg.StoreFile, opts...)
+	}
 	if err != nil {
 		return fmt.Errorf("Server_InitStorage: %w", err)
 	}
@@ -281,11 +403,52 @@ func (s *server) InitStorage(ctx context.Context) error {
 	return nil
 }
 
-func (s *server) pingStorage(ctx context.Context) error {
-	if err := s.storage.(storage.PgStorage).Ping(ctx); err != nil {
-		return fmt.Errorf("Server_pingStorage error: %w", err)
+// pingStorage checks the backends of the storage, the error reports the first unavailable one
+// the updates go to. A database replaced by the buffer or the memory fallback is reported
+// unavailable without an error, the server still serves.
+func (s *server) pingStorage(ctx context.Context) ([]backendState, error) {
+	var (
+		states []backendState
+		err    error
+	)
+
+	state := func(name string, pingErr error) backendState {
+		if pingErr != nil {
+			if err == nil {
+				err = fmt.Errorf("Server_pingStorage: %s: %w", name, pingErr)
+			}
+			return backendState{Name: name, Status: backendUnavailable}
+		}
+		return backendState{Name: name, Status: backendOK}
 	}
-	return nil
+
+	replaced := func(name string, pingErr error) backendState {
+		if pingErr != nil {
+			return backendState{Name: name, Status: backendUnavailable}
+		}
+		return backendState{Name: name, Status: backendOK}
+	}
+
+	switch t := s.storage.(type) {
+	case storage.BufferedStorage:
+		states = append(states, replaced("postgres", t.Ping(ctx)),
+			backendState{Name: "buffer", Status: backendOK, Buffered: t.Buffered()})
+	case storage.PgStorage:
+		states = append(states, state("postgres", t.Ping(ctx)))
+	case storage.SQLiteStorage:
+		states = append(states, state("sqlite", t.Ping(ctx)))
+	case storage.BoltStorage:
+		states = append(states, state("bolt", nil))
+	case storage.MemoryStorage:
+		if s.fallback {
+			states = append(states, replaced("postgres", storage.ErrStorageUnavailable))
+		}
+		states = append(states, state("memory", nil))
+	default:
+		return nil, fmt.Errorf("Server_pingStorage: %w", ErrInvalidStorage)
+	}
+
+	return states, err
 }
 
 func (s *server) batchMetrics(ctx context.Context, m []metrics.Metric, withHash bool) error {
@@ -295,7 +458,7 @@ func (s *server) batchMetrics(ctx context.Context, m []metrics.Metric, withHash
 			if err != nil {
 				return fmt.Errorf("Server_batchMetrics: %w", err)
 			}
-			sign := item.CalcHash(s.cfg.Key)
+			sign := item.CalcHash(s.config().Key)
 
 			if sign != item.Hash {
 				return fmt.Errorf("Server_batchMetric error:%w",
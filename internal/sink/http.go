@@ -0,0 +1,76 @@
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func (s *sink) initRoutes(r *chi.Mux) {
+	r.Post("/update/{metricType}/{metricName}/{metricValue}", s.UpdateMetric)
+	r.Post("/update/", s.UpdateMetricJSON)
+	r.Post("/updates/", s.BatchMetrics)
+}
+
+func (s *sink) UpdateMetric(w http.ResponseWriter, r *http.Request) {
+	m, err := metrics.NewMetric(chi.URLParam(r, "metricName"), chi.URLParam(r, "metricType"),
+		chi.URLParam(r, "metricValue"))
+	if err != nil {
+		logging.Warnf("Sink_UpdateMetric: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.accept(w, []metrics.Metric{m})
+}
+
+func (s *sink) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
+	var m metrics.Metric
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&m); err != nil {
+		logging.Warnf("Sink_UpdateMetricJSON: can't decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.accept(w, []metrics.Metric{m})
+}
+
+func (s *sink) BatchMetrics(w http.ResponseWriter, r *http.Request) {
+	var m []metrics.Metric
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&m); err != nil {
+		logging.Warnf("Sink_BatchMetrics: can't decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.accept(w, m)
+}
+
+// accept validates the whole request before updating, so a batch is applied entirely or not at all.
+func (s *sink) accept(w http.ResponseWriter, m []metrics.Metric) {
+	for _, item := range m {
+		if err := item.Valid(); err != nil || item.ID == "" {
+			logging.Warnf("Sink_accept: invalid metric %s", item.ID)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	for i := range m {
+		m[i].Hash = ""
+		m[i] = s.resolveGauge(m[i], false)
+	}
+
+	s.update(m)
+	w.WriteHeader(http.StatusOK)
+}
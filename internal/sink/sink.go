@@ -0,0 +1,229 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const maxDatagramSize = 65535
+
+var ErrNotLoopback = errors.New("sink address is not a loopback address")
+
+type (
+	// Config of the local endpoints applications push custom metrics to.
+	// An empty address disables the endpoint.
+	Config struct {
+		StatsDAddress string `env:"STATSD_ADDRESS" yaml:"statsd_address"`
+		StatsDSocket  string `env:"STATSD_SOCKET" yaml:"statsd_socket"`
+		HTTPAddress   string `env:"SINK_HTTP_ADDRESS" yaml:"http_address"`
+	}
+
+	Sink interface {
+		Start(ctx context.Context, wg *sync.WaitGroup) error
+	}
+
+	// UpdateFunc receives the metrics accepted by the sink, counters as increments.
+	UpdateFunc func(m []metrics.Metric)
+
+	sink struct {
+		cfg    Config
+		update UpdateFunc
+		mu     sync.Mutex
+		gauges map[string]float64
+	}
+)
+
+func NewSink(cfg Config, update UpdateFunc) Sink {
+	return &sink{
+		cfg:    cfg,
+		update: update,
+		gauges: make(map[string]float64),
+	}
+}
+
+func (c Config) Enabled() bool {
+	return c.StatsDAddress != "" || c.StatsDSocket != "" || c.HTTPAddress != ""
+}
+
+// Valid checks the sink only listens on loopback addresses, it accepts
+// unauthenticated metrics and must not be reachable from other hosts.
+func (c Config) Valid() error {
+	for _, address := range []string{c.StatsDAddress, c.HTTPAddress} {
+		if address == "" {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("Config_Valid: %w", err)
+		}
+
+		if host == "localhost" {
+			continue
+		}
+
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("Config_Valid: %w: %s", ErrNotLoopback, address)
+		}
+	}
+
+	return nil
+}
+
+// Start binds every configured endpoint and serves them until ctx is done.
+func (s *sink) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if err := s.cfg.Valid(); err != nil {
+		return fmt.Errorf("Sink_Start: %w", err)
+	}
+
+	var conns []net.PacketConn
+
+	if s.cfg.StatsDAddress != "" {
+		conn, err := net.ListenPacket("udp", s.cfg.StatsDAddress)
+		if err != nil {
+			return fmt.Errorf("Sink_Start: %w", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	if s.cfg.StatsDSocket != "" {
+		// a socket file left by a previous run prevents binding
+		_ = os.Remove(s.cfg.StatsDSocket)
+		conn, err := net.ListenPacket("unixgram", s.cfg.StatsDSocket)
+		if err != nil {
+			closeConns(conns)
+			return fmt.Errorf("Sink_Start: %w", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	var httpServer *http.Server
+	var listener net.Listener
+	if s.cfg.HTTPAddress != "" {
+		var err error
+		listener, err = net.Listen("tcp", s.cfg.HTTPAddress)
+		if err != nil {
+			closeConns(conns)
+			return fmt.Errorf("Sink_Start: %w", err)
+		}
+
+		r := chi.NewRouter()
+		s.initRoutes(r)
+		httpServer = &http.Server{
+			Handler:           r,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+	}
+
+	for _, conn := range conns {
+		wg.Add(1)
+		go s.serveStatsD(ctx, wg, conn)
+	}
+
+	if httpServer != nil {
+		wg.Add(1)
+		go s.serveHTTP(ctx, wg, httpServer, listener)
+	}
+
+	return nil
+}
+
+func (s *sink) serveStatsD(ctx context.Context, wg *sync.WaitGroup, conn net.PacketConn) {
+	defer wg.Done()
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+		if conn.LocalAddr().Network() == "unixgram" {
+			_ = os.Remove(s.cfg.StatsDSocket)
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Errorf("sink read error: %v", err)
+			}
+			return
+		}
+
+		s.handleStatsD(string(buf[:n]))
+	}
+}
+
+func (s *sink) handleStatsD(packet string) {
+	var accepted []metrics.Metric
+
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		m, relative, err := parseStatsD(line)
+		if err != nil {
+			logging.Errorf("sink: %v", err)
+			continue
+		}
+
+		accepted = append(accepted, s.resolveGauge(m, relative))
+	}
+
+	if len(accepted) != 0 {
+		s.update(accepted)
+	}
+}
+
+// resolveGauge tracks gauge values set through the sink so relative updates can be applied.
+func (s *sink) resolveGauge(m metrics.Metric, relative bool) metrics.Metric {
+	if m.IsCounter() {
+		return m
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value := m.Float64Value()
+	if relative {
+		value += s.gauges[m.ID]
+	}
+	s.gauges[m.ID] = value
+	m.SetFloat64(value)
+
+	return m
+}
+
+func (s *sink) serveHTTP(ctx context.Context, wg *sync.WaitGroup, httpServer *http.Server, listener net.Listener) {
+	defer wg.Done()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Errorf("sink http error: %v", err)
+	}
+}
+
+func closeConns(conns []net.PacketConn) {
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,178 @@
+package sink
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+type testReceiver struct {
+	mu       sync.Mutex
+	received []metrics.Metric
+}
+
+func (r *testReceiver) update(m []metrics.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, m...)
+}
+
+func (r *testReceiver) metrics() []metrics.Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]metrics.Metric(nil), r.received...)
+}
+
+func TestConfig_Valid(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name: "loopback addresses",
+			cfg: Config{
+				StatsDAddress: "127.0.0.1:8125",
+				HTTPAddress:   "localhost:8126",
+			},
+			wantErr: false,
+		},
+
+		{
+			name:    "ipv6 loopback",
+			cfg:     Config{StatsDAddress: "[::1]:8125"},
+			wantErr: false,
+		},
+
+		{
+			name:    "public address",
+			cfg:     Config{HTTPAddress: "0.0.0.0:8126"},
+			wantErr: true,
+		},
+
+		{
+			name:    "invalid address",
+			cfg:     Config{StatsDAddress: "invalid"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Valid()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_sink_StatsD(t *testing.T) {
+	receiver := new(testReceiver)
+	cfg := Config{
+		StatsDAddress: "127.0.0.1:0",
+		StatsDSocket:  filepath.Join(t.TempDir(), "statsd.sock"),
+	}
+	s := NewSink(cfg, receiver.update).(*sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := new(sync.WaitGroup)
+	require.NoError(t, s.Start(ctx, wg))
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	conn, err := net.Dial("unixgram", cfg.StatsDSocket)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("requests:2|c\nqueue:10|g\nqueue:+5|g\ninvalid\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(receiver.metrics()) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	received := receiver.metrics()
+	assert.Equal(t, int64(2), received[0].Int64Value())
+	assert.Equal(t, float64(10), received[1].Float64Value())
+	assert.Equal(t, float64(15), received[2].Float64Value())
+}
+
+func Test_sink_HTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		uri        string
+		body       string
+		statusCode int
+		received   int
+	}{
+		{
+			name:       "update counter",
+			uri:        "/update/counter/jobs/3",
+			statusCode: http.StatusOK,
+			received:   1,
+		},
+
+		{
+			name:       "update gauge json",
+			uri:        "/update/",
+			body:       `{"id":"queue","type":"gauge","value":4.5}`,
+			statusCode: http.StatusOK,
+			received:   1,
+		},
+
+		{
+			name:       "batch",
+			uri:        "/updates/",
+			body:       `[{"id":"jobs","type":"counter","delta":1},{"id":"queue","type":"gauge","value":1}]`,
+			statusCode: http.StatusOK,
+			received:   2,
+		},
+
+		{
+			name:       "batch with invalid metric",
+			uri:        "/updates/",
+			body:       `[{"id":"jobs","type":"counter","delta":1},{"id":"queue","type":"gauge"}]`,
+			statusCode: http.StatusBadRequest,
+			received:   0,
+		},
+
+		{
+			name:       "invalid type",
+			uri:        "/update/histogram/latency/3",
+			statusCode: http.StatusBadRequest,
+			received:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := new(testReceiver)
+			s := NewSink(Config{}, receiver.update).(*sink)
+			r := chi.NewRouter()
+			s.initRoutes(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			resp, err := ts.Client().Post(ts.URL+tt.uri, "application/json", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Len(t, receiver.metrics(), tt.received)
+		})
+	}
+}
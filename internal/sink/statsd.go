@@ -0,0 +1,60 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+var ErrInvalidLine = errors.New("invalid statsd line")
+
+// parseStatsD parses a single StatsD line "name:value|type[|@rate]". Counters ("c")
+// are scaled by the sample rate, gauges ("g") prefixed with a sign are relative
+// to the previous value and returned with relative set.
+func parseStatsD(line string) (m metrics.Metric, relative bool, err error) {
+	name, rest, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return m, false, fmt.Errorf("parseStatsD: %w: %q", ErrInvalidLine, line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return m, false, fmt.Errorf("parseStatsD: %w: %q", ErrInvalidLine, line)
+	}
+
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return m, false, fmt.Errorf("parseStatsD: %w: %q", ErrInvalidLine, line)
+	}
+
+	rate := 1.0
+	for _, option := range parts[2:] {
+		if !strings.HasPrefix(option, "@") {
+			continue
+		}
+		rate, err = strconv.ParseFloat(option[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return m, false, fmt.Errorf("parseStatsD: %w: %q", ErrInvalidLine, line)
+		}
+	}
+
+	m.ID = name
+	switch parts[1] {
+	case "c":
+		m.MType = metrics.CounterStrName
+		m.SetInt64(int64(math.Round(value / rate)))
+	case "g":
+		m.MType = metrics.GaugeStrName
+		m.SetFloat64(value)
+		relative = strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-")
+	default:
+		return m, false, fmt.Errorf("parseStatsD: %w: unsupported type %q",
+			ErrInvalidLine, parts[1])
+	}
+
+	return m, relative, nil
+}
@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func Test_parseStatsD(t *testing.T) {
+	tests := []struct {
+		name         string
+		line         string
+		wantType     string
+		wantDelta    int64
+		wantValue    float64
+		wantRelative bool
+		wantErr      bool
+	}{
+		{
+			name:      "counter",
+			line:      "requests:3|c",
+			wantType:  metrics.CounterStrName,
+			wantDelta: 3,
+		},
+
+		{
+			name:      "sampled counter",
+			line:      "requests:1|c|@0.1",
+			wantType:  metrics.CounterStrName,
+			wantDelta: 10,
+		},
+
+		{
+			name:      "gauge",
+			line:      "queue:17.5|g",
+			wantType:  metrics.GaugeStrName,
+			wantValue: 17.5,
+		},
+
+		{
+			name:         "relative gauge",
+			line:         "queue:-2|g",
+			wantType:     metrics.GaugeStrName,
+			wantValue:    -2,
+			wantRelative: true,
+		},
+
+		{
+			name:    "unsupported type",
+			line:    "latency:320|ms",
+			wantErr: true,
+		},
+
+		{
+			name:    "invalid value",
+			line:    "requests:none|c",
+			wantErr: true,
+		},
+
+		{
+			name:    "invalid sample rate",
+			line:    "requests:1|c|@2",
+			wantErr: true,
+		},
+
+		{
+			name:    "missing type",
+			line:    "requests:1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, relative, err := parseStatsD(tt.line)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLine)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantType, m.MType)
+			assert.Equal(t, tt.wantDelta, m.Int64Value())
+			assert.Equal(t, tt.wantValue, m.Float64Value())
+			assert.Equal(t, tt.wantRelative, relative)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// BoltDSNPrefix selects the bolt storage in the database DSN, e.g. bolt:///var/lib/yametrics/metrics.db.
+const BoltDSNPrefix = "bolt://"
+
+// BoltOpenTimeout bounds the wait for the file lock held by another process.
+var BoltOpenTimeout = time.Second
+
+// NewBoltStorage opens the bbolt database at path, creating it when missing. Metrics are kept in
+// a bucket per type keyed by ID, every update is a bbolt transaction and is durable on return.
+func NewBoltStorage(path string) (BoltStorage, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: BoltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("NewBoltStorage: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{metrics.CounterStrName, metrics.GaugeStrName} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("NewBoltStorage: %w", err)
+	}
+
+	logging.Infof("NewBoltStorage: success open %s", path)
+
+	return &boltStorage{db: db}, nil
+}
+
+// bucket returns the bucket of the metric type, an unknown type is an ErrInvalidMetricType.
+func bucket(tx *bolt.Tx, metricType string) (*bolt.Bucket, error) {
+	if metricType != metrics.CounterStrName && metricType != metrics.GaugeStrName {
+		return nil, metrics.NewMetricError(metricType, "", metrics.ErrInvalidMetricType)
+	}
+
+	return tx.Bucket([]byte(metricType)), nil
+}
+
+func getMetric(b *bolt.Bucket, metricID string) (*metrics.Metric, error) {
+	data := b.Get([]byte(metricID))
+	if data == nil {
+		return nil, fmt.Errorf("%s: %w", metricID, ErrNotFoundMetric)
+	}
+
+	m := new(metrics.Metric)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: can't decode metric: %w", metricID, err)
+	}
+
+	return m, nil
+}
+
+func putMetric(b *bolt.Bucket, metric metrics.Metric) error {
+	metric.Hash = ""
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return fmt.Errorf("%s: can't encode metric: %w", metric.ID, err)
+	}
+
+	return b.Put([]byte(metric.ID), data)
+}
+
+func (s *boltStorage) Save(ctx context.Context, metric metrics.Metric) error {
+	_ = ctx
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, metric.MType)
+		if err != nil {
+			return err
+		}
+		return putMetric(b, metric)
+	})
+	if err != nil {
+		return fmt.Errorf("boltStorage_Save: %w", err)
+	}
+
+	return nil
+}
+
+func (s *boltStorage) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
+	_ = ctx
+	var m *metrics.Metric
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, metricType)
+		if err != nil {
+			return err
+		}
+		m, err = getMetric(b, metricID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("boltStorage_GetMetric: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *boltStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
+	_ = ctx
+	m := metrics.Metrics{
+		Counter: make(map[string]metrics.Metric),
+		Gauge:   make(map[string]metrics.Metric),
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for metricType, items := range map[string]map[string]metrics.Metric{
+			metrics.CounterStrName: m.Counter,
+			metrics.GaugeStrName:   m.Gauge,
+		} {
+			err := tx.Bucket([]byte(metricType)).ForEach(func(k, v []byte) error {
+				var metric metrics.Metric
+				if err := json.Unmarshal(v, &metric); err != nil {
+					return fmt.Errorf("%s: can't decode metric: %w", k, err)
+				}
+				items[metric.ID] = metric
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("boltStorage_GetMetrics: %w", err)
+	}
+
+	return &m, nil
+}
+
+// IncrementCounter adds the value in a single read-modify-write transaction, bbolt runs the
+// writing transactions one at a time so concurrent increments are not lost. A missing counter
+// is created.
+func (s *boltStorage) IncrementCounter(ctx context.Context, metricID string, value int64) error {
+	_ = ctx
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(metrics.CounterStrName))
+		m, err := getMetric(b, metricID)
+		switch {
+		case errors.Is(err, ErrNotFoundMetric):
+			m = &metrics.Metric{ID: metricID, MType: metrics.CounterStrName}
+		case err != nil:
+			return err
+		}
+
+		m.SetInt64(m.Int64Value() + value)
+		return putMetric(b, *m)
+	})
+	if err != nil {
+		return fmt.Errorf("boltStorage_IncrementCounter: %w", err)
+	}
+
+	return nil
+}
+
+// BatchMetrics applies the metrics in one transaction, nothing is stored when one of them fails.
+func (s *boltStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
+	_ = ctx
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, metric := range m {
+			b, err := bucket(tx, metric.MType)
+			if err != nil {
+				return metrics.NewMetricError(metric.MType, metric.ID, metrics.ErrInvalidMetricType)
+			}
+
+			if metric.MType == metrics.CounterStrName {
+				stored, err := getMetric(b, metric.ID)
+				switch {
+				case err == nil:
+					metric.SetInt64(stored.Int64Value() + metric.Int64Value())
+				case !errors.Is(err, ErrNotFoundMetric):
+					return err
+				}
+			}
+
+			if err = putMetric(b, metric); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("boltStorage_BatchMetrics: %w", err)
+	}
+
+	return nil
+}
+
+func (s *boltStorage) Close(ctx context.Context) error {
+	_ = ctx
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("boltStorage_Close: %w", err)
+	}
+
+	return nil
+}
+
+// Path is the database file.
+func (s *boltStorage) Path() string {
+	return s.db.Path()
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func TestBoltStorage_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	s, err := NewBoltStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "10")))
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+	require.NoError(t, s.Save(ctx, testMetric(t, "Alloc", "gauge", "1.5")))
+	require.NoError(t, s.Close(ctx))
+
+	reopened, err := NewBoltStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close(ctx)
+
+	assert.Equal(t, int64(15), counterValue(t, reopened, "PollCount"))
+	assert.Equal(t, 1.5, gaugeValue(t, reopened, "Alloc"))
+
+	m, err := reopened.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, m.Counter, 1)
+	assert.Len(t, m.Gauge, 1)
+}
+
+func TestBoltStorage_BatchMetrics(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewBoltStorage(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer s.Close(ctx)
+
+	require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", "counter", "1"),
+		testMetric(t, "PollCount", "counter", "2"),
+		testMetric(t, "Alloc", "gauge", "1.5"),
+	}))
+	assert.Equal(t, int64(3), counterValue(t, s, "PollCount"))
+
+	invalid := testMetric(t, "Alloc", "gauge", "2.5")
+	invalid.MType = "unknown"
+	err = s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", "counter", "10"),
+		invalid,
+	})
+	var metricErr *metrics.ErrMetric
+	require.ErrorAs(t, err, &metricErr)
+	assert.ErrorIs(t, metricErr.MetricError, metrics.ErrInvalidMetricType)
+	assert.Equal(t, int64(3), counterValue(t, s, "PollCount"), "failed batch is rolled back")
+}
+
+func TestBoltStorage_IncrementCounter(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewBoltStorage(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer s.Close(ctx)
+
+	// a missing counter is created
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 1))
+	assert.Equal(t, int64(1), counterValue(t, s, "PollCount"))
+
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "0")))
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.IncrementCounter(ctx, "PollCount", 1))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(20), counterValue(t, s, "PollCount"))
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+type (
+	// updateBuffer holds the updates of the buffered storage while the database is unreachable.
+	// A counter written with Save replaces the stored value, so it's kept apart from the
+	// increments, which are added to the stored value.
+	updateBuffer struct {
+		mu       sync.RWMutex
+		counters map[string]bufferedCounter
+		gauges   map[string]float64
+	}
+
+	// bufferedCounter is the value to save when absolute, the increment to add otherwise.
+	bufferedCounter struct {
+		value    int64
+		absolute bool
+	}
+)
+
+func newUpdateBuffer() *updateBuffer {
+	return &updateBuffer{counters: make(map[string]bufferedCounter), gauges: make(map[string]float64)}
+}
+
+func (b *updateBuffer) Save(ctx context.Context, metric metrics.Metric) error {
+	_ = ctx
+	if err := validMetricType(metric.MType, metric.ID); err != nil {
+		return fmt.Errorf("updateBuffer_Save: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if metric.MType == metrics.GaugeStrName {
+		b.gauges[metric.ID] = metric.Float64Value()
+		return nil
+	}
+	b.counters[metric.ID] = bufferedCounter{value: metric.Int64Value(), absolute: true}
+
+	return nil
+}
+
+// add adds to the counter, an absolute one stays absolute. The caller holds the lock.
+func (b *updateBuffer) add(metricID string, value int64) {
+	counter := b.counters[metricID]
+	counter.value += value
+	b.counters[metricID] = counter
+}
+
+func (b *updateBuffer) IncrementCounter(ctx context.Context, metricID string, value int64) error {
+	_ = ctx
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.add(metricID, value)
+	return nil
+}
+
+func (b *updateBuffer) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
+	_ = ctx
+	for _, metric := range m {
+		if err := validMetricType(metric.MType, metric.ID); err != nil {
+			return fmt.Errorf("updateBuffer_BatchMetrics: %w", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, metric := range m {
+		if metric.MType == metrics.CounterStrName {
+			b.add(metric.ID, metric.Int64Value())
+			continue
+		}
+		b.gauges[metric.ID] = metric.Float64Value()
+	}
+
+	return nil
+}
+
+// GetMetric returns the buffered value, for an increment it's the change since the database
+// became unreachable.
+func (b *updateBuffer) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
+	_ = ctx
+	if err := validMetricType(metricType, metricID); err != nil {
+		return nil, fmt.Errorf("updateBuffer_GetMetric: %w", err)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	metric := metrics.Metric{ID: metricID, MType: metricType}
+	if metricType == metrics.GaugeStrName {
+		value, exist := b.gauges[metricID]
+		if !exist {
+			return nil, fmt.Errorf("%s: %w", metricID, ErrNotFoundMetric)
+		}
+		metric.SetFloat64(value)
+		return &metric, nil
+	}
+
+	counter, exist := b.counters[metricID]
+	if !exist {
+		return nil, fmt.Errorf("%s: %w", metricID, ErrNotFoundMetric)
+	}
+	metric.SetInt64(counter.value)
+
+	return &metric, nil
+}
+
+func (b *updateBuffer) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
+	_ = ctx
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	m := metrics.Metrics{
+		Counter: make(map[string]metrics.Metric, len(b.counters)),
+		Gauge:   make(map[string]metrics.Metric, len(b.gauges)),
+	}
+	for id, counter := range b.counters {
+		metric := metrics.Metric{ID: id, MType: metrics.CounterStrName}
+		metric.SetInt64(counter.value)
+		m.Counter[id] = metric
+	}
+	for id, value := range b.gauges {
+		metric := metrics.Metric{ID: id, MType: metrics.GaugeStrName}
+		metric.SetFloat64(value)
+		m.Gauge[id] = metric
+	}
+
+	return &m, nil
+}
+
+func (b *updateBuffer) Close(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+func (b *updateBuffer) len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.counters) + len(b.gauges)
+}
+
+// send writes the buffer to the database: the absolute counters with Save, then the increments
+// and the gauges with one BatchMetrics. Sending a buffer again after a failure is safe as long as
+// the batch wasn't applied, the saves are idempotent.
+func (b *updateBuffer) send(ctx context.Context, db Storage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	batch := make([]metrics.Metric, 0, len(b.counters)+len(b.gauges))
+	for id, counter := range b.counters {
+		metric := metrics.Metric{ID: id, MType: metrics.CounterStrName}
+		metric.SetInt64(counter.value)
+		if !counter.absolute {
+			batch = append(batch, metric)
+			continue
+		}
+
+		if err := db.Save(ctx, metric); err != nil {
+			return fmt.Errorf("updateBuffer_send: %w", err)
+		}
+	}
+	for id, value := range b.gauges {
+		metric := metrics.Metric{ID: id, MType: metrics.GaugeStrName}
+		metric.SetFloat64(value)
+		batch = append(batch, metric)
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := db.BatchMetrics(ctx, batch); err != nil {
+		return fmt.Errorf("updateBuffer_send: %w", err)
+	}
+
+	return nil
+}
+
+// merge applies the updates of newer, buffered after the ones of b, on top of b.
+func (b *updateBuffer) merge(newer *updateBuffer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	newer.mu.RLock()
+	defer newer.mu.RUnlock()
+
+	for id, counter := range newer.counters {
+		if counter.absolute {
+			b.counters[id] = counter
+			continue
+		}
+		b.add(id, counter.value)
+	}
+	for id, value := range newer.gauges {
+		b.gauges[id] = value
+	}
+}
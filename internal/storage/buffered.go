@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// BufferedDrainInterval is how often the buffered storage tries to reach the database and
+// to drain the buffered updates into it.
+var BufferedDrainInterval = 5 * time.Second
+
+// bufferedDrainRounds bounds the sends of a drain, the updates buffered during the last round
+// are sent blocking the new ones so the drain ends.
+const bufferedDrainRounds = 3
+
+type (
+	// DatabaseStorage is a storage on a database server which can be unreachable, e.g. PgStorage.
+	DatabaseStorage interface {
+		Storage
+		Ping(ctx context.Context) error
+	}
+
+	// ConnectFunc opens the database of the buffered storage, including the schema migration.
+	ConnectFunc func(ctx context.Context) (DatabaseStorage, error)
+
+	bufferedStorage struct {
+		// mu guards the switch between db and buffer, updates hold it for reading so a drain
+		// never misses an update written to the buffer meanwhile.
+		mu        sync.RWMutex
+		db        DatabaseStorage
+		buffering bool
+		buffer    *updateBuffer
+		// sending is the number of metrics of the buffer a drain is sending
+		sending int
+		drainMu sync.Mutex
+		connect ConnectFunc
+		cancel  context.CancelFunc
+		wg      sync.WaitGroup
+	}
+)
+
+// NewBufferedStorage writes to the database returned by connect and keeps the updates in memory
+// while the database is unreachable, at startup or later. The buffered updates are drained into
+// the database once it's reachable again: the counters written with Save replace the stored
+// values, the increments buffered in the meantime are added to them.
+//
+// Reads while buffering see only the updates buffered and not being drained. An update whose
+// connection broke after it was sent may be applied by the database and buffered as well.
+func NewBufferedStorage(ctx context.Context, connect ConnectFunc) (BufferedStorage, error) {
+	var err error
+	s := &bufferedStorage{buffer: newUpdateBuffer(), connect: connect}
+	if s.db, err = connect(ctx); err != nil {
+		logging.Warnf("NewBufferedStorage: database unavailable, buffering updates: %v", err)
+		s.buffering = true
+	}
+
+	var loopCtx context.Context
+	loopCtx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		tick := time.NewTicker(BufferedDrainInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				if drainErr := s.drain(loopCtx); drainErr != nil {
+					logging.Errorf("%v", drainErr)
+				}
+			case <-loopCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+// target is the storage the updates go to now, the caller holds mu.
+func (s *bufferedStorage) target() Storage {
+	if s.buffering {
+		return s.buffer
+	}
+	return s.db
+}
+
+// unreachable reports whether err of db means the database is down, in which case the storage
+// switches to buffering. Errors reported by the database server itself are returned as is.
+func unreachable(ctx context.Context, db DatabaseStorage, err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) || errors.Is(err, ErrNotFoundMetric) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var metricErr *metrics.ErrMetric
+	if errors.As(err, &metricErr) {
+		return false
+	}
+
+	return db.Ping(ctx) != nil
+}
+
+// do runs op on the current target and retries it on the buffer when the database turns out
+// to be unreachable.
+func (s *bufferedStorage) do(ctx context.Context, op func(target Storage) error) error {
+	s.mu.RLock()
+	db, buffering := s.db, s.buffering
+	err := op(s.target())
+	s.mu.RUnlock()
+
+	if err == nil || buffering || !unreachable(ctx, db, err) {
+		return err
+	}
+
+	s.mu.Lock()
+	if !s.buffering {
+		logging.Warnf("bufferedStorage: database unavailable, buffering updates: %v", err)
+		s.buffering = true
+	}
+	s.mu.Unlock()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return op(s.target())
+}
+
+// drain connects the database when needed and moves the buffered updates into it. The buffer
+// is swapped for an empty one and sent without blocking the updates, which go to the new buffer.
+// A failed send is merged back under the updates made meanwhile.
+func (s *bufferedStorage) drain(ctx context.Context) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	s.mu.RLock()
+	db, buffering := s.db, s.buffering
+	s.mu.RUnlock()
+
+	if !buffering {
+		return nil
+	}
+
+	if db == nil {
+		var err error
+		if db, err = s.connect(ctx); err != nil {
+			return fmt.Errorf("bufferedStorage_drain: %w", err)
+		}
+	} else if err := db.Ping(ctx); err != nil {
+		return fmt.Errorf("bufferedStorage_drain: %w", err)
+	}
+
+	s.mu.Lock()
+	s.db = db
+	s.mu.Unlock()
+
+	drained := 0
+	for round := 1; round < bufferedDrainRounds; round++ {
+		s.mu.Lock()
+		pending, size := s.buffer, s.buffer.len()
+		if size == 0 {
+			s.mu.Unlock()
+			break
+		}
+		s.buffer, s.sending = newUpdateBuffer(), size
+		s.mu.Unlock()
+
+		err := pending.send(ctx, db)
+
+		s.mu.Lock()
+		s.sending = 0
+		if err != nil {
+			pending.merge(s.buffer)
+			s.buffer = pending
+			s.mu.Unlock()
+			return fmt.Errorf("bufferedStorage_drain: %w", err)
+		}
+		s.mu.Unlock()
+		drained += size
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the last round holds the lock, the storage switches to the database with an empty buffer
+	size := s.buffer.len()
+	if err := s.buffer.send(ctx, db); err != nil {
+		return fmt.Errorf("bufferedStorage_drain: %w", err)
+	}
+	s.buffer = newUpdateBuffer()
+	s.buffering = false
+	logging.Infof("bufferedStorage: database available, drained %d metrics", drained+size)
+
+	return nil
+}
+
+func (s *bufferedStorage) Save(ctx context.Context, metric metrics.Metric) error {
+	err := s.do(ctx, func(target Storage) error {
+		return target.Save(ctx, metric)
+	})
+	if err != nil {
+		return fmt.Errorf("bufferedStorage_Save: %w", err)
+	}
+
+	return nil
+}
+
+func (s *bufferedStorage) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
+	var m *metrics.Metric
+	err := s.do(ctx, func(target Storage) error {
+		var err error
+		m, err = target.GetMetric(ctx, metricType, metricID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bufferedStorage_GetMetric: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *bufferedStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
+	var m *metrics.Metrics
+	err := s.do(ctx, func(target Storage) error {
+		var err error
+		m, err = target.GetMetrics(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bufferedStorage_GetMetrics: %w", err)
+	}
+
+	return m, nil
+}
+
+// IncrementCounter adds to the buffered counter while buffering, the buffer holds the change
+// since the database became unreachable.
+func (s *bufferedStorage) IncrementCounter(ctx context.Context, metricID string, value int64) error {
+	err := s.do(ctx, func(target Storage) error {
+		return target.IncrementCounter(ctx, metricID, value)
+	})
+	if err != nil {
+		return fmt.Errorf("bufferedStorage_IncrementCounter: %w", err)
+	}
+
+	return nil
+}
+
+func (s *bufferedStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
+	err := s.do(ctx, func(target Storage) error {
+		return target.BatchMetrics(ctx, m)
+	})
+	if err != nil {
+		return fmt.Errorf("bufferedStorage_BatchMetrics: %w", err)
+	}
+
+	return nil
+}
+
+// Ping reports the state of the database, the storage accepts updates either way.
+func (s *bufferedStorage) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	db := s.db
+	s.mu.RUnlock()
+
+	if db == nil {
+		return fmt.Errorf("bufferedStorage_Ping: %w", ErrStorageUnavailable)
+	}
+
+	if err := db.Ping(ctx); err != nil {
+		return fmt.Errorf("bufferedStorage_Ping: %w", err)
+	}
+
+	return nil
+}
+
+// Buffered is the number of metrics waiting for the database, including the ones being drained.
+func (s *bufferedStorage) Buffered() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.buffer.len() + s.sending
+}
+
+// Close drains the buffer one last time, the updates still buffered are lost.
+func (s *bufferedStorage) Close(ctx context.Context) error {
+	s.cancel()
+	s.wg.Wait()
+
+	if err := s.drain(ctx); err != nil {
+		logging.Errorf("bufferedStorage_Close: %d buffered metrics lost: %v", s.Buffered(), err)
+	}
+
+	s.mu.RLock()
+	db := s.db
+	s.mu.RUnlock()
+
+	if db == nil {
+		return nil
+	}
+
+	if err := db.Close(ctx); err != nil {
+		return fmt.Errorf("bufferedStorage_Close: %w", err)
+	}
+
+	return nil
+}
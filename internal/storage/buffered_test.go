@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// fakeDatabase is a memory storage which fails like an unreachable database while down.
+// beforeBatch, when set, runs at the start of BatchMetrics.
+type fakeDatabase struct {
+	Storage
+	beforeBatch func()
+	mu          sync.RWMutex
+	down        bool
+}
+
+func newFakeDatabase(t *testing.T) *fakeDatabase {
+	t.Helper()
+	s, err := NewMemoryStorage("")
+	require.NoError(t, err)
+	return &fakeDatabase{Storage: s}
+}
+
+func (d *fakeDatabase) setDown(down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down = down
+}
+
+func (d *fakeDatabase) err() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.down {
+		return fmt.Errorf("dial tcp: connection refused: %w", ErrStorageUnavailable)
+	}
+	return nil
+}
+
+func (d *fakeDatabase) Ping(ctx context.Context) error {
+	_ = ctx
+	return d.err()
+}
+
+func (d *fakeDatabase) Save(ctx context.Context, metric metrics.Metric) error {
+	if err := d.err(); err != nil {
+		return err
+	}
+	return d.Storage.Save(ctx, metric)
+}
+
+func (d *fakeDatabase) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
+	if err := d.err(); err != nil {
+		return nil, err
+	}
+	return d.Storage.GetMetric(ctx, metricType, metricID)
+}
+
+func (d *fakeDatabase) IncrementCounter(ctx context.Context, metricID string, value int64) error {
+	if err := d.err(); err != nil {
+		return err
+	}
+	return d.Storage.IncrementCounter(ctx, metricID, value)
+}
+
+func (d *fakeDatabase) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
+	if d.beforeBatch != nil {
+		d.beforeBatch()
+	}
+	if err := d.err(); err != nil {
+		return err
+	}
+	return d.Storage.BatchMetrics(ctx, m)
+}
+
+func newTestBufferedStorage(t *testing.T, db *fakeDatabase) *bufferedStorage {
+	t.Helper()
+	s, err := NewBufferedStorage(context.Background(), func(ctx context.Context) (DatabaseStorage, error) {
+		if err := db.err(); err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close(context.Background()) })
+	return s.(*bufferedStorage)
+}
+
+func TestBufferedStorage_DatabaseDownAtStartup(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDatabase(t)
+	require.NoError(t, db.Storage.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "10")))
+	db.setDown(true)
+
+	s := newTestBufferedStorage(t, db)
+	assert.ErrorIs(t, s.Ping(ctx), ErrStorageUnavailable)
+
+	require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", metrics.CounterStrName, "2"),
+		testMetric(t, "Alloc", metrics.GaugeStrName, "1.5"),
+	}))
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 3))
+	assert.Equal(t, 2, s.Buffered())
+
+	assert.Error(t, s.drain(ctx), "database still down")
+	assert.Equal(t, 2, s.Buffered())
+
+	db.setDown(false)
+	require.NoError(t, s.drain(ctx))
+	assert.NoError(t, s.Ping(ctx))
+	assert.Equal(t, 0, s.Buffered())
+
+	assert.Equal(t, int64(15), counterValue(t, db, "PollCount"), "buffered increments are added")
+	assert.Equal(t, 1.5, gaugeValue(t, db, "Alloc"))
+	assert.Equal(t, int64(15), counterValue(t, s, "PollCount"), "reads go to the database again")
+}
+
+func TestBufferedStorage_DatabaseDownLater(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDatabase(t)
+	s := newTestBufferedStorage(t, db)
+
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "1")))
+	assert.Equal(t, 0, s.Buffered())
+
+	db.setDown(true)
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 4))
+	require.NoError(t, s.Save(ctx, testMetric(t, "Alloc", metrics.GaugeStrName, "2.5")))
+	assert.Equal(t, 2, s.Buffered())
+	assert.Error(t, s.Ping(ctx))
+
+	db.setDown(false)
+	require.NoError(t, s.drain(ctx))
+	assert.Equal(t, int64(5), counterValue(t, s, "PollCount"))
+	assert.Equal(t, 2.5, gaugeValue(t, s, "Alloc"))
+}
+
+func TestBufferedStorage_SaveIsAbsolute(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDatabase(t)
+	require.NoError(t, db.Storage.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "10")))
+	db.setDown(true)
+	s := newTestBufferedStorage(t, db)
+
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "3")))
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 2))
+	require.NoError(t, s.IncrementCounter(ctx, "Requests", 4))
+
+	db.setDown(false)
+	require.NoError(t, s.drain(ctx))
+	assert.Equal(t, int64(5), counterValue(t, db, "PollCount"), "a saved counter replaces the stored value")
+	assert.Equal(t, int64(4), counterValue(t, db, "Requests"))
+}
+
+func TestBufferedStorage_DrainDoesNotBlockUpdates(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDatabase(t)
+	db.setDown(true)
+	s := newTestBufferedStorage(t, db)
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 1))
+
+	started, release := make(chan struct{}, bufferedDrainRounds), make(chan struct{})
+	db.beforeBatch = func() {
+		started <- struct{}{}
+		<-release
+	}
+	db.setDown(false)
+	drained := make(chan error)
+	go func() { drained <- s.drain(ctx) }()
+	<-started
+
+	updated := make(chan error)
+	go func() { updated <- s.IncrementCounter(ctx, "PollCount", 2) }()
+	select {
+	case err := <-updated:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the update waits for the database")
+	}
+	assert.Equal(t, 2, s.Buffered(), "the metrics being sent are counted")
+
+	close(release)
+	require.NoError(t, <-drained)
+	assert.Equal(t, 0, s.Buffered())
+	assert.Equal(t, int64(3), counterValue(t, db, "PollCount"))
+}
+
+func TestBufferedStorage_DrainFailureMergesBack(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDatabase(t)
+	db.setDown(true)
+	s := newTestBufferedStorage(t, db)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "10")))
+	require.NoError(t, s.IncrementCounter(ctx, "Requests", 1))
+	require.NoError(t, s.Save(ctx, testMetric(t, "Alloc", metrics.GaugeStrName, "1")))
+
+	db.beforeBatch = func() {
+		// updates made while the batch is sent, then the database goes down
+		assert.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+		assert.NoError(t, s.IncrementCounter(ctx, "Requests", 2))
+		assert.NoError(t, s.Save(ctx, testMetric(t, "Alloc", metrics.GaugeStrName, "2")))
+		db.setDown(true)
+	}
+	db.setDown(false)
+	assert.Error(t, s.drain(ctx))
+	assert.Equal(t, 3, s.Buffered())
+
+	db.beforeBatch = nil
+	db.setDown(false)
+	require.NoError(t, s.drain(ctx))
+	assert.Equal(t, int64(15), counterValue(t, db, "PollCount"), "the increments stay on top of the saved value")
+	assert.Equal(t, int64(3), counterValue(t, db, "Requests"))
+	assert.Equal(t, 2.0, gaugeValue(t, db, "Alloc"), "the newer gauge wins")
+}
+
+func TestBufferedStorage_ErrorsNotBuffered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBufferedStorage(t, newFakeDatabase(t))
+
+	_, err := s.GetMetric(ctx, metrics.CounterStrName, "Missing")
+	assert.ErrorIs(t, err, ErrNotFoundMetric)
+	assert.Equal(t, 0, s.Buffered())
+	assert.False(t, s.buffering, "an error of a reachable database doesn't switch to the buffer")
+}
+
+func TestBufferedStorage_ConcurrentDrain(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDatabase(t)
+	require.NoError(t, db.Storage.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "0")))
+	db.setDown(true)
+	s := newTestBufferedStorage(t, db)
+
+	const writers, increments = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				if i == 0 && j == increments/2 {
+					db.setDown(false)
+					assert.NoError(t, s.drain(ctx))
+				}
+				assert.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+					testMetric(t, "PollCount", metrics.CounterStrName, "1"),
+				}))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, s.drain(ctx))
+	assert.Equal(t, int64(writers*increments), counterValue(t, db, "PollCount"), "no update is lost by the drain")
+}
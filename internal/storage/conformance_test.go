@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+	"github.com/sreway/yametrics/internal/testutil"
+)
+
+// conformanceBackend returns the opener of a storage, each call opens a storage on the same
+// data so the scenarios can check what survives a restart. The previous storage is closed first.
+type conformanceBackend func(t *testing.T) func() Storage
+
+// conformanceBackends are all the Storage implementations. Postgres runs against
+// TEST_DATABASE_DSN when it's set and against the in-process stand-in otherwise.
+var conformanceBackends = map[string]conformanceBackend{
+	"memory": func(t *testing.T) func() Storage {
+		path, restore := filepath.Join(t.TempDir(), "metrics.json"), false
+		return func() Storage {
+			defer func() { restore = true }()
+			return openTestMemoryStorage(t, path, restore)
+		}
+	},
+	"memory-wal": func(t *testing.T) func() Storage {
+		dir, restore := t.TempDir(), false
+		return func() Storage {
+			defer func() { restore = true }()
+			return openTestMemoryStorage(t, filepath.Join(dir, "metrics.json"), restore,
+				WithWAL(filepath.Join(dir, "metrics.wal"), WALSyncNever, 0))
+		}
+	},
+	"sharded": func(t *testing.T) func() Storage {
+		path, restore := filepath.Join(t.TempDir(), "metrics.json"), false
+		return func() Storage {
+			defer func() { restore = true }()
+			return openTestShardedStorage(t, path, restore)
+		}
+	},
+	"sharded-wal": func(t *testing.T) func() Storage {
+		dir, restore := t.TempDir(), false
+		return func() Storage {
+			defer func() { restore = true }()
+			return openTestShardedStorage(t, filepath.Join(dir, "metrics.json"), restore,
+				WithWAL(filepath.Join(dir, "metrics.wal"), WALSyncNever, 0))
+		}
+	},
+	"bolt": func(t *testing.T) func() Storage {
+		path := filepath.Join(t.TempDir(), "metrics.db")
+		return func() Storage {
+			s, err := NewBoltStorage(path)
+			require.NoError(t, err)
+			return s
+		}
+	},
+	"sqlite": func(t *testing.T) func() Storage {
+		path := filepath.Join(t.TempDir(), "metrics.db")
+		return func() Storage {
+			return newTestSQLiteStorage(t, path)
+		}
+	},
+	"postgres": func(t *testing.T) func() Storage {
+		dsn, real := testutil.DatabaseDSN(t, testPgMigration)
+		return func() Storage {
+			return openTestPgStorage(t, dsn, real)
+		}
+	},
+	"buffered": func(t *testing.T) func() Storage {
+		db := newFakeDatabase(t)
+		return func() Storage {
+			s, err := NewBufferedStorage(context.Background(), func(ctx context.Context) (DatabaseStorage, error) {
+				return db, nil
+			})
+			require.NoError(t, err)
+			return s
+		}
+	},
+}
+
+// openTestMemoryStorage opens the memory storage and restores it like the server does.
+func openTestMemoryStorage(t *testing.T, path string, restore bool, opts ...MemoryStorageOption) Storage {
+	t.Helper()
+	s, err := NewMemoryStorage(path, opts...)
+	require.NoError(t, err)
+
+	if restore {
+		require.NoError(t, s.LoadMetrics())
+	}
+	return s
+}
+
+func openTestShardedStorage(t *testing.T, path string, restore bool, opts ...MemoryStorageOption) Storage {
+	t.Helper()
+	s, err := NewShardedMemoryStorage(path, 4, opts...)
+	require.NoError(t, err)
+
+	if restore {
+		require.NoError(t, s.LoadMetrics())
+	}
+	return s
+}
+
+// conformanceStorage is the storage under test, the metric names are prefixed so the scenarios
+// can share a database.
+type conformanceStorage struct {
+	Storage
+	t      *testing.T
+	open   func() Storage
+	prefix string
+}
+
+func (c *conformanceStorage) id(name string) string {
+	return c.prefix + name
+}
+
+func (c *conformanceStorage) counter(name, value string) metrics.Metric {
+	return testMetric(c.t, c.id(name), metrics.CounterStrName, value)
+}
+
+func (c *conformanceStorage) gauge(name, value string) metrics.Metric {
+	return testMetric(c.t, c.id(name), metrics.GaugeStrName, value)
+}
+
+// metrics returns the stored metrics of the scenario.
+func (c *conformanceStorage) metrics() metrics.Metrics {
+	c.t.Helper()
+	all, err := c.GetMetrics(context.Background())
+	require.NoError(c.t, err)
+
+	m := metrics.Metrics{Counter: make(map[string]metrics.Metric), Gauge: make(map[string]metrics.Metric)}
+	for id, metric := range all.Counter {
+		if strings.HasPrefix(id, c.prefix) {
+			m.Counter[strings.TrimPrefix(id, c.prefix)] = metric
+		}
+	}
+	for id, metric := range all.Gauge {
+		if strings.HasPrefix(id, c.prefix) {
+			m.Gauge[strings.TrimPrefix(id, c.prefix)] = metric
+		}
+	}
+	return m
+}
+
+// reopen closes the storage and opens it again, the memory storage stores a snapshot first
+// like the server does on shutdown.
+func (c *conformanceStorage) reopen() {
+	c.t.Helper()
+	ctx := context.Background()
+	if memory, ok := c.Storage.(MemoryStorage); ok {
+		require.NoError(c.t, memory.StoreMetrics())
+	}
+	require.NoError(c.t, c.Close(ctx))
+	c.Storage = c.open()
+}
+
+func assertInvalidType(t *testing.T, err error) {
+	t.Helper()
+	var metricErr *metrics.ErrMetric
+	if assert.ErrorAs(t, err, &metricErr) {
+		assert.ErrorIs(t, metricErr.MetricError, metrics.ErrInvalidMetricType)
+	}
+}
+
+var conformanceScenarios = map[string]func(t *testing.T, s *conformanceStorage){
+	"save sets the value": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		require.NoError(t, s.Save(ctx, s.counter("PollCount", "10")))
+		require.NoError(t, s.Save(ctx, s.counter("PollCount", "3")))
+		require.NoError(t, s.Save(ctx, s.gauge("Alloc", "1.5")))
+		require.NoError(t, s.Save(ctx, s.gauge("Alloc", "2.5")))
+
+		assert.Equal(t, int64(3), counterValue(t, s, s.id("PollCount")), "Save replaces a counter, it doesn't add")
+		assert.Equal(t, 2.5, gaugeValue(t, s, s.id("Alloc")))
+	},
+	"increment adds to the counter": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		require.NoError(t, s.IncrementCounter(ctx, s.id("Created"), 4))
+		assert.Equal(t, int64(4), counterValue(t, s, s.id("Created")), "a missing counter is created")
+
+		require.NoError(t, s.Save(ctx, s.counter("PollCount", "10")))
+		require.NoError(t, s.IncrementCounter(ctx, s.id("PollCount"), 5))
+		require.NoError(t, s.IncrementCounter(ctx, s.id("PollCount"), -2))
+		assert.Equal(t, int64(13), counterValue(t, s, s.id("PollCount")))
+
+		_, err := s.GetMetric(ctx, metrics.GaugeStrName, s.id("PollCount"))
+		assert.ErrorIs(t, err, ErrNotFoundMetric, "counters and gauges are separate")
+	},
+	"concurrent increments": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		const workers, increments = 8, 25
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < increments; j++ {
+					assert.NoError(t, s.IncrementCounter(ctx, s.id("PollCount"), 1))
+					assert.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{s.counter("Batched", "2")}))
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int64(workers*increments), counterValue(t, s, s.id("PollCount")), "no increment is lost")
+		assert.Equal(t, int64(2*workers*increments), counterValue(t, s, s.id("Batched")))
+	},
+	"batch merges into the stored metrics": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		require.NoError(t, s.Save(ctx, s.counter("PollCount", "10")))
+		require.NoError(t, s.Save(ctx, s.gauge("Alloc", "1")))
+
+		batch := []metrics.Metric{
+			s.counter("PollCount", "1"),
+			s.gauge("Alloc", "2"),
+			s.counter("PollCount", "2"),
+			s.counter("New", "7"),
+			s.gauge("Alloc", "3"),
+		}
+		require.NoError(t, s.BatchMetrics(ctx, batch))
+		require.NoError(t, s.BatchMetrics(ctx, nil), "an empty batch is not an error")
+
+		assert.Equal(t, int64(13), counterValue(t, s, s.id("PollCount")), "counters are summed")
+		assert.Equal(t, int64(7), counterValue(t, s, s.id("New")))
+		assert.Equal(t, 3.0, gaugeValue(t, s, s.id("Alloc")), "the last gauge wins")
+		assert.Equal(t, int64(1), batch[0].Int64Value(), "the batch is not changed")
+	},
+	"batch with an invalid type stores nothing": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		require.NoError(t, s.Save(ctx, s.counter("PollCount", "10")))
+
+		err := s.BatchMetrics(ctx, []metrics.Metric{
+			s.counter("PollCount", "1"),
+			s.gauge("Alloc", "2"),
+			{ID: s.id("Histogram"), MType: "histogram"},
+		})
+		assertInvalidType(t, err)
+
+		assert.Equal(t, int64(10), counterValue(t, s, s.id("PollCount")))
+		_, err = s.GetMetric(ctx, metrics.GaugeStrName, s.id("Alloc"))
+		assert.ErrorIs(t, err, ErrNotFoundMetric)
+	},
+	"not found": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		for _, metricType := range []string{metrics.CounterStrName, metrics.GaugeStrName} {
+			_, err := s.GetMetric(ctx, metricType, s.id("Missing"))
+			assert.ErrorIs(t, err, ErrNotFoundMetric, metricType)
+		}
+	},
+	"invalid type": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		metric := s.counter("PollCount", "1")
+		metric.MType = "histogram"
+		assertInvalidType(t, s.Save(ctx, metric))
+
+		_, err := s.GetMetric(ctx, "histogram", s.id("PollCount"))
+		assertInvalidType(t, err)
+		assert.Empty(t, s.metrics().Counter)
+	},
+	"stored metrics are not shared": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		metric := s.counter("PollCount", "1")
+		require.NoError(t, s.Save(ctx, metric))
+		*metric.Delta = 100
+
+		got, err := s.GetMetric(ctx, metrics.CounterStrName, s.id("PollCount"))
+		require.NoError(t, err)
+		all := s.metrics()
+		require.NoError(t, s.IncrementCounter(ctx, s.id("PollCount"), 1))
+
+		assert.Equal(t, int64(1), got.Int64Value(), "a read metric doesn't change with the storage")
+		assert.Equal(t, int64(1), *all.Counter["PollCount"].Delta)
+		assert.Equal(t, int64(2), counterValue(t, s, s.id("PollCount")), "the saved metric is copied")
+	},
+	"get metrics": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+			s.counter("PollCount", "5"),
+			s.gauge("Alloc", "1.5"),
+			s.gauge("Frees", "-3"),
+		}))
+
+		m := s.metrics()
+		require.Len(t, m.Counter, 1)
+		require.Len(t, m.Gauge, 2)
+		pollCount, frees := m.Counter["PollCount"], m.Gauge["Frees"]
+		assert.Equal(t, s.id("PollCount"), pollCount.ID)
+		assert.Equal(t, metrics.CounterStrName, pollCount.MType)
+		assert.Equal(t, int64(5), pollCount.Int64Value())
+		assert.Nil(t, pollCount.Value)
+		assert.Equal(t, metrics.GaugeStrName, frees.MType)
+		assert.Equal(t, -3.0, frees.Float64Value())
+		assert.Nil(t, frees.Delta)
+	},
+	"restore round trip": func(t *testing.T, s *conformanceStorage) {
+		ctx := context.Background()
+		require.NoError(t, s.Save(ctx, s.counter("PollCount", "10")))
+		require.NoError(t, s.IncrementCounter(ctx, s.id("PollCount"), 5))
+		require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{s.counter("PollCount", "1"), s.gauge("Alloc", "0.1")}))
+		require.NoError(t, s.Save(ctx, s.gauge("Alloc", "1e-9")))
+		want := s.metrics()
+
+		s.reopen()
+		assert.Equal(t, want, s.metrics())
+		assert.Equal(t, int64(16), counterValue(t, s, s.id("PollCount")))
+		assert.Equal(t, 1e-9, gaugeValue(t, s, s.id("Alloc")), "gauges keep their precision")
+
+		require.NoError(t, s.IncrementCounter(ctx, s.id("PollCount"), 1))
+		s.reopen()
+		assert.Equal(t, int64(17), counterValue(t, s, s.id("PollCount")))
+	},
+}
+
+// TestStorageConformance runs the same scenarios against every storage, they define the
+// semantics all of them share.
+func TestStorageConformance(t *testing.T) {
+	for name, backend := range conformanceBackends {
+		t.Run(name, func(t *testing.T) {
+			for scenarioName, scenario := range conformanceScenarios {
+				t.Run(scenarioName, func(t *testing.T) {
+					open := backend(t)
+					s := &conformanceStorage{
+						Storage: open(),
+						t:       t,
+						open:    open,
+						prefix:  testPgPrefix(strings.ReplaceAll(scenarioName, " ", "_")),
+					}
+					t.Cleanup(func() {
+						if pg, ok := s.Storage.(*pgStorage); ok {
+							_, err := pg.pool.Exec(context.Background(), "DELETE FROM metrics WHERE name LIKE $1", s.prefix+"%")
+							assert.NoError(t, err)
+						}
+						_ = s.Close(context.Background())
+					})
+
+					scenario(t, s)
+				})
+			}
+		})
+	}
+}
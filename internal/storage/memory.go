@@ -3,11 +3,13 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
-	"sync"
+	"path/filepath"
 
+	"github.com/sreway/yametrics/internal/logging"
 	"github.com/sreway/yametrics/internal/metrics"
 )
 
@@ -24,16 +26,39 @@ func (s *memoryStorage) Save(ctx context.Context, metric metrics.Metric) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	_ = ctx
+	if _, err := s.metrics.GetMetrics(metric.MType); err != nil {
+		return fmt.Errorf("Storage_Save:%w", err)
+	}
+
+	if err := s.log(walRecord{Op: walOpSave, Metrics: []metrics.Metric{metric}}); err != nil {
+		return fmt.Errorf("Storage_Save: %w", err)
+	}
+
+	return s.applySave(metric)
+}
+
+func (s *memoryStorage) applySave(metric metrics.Metric) error {
 	storageMetrics, err := s.metrics.GetMetrics(metric.MType)
 	if err != nil {
 		return fmt.Errorf("Storage_Save:%w", err)
 	}
 
-	storageMetrics[metric.ID] = metric
+	storageMetrics[metric.ID] = cloneMetric(metric)
 
 	return nil
 }
 
+// cloneMetric copies the value pointers, so the stored metrics are not shared with the callers.
+func cloneMetric(metric metrics.Metric) metrics.Metric {
+	if metric.Delta != nil {
+		metric.SetInt64(*metric.Delta)
+	}
+	if metric.Value != nil {
+		metric.SetFloat64(*metric.Value)
+	}
+	return metric
+}
+
 func (s *memoryStorage) GetMetric(ctx context.Context, metricType, metricName string) (*metrics.Metric, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -49,68 +74,77 @@ func (s *memoryStorage) GetMetric(ctx context.Context, metricType, metricName st
 		return nil, fmt.Errorf("%s: %w", metricName, ErrNotFoundMetric)
 	}
 
+	metric = cloneMetric(metric)
 	return &metric, nil
 }
 
+// GetMetrics returns a copy of the metrics, it's not changed by the later updates.
 func (s *memoryStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
-	_ = ctx
-	return &s.metrics, nil
-}
-
-func (s *memoryStorage) StoreMetrics() error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	_ = ctx
 
-	err := s.fileObj.Truncate(0)
-	if err != nil {
-		return fmt.Errorf("%w cat't truncate file", ErrStoreMetrics)
+	m := metrics.Metrics{
+		Counter: make(map[string]metrics.Metric, len(s.metrics.Counter)),
+		Gauge:   make(map[string]metrics.Metric, len(s.metrics.Gauge)),
 	}
-
-	_, err = s.fileObj.Seek(0, 0)
-	if err != nil {
-		return fmt.Errorf("%w cat't seek file", ErrStoreMetrics)
+	for id, metric := range s.metrics.Counter {
+		m.Counter[id] = cloneMetric(metric)
 	}
-
-	m, err := s.GetMetrics(context.Background())
-	if err != nil {
-		return fmt.Errorf("memoryStorage_GetMetrics: %w", err)
+	for id, metric := range s.metrics.Gauge {
+		m.Gauge[id] = cloneMetric(metric)
 	}
 
-	if err := json.NewEncoder(s.fileObj).Encode(m); err != nil {
-		return fmt.Errorf("%w: cant't encode metrics", ErrStoreMetrics)
-	}
+	return &m, nil
+}
 
-	log.Println("success save metrics to file")
+// StoreMetrics writes a snapshot of the metrics, the WAL records it contains are dropped.
+func (s *memoryStorage) StoreMetrics() error {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return nil
+	return s.store(&s.metrics)
 }
 
+// LoadMetrics restores the latest valid snapshot and replays the WAL records written after it,
+// the snapshot may be missing when the WAL is enabled.
 func (s *memoryStorage) LoadMetrics() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := json.NewDecoder(s.fileObj).Decode(&s.metrics); err != nil {
-		return fmt.Errorf("%w: cant't decode metrics", ErrLoadMetrics)
-	}
-
-	log.Printf("success load metrics")
-
-	return nil
+	return s.load(func(m metrics.Metrics) { s.metrics = m }, s.apply)
 }
 
+// IncrementCounter adds the value to the counter, a missing counter is created like in the SQL storages.
 func (s *memoryStorage) IncrementCounter(ctx context.Context, metricID string, value int64) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	_ = ctx
-	*s.metrics.Counter[metricID].Delta += value
+
+	if err := s.log(walRecord{Op: walOpIncrement, ID: metricID, Delta: value}); err != nil {
+		return fmt.Errorf("memoryStorage_IncrementCounter: %w", err)
+	}
+
+	return s.applyIncrement(metricID, value)
+}
+
+func (s *memoryStorage) applyIncrement(metricID string, value int64) error {
+	metric, exist := s.metrics.Counter[metricID]
+	if !exist {
+		metric = metrics.Metric{ID: metricID, MType: metrics.CounterStrName}
+	}
+
+	metric.SetInt64(metric.Int64Value() + value)
+	s.metrics.Counter[metricID] = metric
 
 	return nil
 }
 
 func (s *memoryStorage) Close(ctx context.Context) error {
 	_ = ctx
-	err := s.fileObj.Close()
-	if err != nil {
+	if err := s.close(); err != nil {
 		return fmt.Errorf("memoryStorage_Close: %w", err)
 	}
 
@@ -121,6 +155,21 @@ func (s *memoryStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) er
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	_ = ctx
+	for _, metric := range m {
+		if metric.MType != metrics.CounterStrName && metric.MType != metrics.GaugeStrName {
+			return fmt.Errorf("memoryStorage_BatchMetrics: %w",
+				metrics.NewMetricError(metric.MType, metric.ID, metrics.ErrInvalidMetricType))
+		}
+	}
+
+	if err := s.log(walRecord{Op: walOpBatch, Metrics: m}); err != nil {
+		return fmt.Errorf("memoryStorage_BatchMetrics: %w", err)
+	}
+
+	return s.applyBatch(m)
+}
+
+func (s *memoryStorage) applyBatch(m []metrics.Metric) error {
 	counterMetrics, err := s.metrics.GetMetrics("counter")
 	if err != nil {
 		return fmt.Errorf("memoryStorage_BatchMetrics: %w", err)
@@ -134,13 +183,12 @@ func (s *memoryStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) er
 	for _, metric := range m {
 		switch metric.MType {
 		case metrics.CounterStrName:
-			if _, exist := counterMetrics[metric.ID]; !exist {
-				counterMetrics[metric.ID] = metric
-			} else {
-				*counterMetrics[metric.ID].Delta += *metric.Delta
+			if stored, exist := counterMetrics[metric.ID]; exist {
+				metric.SetInt64(stored.Int64Value() + metric.Int64Value())
 			}
+			counterMetrics[metric.ID] = cloneMetric(metric)
 		case metrics.GaugeStrName:
-			gaugeMetrics[metric.ID] = metric
+			gaugeMetrics[metric.ID] = cloneMetric(metric)
 		default:
 			return fmt.Errorf("memoryStorage_BatchMetrics: %w",
 				metrics.NewMetricError(metric.MType, metric.ID, metrics.ErrInvalidMetricType))
@@ -153,32 +201,123 @@ func (s *memoryStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) er
 	return nil
 }
 
-func NewMemoryStorage(storageFile string) (MemoryStorage, error) {
+func (s *memoryStorage) apply(record walRecord) error {
+	switch record.Op {
+	case walOpSave:
+		for _, metric := range record.Metrics {
+			if err := s.applySave(metric); err != nil {
+				return err
+			}
+		}
+		return nil
+	case walOpIncrement:
+		return s.applyIncrement(record.ID, record.Delta)
+	case walOpBatch:
+		return s.applyBatch(record.Metrics)
+	default:
+		return fmt.Errorf("%w: unknown operation %s", ErrWAL, record.Op)
+	}
+}
+
+func NewMemoryStorage(storageFile string, opts ...MemoryStorageOption) (MemoryStorage, error) {
 	s := &memoryStorage{
-		metrics.Metrics{
+		metrics: metrics.Metrics{
 			Counter: make(map[string]metrics.Metric),
 			Gauge:   make(map[string]metrics.Metric),
 		},
-		sync.RWMutex{},
-		nil,
 	}
 
+	if err := s.init(storageFile, opts); err != nil {
+		return nil, fmt.Errorf("NewMemoryStorage: %w", err)
+	}
+
+	return s, nil
+}
+
+// init checks the store file and applies the options, the WAL opened by them is closed on error.
+func (p *memoryPersistence) init(storageFile string, opts []MemoryStorageOption) error {
+	p.storeFile = storageFile
+	p.snapshotKeep = SnapshotKeepDefault
+
 	if storageFile != "" {
-		fileObj, err := OpenStorageFile(storageFile)
+		if info, err := os.Stat(filepath.Dir(storageFile)); err != nil || !info.IsDir() {
+			return fmt.Errorf("can't use store file %s: directory doesn't exist", storageFile)
+		}
+	}
+
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			_ = p.close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// store writes the snapshot of m, the caller holds storeMu and keeps the metrics from changing
+// so no WAL record is appended meanwhile.
+func (p *memoryPersistence) store(m *metrics.Metrics) error {
+	if p.storeFile == "" {
+		return fmt.Errorf("%w: no store file", ErrStoreMetrics)
+	}
+
+	var walSeq uint64
+	if p.wal != nil {
+		walSeq = p.wal.lastSeq()
+	}
+
+	if err := writeSnapshot(p.storeFile, p.snapshotKeep, m, walSeq); err != nil {
+		return fmt.Errorf("%w: %v", ErrStoreMetrics, err)
+	}
+
+	if p.wal != nil {
+		if err := p.wal.truncate(); err != nil {
+			return fmt.Errorf("memoryStorage_StoreMetrics: %w", err)
+		}
+	}
+
+	logging.Debugf("success save metrics to file")
+
+	return nil
+}
+
+// load passes the latest valid snapshot to restore and the WAL records written after it to
+// apply, the caller holds the lock of the metrics.
+func (p *memoryPersistence) load(restore func(m metrics.Metrics), apply func(record walRecord) error) error {
+	m, walSeq, err := loadSnapshot(p.storeFile, p.snapshotKeep)
+	switch {
+	case err == nil:
+		restore(m)
+	case errors.Is(err, fs.ErrNotExist) && p.wal != nil:
+	default:
+		return fmt.Errorf("%w: %v", ErrLoadMetrics, err)
+	}
+
+	if p.wal != nil {
+		applied, err := p.wal.replay(walSeq, apply)
 		if err != nil {
-			return nil, fmt.Errorf("NewMemoryStorage: %w", err)
+			return fmt.Errorf("%w: %v", ErrLoadMetrics, err)
 		}
-		s.fileObj = fileObj
+		logging.Infof("replayed %d wal records", applied)
 	}
 
-	return s, nil
+	logging.Infof("success load metrics")
+
+	return nil
 }
 
-func OpenStorageFile(path string) (*os.File, error) {
-	flag := os.O_RDWR | os.O_CREATE
-	fileObj, err := os.OpenFile(path, flag, 0o644)
-	if err != nil {
-		return nil, fmt.Errorf("NewServer: can't open file %s", path)
+// log appends the operation to the WAL before it is applied, it's a no-op without WAL.
+func (p *memoryPersistence) log(record walRecord) error {
+	if p.wal == nil {
+		return nil
+	}
+	return p.wal.append(record)
+}
+
+func (p *memoryPersistence) close() error {
+	if p.wal == nil {
+		return nil
 	}
-	return fileObj, nil
+	return p.wal.close()
 }
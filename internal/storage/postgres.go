@@ -4,32 +4,220 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 
+	"github.com/sreway/yametrics/internal/logging"
 	"github.com/sreway/yametrics/internal/metrics"
 )
 
-func NewPgStorage(ctx context.Context, dsn string) (PgStorage, error) {
-	conn, err := pgx.Connect(ctx, dsn)
+var (
+	PgMaxConnsDefault          int32 = 10
+	PgMinConnsDefault          int32
+	PgHealthCheckPeriodDefault = 30 * time.Second
+	PgStatementCacheDefault    = 512
+
+	// pgRetryAttempts is how many times a query is tried when the connection failed before
+	// the query reached the server, e.g. a pooled connection broken by a database restart.
+	pgRetryAttempts = 3
+	pgRetryBackoff  = 100 * time.Millisecond
+
+	// PgBatchCopyThreshold is the batch size from which BatchMetrics loads the metrics with COPY,
+	// smaller batches are cheaper as a pipeline of upserts.
+	PgBatchCopyThreshold = 64
+
+	ErrInvalidPgOption = errors.New("invalid postgres storage option")
+)
+
+// The statements of pgStorage, all values are passed as parameters. They are prepared by name
+// on each pooled connection when first used.
+const (
+	pgGetMetric        = "get_metric"
+	pgGetMetrics       = "get_metrics"
+	pgSaveMetric       = "save_metric"
+	pgIncrementCounter = "increment_counter"
+)
+
+var pgStatements = map[string]string{
+	pgGetMetric:  "SELECT delta, value FROM metrics WHERE name = $1 AND type = $2",
+	pgGetMetrics: "SELECT name, type, delta, value FROM metrics",
+	pgSaveMetric: "INSERT INTO metrics (name, type, delta, value) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE SET delta = $3, value = $4",
+	pgIncrementCounter: "INSERT INTO metrics (name, type, delta) VALUES ($1, $2, $3) " +
+		"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE SET delta = $3 + metrics.delta",
+}
+
+// The bulk path of BatchMetrics, see batchCopy.
+const (
+	pgStagingTable  = "metrics_staging"
+	pgCreateStaging = "CREATE TEMP TABLE " + pgStagingTable + " " +
+		"(seq INTEGER NOT NULL, name VARCHAR(255) NOT NULL, type VARCHAR(255) NOT NULL, " +
+		"delta BIGINT, value DOUBLE PRECISION) ON COMMIT DROP"
+	pgMergeStaging = "INSERT INTO metrics (name, type, delta, value) " +
+		"SELECT name, type, SUM(delta)::BIGINT, NULL FROM " + pgStagingTable + " WHERE type = 'counter' GROUP BY name, type " +
+		"UNION ALL " +
+		"SELECT * FROM (SELECT DISTINCT ON (name) name, type, delta, value FROM " + pgStagingTable + " " +
+		"WHERE type = 'gauge' ORDER BY name, seq DESC) AS gauges " +
+		"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE SET " +
+		"delta = CASE WHEN excluded.type = 'counter' THEN COALESCE(metrics.delta, 0) + excluded.delta " +
+		"ELSE excluded.delta END, value = excluded.value"
+)
+
+type (
+	PgStorageOption func(cfg *pgxpool.Config) error
+
+	// PoolStats is a snapshot of the connection pool, the counts are cumulative.
+	PoolStats struct {
+		TotalConns           int32
+		IdleConns            int32
+		AcquiredConns        int32
+		ConstructingConns    int32
+		MaxConns             int32
+		AcquireCount         int64
+		EmptyAcquireCount    int64
+		CanceledAcquireCount int64
+		AcquireDuration      time.Duration
+	}
+)
+
+// WithPoolSize bounds the number of pooled connections, minConns are kept open even when idle.
+func WithPoolSize(minConns, maxConns int32) PgStorageOption {
+	return func(cfg *pgxpool.Config) error {
+		if maxConns < 1 || minConns < 0 || minConns > maxConns {
+			return fmt.Errorf("WithPoolSize: %w: min %d max %d", ErrInvalidPgOption, minConns, maxConns)
+		}
+		cfg.MinConns = minConns
+		cfg.MaxConns = maxConns
+		return nil
+	}
+}
+
+// WithHealthCheckPeriod sets how often idle connections are checked, broken ones are replaced.
+func WithHealthCheckPeriod(period time.Duration) PgStorageOption {
+	return func(cfg *pgxpool.Config) error {
+		if period <= 0 {
+			return fmt.Errorf("WithHealthCheckPeriod: %w: %s", ErrInvalidPgOption, period)
+		}
+		cfg.HealthCheckPeriod = period
+		return nil
+	}
+}
+
+// WithStatementCache sets the number of prepared statements cached per connection, 0 disables the cache.
+func WithStatementCache(capacity int) PgStorageOption {
+	return func(cfg *pgxpool.Config) error {
+		if capacity < 0 {
+			return fmt.Errorf("WithStatementCache: %w: %d", ErrInvalidPgOption, capacity)
+		}
+
+		cfg.ConnConfig.BuildStatementCache = nil
+		if capacity > 0 {
+			cfg.ConnConfig.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+				return stmtcache.New(conn, stmtcache.ModePrepare, capacity)
+			}
+		}
+		return nil
+	}
+}
+
+// NewPgStorage connects a pool to the database, one connection is established to check the DSN.
+// Connections broken later, e.g. by a database restart, are replaced by the pool.
+func NewPgStorage(ctx context.Context, dsn string, opts ...PgStorageOption) (PgStorage, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("NewPgStroge error: %w", err)
+		return nil, fmt.Errorf("NewPgStorage: %w", err)
 	}
 
-	log.Println("NewPgStorage: success connect database")
+	opts = append([]PgStorageOption{
+		WithPoolSize(PgMinConnsDefault, PgMaxConnsDefault),
+		WithHealthCheckPeriod(PgHealthCheckPeriodDefault),
+		WithStatementCache(PgStatementCacheDefault),
+	}, opts...)
+
+	for _, opt := range opts {
+		if err = opt(cfg); err != nil {
+			return nil, fmt.Errorf("NewPgStorage: %w", err)
+		}
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("NewPgStorage: %w", err)
+	}
+
+	logging.Infof("NewPgStorage: success connect database")
 
 	return &pgStorage{
-		connection: conn,
+		pool: pool,
 	}, nil
 }
 
+// retry runs op again when it failed before anything was sent to the server, so retried
+// statements are never applied twice.
+func (s *pgStorage) retry(ctx context.Context, op func() error) error {
+	var err error
+	for attempt := 1; attempt <= pgRetryAttempts; attempt++ {
+		err = op()
+		if err == nil || !pgconn.SafeToRetry(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt) * pgRetryBackoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+	return err
+}
+
+// exec runs the named statement, preparing it on the connection first. pgx prepares a name once
+// per connection, later calls of Prepare with the same name and SQL are a lookup.
+func (s *pgStorage) exec(ctx context.Context, name string, args ...interface{}) error {
+	return s.retry(ctx, func() error {
+		return s.pool.AcquireFunc(ctx, func(conn *pgxpool.Conn) error {
+			if _, err := conn.Conn().Prepare(ctx, name, pgStatements[name]); err != nil {
+				return err
+			}
+			_, err := conn.Exec(ctx, name, args...)
+			return err
+		})
+	})
+}
+
+// query runs the named statement like exec and passes the rows to scan.
+func (s *pgStorage) query(ctx context.Context, scan func(rows pgx.Rows) error, name string, args ...interface{}) error {
+	return s.retry(ctx, func() error {
+		return s.pool.AcquireFunc(ctx, func(conn *pgxpool.Conn) error {
+			if _, err := conn.Conn().Prepare(ctx, name, pgStatements[name]); err != nil {
+				return err
+			}
+
+			rows, err := conn.Query(ctx, name, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			if err = scan(rows); err != nil {
+				return err
+			}
+			return rows.Err()
+		})
+	})
+}
+
 func (s *pgStorage) Save(ctx context.Context, metric metrics.Metric) error {
-	_, err := s.connection.Exec(ctx, "INSERT INTO metrics (name, type, delta, value) VALUES ($1, $2, $3, $4)"+
-		"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE set delta=$3, value=$4",
-		metric.ID, metric.MType, metric.Int64Pointer(), metric.Float64Pointer())
+	if err := validMetricType(metric.MType, metric.ID); err != nil {
+		return fmt.Errorf("pgStorage_Save: %w", err)
+	}
+
+	err := s.exec(ctx, pgSaveMetric, metric.ID, metric.MType, metric.Int64Pointer(), metric.Float64Pointer())
 	if err != nil {
 		return fmt.Errorf("pgStorage_Save:%w", err)
 	}
@@ -38,95 +226,102 @@ func (s *pgStorage) Save(ctx context.Context, metric metrics.Metric) error {
 }
 
 func (s *pgStorage) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
-	var m metrics.Metric
+	if err := validMetricType(metricType, metricID); err != nil {
+		return nil, fmt.Errorf("pgStorage_GetMetric: %w", err)
+	}
 
-	q := fmt.Sprintf("SELECT delta, value FROM metrics WHERE name = '%s' and type = '%s'", metricID, metricType)
-	err := s.connection.QueryRow(ctx, q).Scan(&m.Delta, &m.Value)
-	if err != nil {
-		var pgErr *pgconn.PgError
-		switch {
-		case errors.Is(err, pgx.ErrNoRows):
-			return nil, fmt.Errorf("pgStorage_GetMetric: %w", ErrNotFoundMetric)
-		case errors.As(err, &pgErr):
-			if pgErr.Code == "42703" {
-				return nil, fmt.Errorf("pgStorage_GetMetric: %w", ErrNotFoundMetric)
-			}
-			return nil, fmt.Errorf("pgStorage_GetMetric: %w", err)
-		default:
-			return nil, fmt.Errorf("pgStorage_GetMetric: %w", err)
+	var m *metrics.Metric
+	err := s.query(ctx, func(rows pgx.Rows) error {
+		if !rows.Next() {
+			return nil
 		}
+		m = &metrics.Metric{ID: metricID, MType: metricType}
+		return rows.Scan(&m.Delta, &m.Value)
+	}, pgGetMetric, metricID, metricType)
+	if err != nil {
+		return nil, fmt.Errorf("pgStorage_GetMetric: %w", err)
 	}
 
-	m.ID = metricID
-	m.MType = metricType
-	return &m, nil
-}
-
-func (s *pgStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
-	m := metrics.Metrics{
-		Counter: make(map[string]metrics.Metric),
-		Gauge:   make(map[string]metrics.Metric),
+	if m == nil {
+		return nil, fmt.Errorf("pgStorage_GetMetric: %s: %w", metricID, ErrNotFoundMetric)
 	}
 
-	rows, err := s.connection.Query(ctx, "SELECT name, type, delta, value FROM metrics")
-	if err != nil {
-		return nil, fmt.Errorf("Server_GetMetrics: %w", err)
-	}
+	return m, nil
+}
 
-	defer rows.Close()
+func (s *pgStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
+	var m metrics.Metrics
 
-	for rows.Next() {
-		var v metrics.Metric
-		err = rows.Scan(&v.ID, &v.MType, &v.Delta, &v.Value)
-		if err != nil {
-			return nil, fmt.Errorf("Server_GetMetrics: %w", err)
+	err := s.query(ctx, func(rows pgx.Rows) error {
+		// the scan is retried as a whole, so it starts over with empty maps
+		m = metrics.Metrics{
+			Counter: make(map[string]metrics.Metric),
+			Gauge:   make(map[string]metrics.Metric),
 		}
 
-		switch v.MType {
-		case "counter":
-			m.Counter[v.ID] = v
-		case "gauge":
-			m.Gauge[v.ID] = v
-		default:
-			return nil, fmt.Errorf("Server_GetMetrics: %w",
-				metrics.NewMetricError(v.MType, v.ID, metrics.ErrInvalidMetricType))
-		}
-	}
+		for rows.Next() {
+			var v metrics.Metric
+			if err := rows.Scan(&v.ID, &v.MType, &v.Delta, &v.Value); err != nil {
+				return err
+			}
 
-	err = rows.Err()
+			switch v.MType {
+			case metrics.CounterStrName:
+				m.Counter[v.ID] = v
+			case metrics.GaugeStrName:
+				m.Gauge[v.ID] = v
+			default:
+				return metrics.NewMetricError(v.MType, v.ID, metrics.ErrInvalidMetricType)
+			}
+		}
+		return nil
+	}, pgGetMetrics)
 	if err != nil {
-		return nil, fmt.Errorf("Server_GetMetrics: %w", err)
+		return nil, fmt.Errorf("pgStorage_GetMetrics: %w", err)
 	}
 
 	return &m, nil
 }
 
 func (s *pgStorage) IncrementCounter(ctx context.Context, metricID string, value int64) error {
-	_, err := s.connection.Exec(ctx, "INSERT INTO metrics (name, type, delta) VALUES ($1, $2, $3)"+
-		"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE set delta = $3 + metrics.delta", metricID, "counter", value)
+	err := s.exec(ctx, pgIncrementCounter, metricID, metrics.CounterStrName, value)
 	if err != nil {
-		return fmt.Errorf("pgStorage_Save:%w", err)
+		return fmt.Errorf("pgStorage_IncrementCounter:%w", err)
 	}
 
 	return nil
 }
 
 func (s *pgStorage) Ping(ctx context.Context) error {
-	if err := s.connection.Ping(ctx); err != nil {
+	if err := s.pool.Ping(ctx); err != nil {
 		return fmt.Errorf("pgStorage_Ping: %w", ErrStorageUnavailable)
 	}
 	return nil
 }
 
 func (s *pgStorage) Close(ctx context.Context) error {
-	if err := s.connection.Close(ctx); err != nil {
-		return fmt.Errorf("pgStorage_Close: %w", err)
-	}
+	_ = ctx
+	s.pool.Close()
 	return nil
 }
 
+func (s *pgStorage) Stats() PoolStats {
+	stat := s.pool.Stat()
+	return PoolStats{
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		MaxConns:             stat.MaxConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+	}
+}
+
 func (s *pgStorage) ValidateSchema(sourceMigrationsURL string) error {
-	config := s.connection.Config()
+	config := s.pool.Config().ConnConfig
 	migrateURL := fmt.Sprintf("pgx://%s:%s@%s:%d/%s",
 		config.User, config.Password, config.Host, config.Port, config.Database)
 	m, err := migrate.New(sourceMigrationsURL, migrateURL)
@@ -139,7 +334,7 @@ func (s *pgStorage) ValidateSchema(sourceMigrationsURL string) error {
 	if err != nil {
 		switch {
 		case errors.Is(err, migrate.ErrNoChange):
-			log.Printf("Migrate up: %s", err)
+			logging.Infof("Migrate up: %v", err)
 		default:
 			return fmt.Errorf("pgStorage_ValidateSchema: %w", err)
 		}
@@ -147,49 +342,82 @@ func (s *pgStorage) ValidateSchema(sourceMigrationsURL string) error {
 	return nil
 }
 
+// BatchMetrics applies the metrics in one transaction. Small batches are sent as a pgx.Batch in a
+// single round trip, larger ones are copied into a staging table and merged with one upsert,
+// see PgBatchCopyThreshold.
 func (s *pgStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
-	tx, err := s.connection.BeginTx(ctx, pgx.TxOptions{})
+	for _, metric := range m {
+		if err := validMetricType(metric.MType, metric.ID); err != nil {
+			return fmt.Errorf("pgStorage_BatchMetrics: %w", err)
+		}
+	}
+
+	if len(m) == 0 {
+		return nil
+	}
+
+	apply := s.batchStatements
+	if len(m) >= PgBatchCopyThreshold {
+		apply = s.batchCopy
+	}
+
+	err := s.retry(ctx, func() error {
+		return s.pool.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			return apply(ctx, tx, m)
+		})
+	})
 	if err != nil {
 		return fmt.Errorf("pgStorage_BatchMetrics: %w", err)
 	}
 
-	defer func() {
-		err := tx.Rollback(ctx)
-		if err != nil {
-			return
+	return nil
+}
+
+// batchStatements queues a save or an increment per metric and sends them at once.
+func (s *pgStorage) batchStatements(ctx context.Context, tx pgx.Tx, m []metrics.Metric) error {
+	batch := new(pgx.Batch)
+	for _, metric := range m {
+		if metric.MType == metrics.CounterStrName {
+			batch.Queue(pgStatements[pgIncrementCounter], metric.ID, metric.MType, metric.Int64Value())
+			continue
 		}
-	}()
+		batch.Queue(pgStatements[pgSaveMetric], metric.ID, metric.MType, metric.Int64Pointer(), metric.Float64Pointer())
+	}
 
-	if _, err := tx.Prepare(ctx, "update_gauge",
-		"INSERT INTO metrics (name, type, delta, value) VALUES ($1, $2, $3, $4) "+
-			"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE set delta=$3, value=$4"); err != nil {
-		return fmt.Errorf("pgStorage_BatchMetrics: %w", err)
+	results := tx.SendBatch(ctx, batch)
+	for range m {
+		if _, err := results.Exec(); err != nil {
+			_ = results.Close()
+			return err
+		}
 	}
 
-	if _, err := tx.Prepare(ctx, "update_counter",
-		"INSERT INTO metrics (name, type, delta) VALUES ($1, $2, $3) "+
-			"ON CONFLICT ON CONSTRAINT uniq_name_type DO UPDATE set delta = $3 + metrics.delta"); err != nil {
-		return fmt.Errorf("pgStorage_BatchMetrics: %w", err)
+	return results.Close()
+}
+
+// batchCopy copies the metrics into a staging table dropped on commit and merges it into metrics
+// with a single upsert. Postgres can't update a row twice in one statement, so the staged rows are
+// reduced first: the counters of a name are summed and the last gauge of a name wins.
+func (s *pgStorage) batchCopy(ctx context.Context, tx pgx.Tx, m []metrics.Metric) error {
+	if _, err := tx.Exec(ctx, pgCreateStaging); err != nil {
+		return err
 	}
 
-	for _, metric := range m {
-		switch metric.MType {
-		case "counter":
-			_, err := tx.Exec(ctx, "update_counter", metric.ID, metric.MType, *metric.Delta)
-			if err != nil {
-				return fmt.Errorf("pgStorage_BatchMetrics: %w", err)
-			}
-		case "gauge":
-			_, err := tx.Exec(ctx, "update_gauge",
-				metric.ID, metric.MType, metric.Int64Pointer(), metric.Float64Pointer())
-			if err != nil {
-				return fmt.Errorf("pgStorage_BatchMetrics: %w", err)
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{pgStagingTable}, []string{"seq", "name", "type", "delta", "value"},
+		pgx.CopyFromSlice(len(m), func(i int) ([]interface{}, error) {
+			metric := m[i]
+			delta := metric.Int64Pointer()
+			if metric.MType == metrics.CounterStrName {
+				// a counter without delta adds nothing, like IncrementCounter
+				value := metric.Int64Value()
+				delta = &value
 			}
-		default:
-			return fmt.Errorf("pgStorage_BatchMetrics: %w",
-				metrics.NewMetricError(metric.MType, metric.ID, metrics.ErrInvalidMetricType))
-		}
+			return []interface{}{int32(i), metric.ID, metric.MType, delta, metric.Float64Pointer()}, nil
+		}))
+	if err != nil {
+		return err
 	}
 
-	return tx.Commit(ctx)
+	_, err = tx.Exec(ctx, pgMergeStaging)
+	return err
 }
@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+type (
+	// shardedStorage is the memory storage split into shards by the hash of type and ID, each with
+	// its own lock. The counters are updated atomically under the read lock of their shard, so the
+	// increments of different counters and of the same one don't wait for each other.
+	//
+	// The write lock of a shard is taken to add a metric, to set a value and by the batches, a batch
+	// locks all its shards at once so it's applied as a whole. The increments commute, so their WAL
+	// records may be appended in another order than they are applied.
+	shardedStorage struct {
+		memoryPersistence
+		shards []*memoryShard
+	}
+
+	memoryShard struct {
+		mu       sync.RWMutex
+		counters map[string]*int64
+		gauges   map[string]float64
+	}
+)
+
+// NewShardedMemoryStorage is the memory storage for many concurrent updates, see NewMemoryStorage
+// for the store file and the options. The metrics are spread over the given number of shards.
+func NewShardedMemoryStorage(storageFile string, shards int, opts ...MemoryStorageOption) (MemoryStorage, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("NewShardedMemoryStorage: shards must be positive, got %d", shards)
+	}
+
+	s := &shardedStorage{shards: make([]*memoryShard, shards)}
+	for i := range s.shards {
+		s.shards[i] = newMemoryShard()
+	}
+
+	if err := s.init(storageFile, opts); err != nil {
+		return nil, fmt.Errorf("NewShardedMemoryStorage: %w", err)
+	}
+
+	return s, nil
+}
+
+func newMemoryShard() *memoryShard {
+	return &memoryShard{counters: make(map[string]*int64), gauges: make(map[string]float64)}
+}
+
+// shardIndex is the FNV-1a hash of the type and ID modulo the number of shards.
+func (s *shardedStorage) shardIndex(metricType, metricID string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(metricType); i++ {
+		h = (h ^ uint32(metricType[i])) * 16777619
+	}
+	for i := 0; i < len(metricID); i++ {
+		h = (h ^ uint32(metricID[i])) * 16777619
+	}
+	return int(h % uint32(len(s.shards)))
+}
+
+func (s *shardedStorage) shard(metricType, metricID string) *memoryShard {
+	return s.shards[s.shardIndex(metricType, metricID)]
+}
+
+// lock takes the write locks of all shards in order, the batches take theirs in the same order.
+func (s *shardedStorage) lock() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+}
+
+func (s *shardedStorage) unlock() {
+	for _, shard := range s.shards {
+		shard.mu.Unlock()
+	}
+}
+
+func (s *shardedStorage) Save(ctx context.Context, metric metrics.Metric) error {
+	_ = ctx
+	if err := validMetricType(metric.MType, metric.ID); err != nil {
+		return fmt.Errorf("shardedStorage_Save: %w", err)
+	}
+
+	shard := s.shard(metric.MType, metric.ID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if err := s.log(walRecord{Op: walOpSave, Metrics: []metrics.Metric{metric}}); err != nil {
+		return fmt.Errorf("shardedStorage_Save: %w", err)
+	}
+
+	shard.set(metric)
+
+	return nil
+}
+
+// set stores the value of the metric, the caller holds the write lock.
+func (shard *memoryShard) set(metric metrics.Metric) {
+	if metric.MType == metrics.GaugeStrName {
+		shard.gauges[metric.ID] = metric.Float64Value()
+		return
+	}
+
+	if delta, exist := shard.counters[metric.ID]; exist {
+		atomic.StoreInt64(delta, metric.Int64Value())
+		return
+	}
+	delta := metric.Int64Value()
+	shard.counters[metric.ID] = &delta
+}
+
+// add adds to the counter, the caller holds the write lock.
+func (shard *memoryShard) add(metricID string, value int64) {
+	if delta, exist := shard.counters[metricID]; exist {
+		atomic.AddInt64(delta, value)
+		return
+	}
+	shard.counters[metricID] = &value
+}
+
+func (s *shardedStorage) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
+	_ = ctx
+	if err := validMetricType(metricType, metricID); err != nil {
+		return nil, fmt.Errorf("shardedStorage_GetMetric: %w", err)
+	}
+
+	shard := s.shard(metricType, metricID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	metric := metrics.Metric{ID: metricID, MType: metricType}
+	if metricType == metrics.GaugeStrName {
+		value, exist := shard.gauges[metricID]
+		if !exist {
+			return nil, fmt.Errorf("%s: %w", metricID, ErrNotFoundMetric)
+		}
+		metric.SetFloat64(value)
+		return &metric, nil
+	}
+
+	delta, exist := shard.counters[metricID]
+	if !exist {
+		return nil, fmt.Errorf("%s: %w", metricID, ErrNotFoundMetric)
+	}
+	metric.SetInt64(atomic.LoadInt64(delta))
+
+	return &metric, nil
+}
+
+// GetMetrics returns a copy of the metrics, it holds the read locks of all shards so a batch
+// is seen either whole or not at all.
+func (s *shardedStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
+	_ = ctx
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+	}
+	defer func() {
+		for _, shard := range s.shards {
+			shard.mu.RUnlock()
+		}
+	}()
+
+	m := s.metrics()
+	return &m, nil
+}
+
+// metrics copies the metrics of all shards, the caller holds their locks.
+func (s *shardedStorage) metrics() metrics.Metrics {
+	m := metrics.Metrics{
+		Counter: make(map[string]metrics.Metric),
+		Gauge:   make(map[string]metrics.Metric),
+	}
+	for _, shard := range s.shards {
+		for id, delta := range shard.counters {
+			metric := metrics.Metric{ID: id, MType: metrics.CounterStrName}
+			metric.SetInt64(atomic.LoadInt64(delta))
+			m.Counter[id] = metric
+		}
+		for id, value := range shard.gauges {
+			metric := metrics.Metric{ID: id, MType: metrics.GaugeStrName}
+			metric.SetFloat64(value)
+			m.Gauge[id] = metric
+		}
+	}
+	return m
+}
+
+// IncrementCounter adds the value atomically under the read lock of the shard, the write lock is
+// taken only to create a missing counter.
+func (s *shardedStorage) IncrementCounter(ctx context.Context, metricID string, value int64) error {
+	_ = ctx
+	record := walRecord{Op: walOpIncrement, ID: metricID, Delta: value}
+	shard := s.shard(metrics.CounterStrName, metricID)
+
+	shard.mu.RLock()
+	if delta, exist := shard.counters[metricID]; exist {
+		defer shard.mu.RUnlock()
+		if err := s.log(record); err != nil {
+			return fmt.Errorf("shardedStorage_IncrementCounter: %w", err)
+		}
+		atomic.AddInt64(delta, value)
+		return nil
+	}
+	shard.mu.RUnlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if err := s.log(record); err != nil {
+		return fmt.Errorf("shardedStorage_IncrementCounter: %w", err)
+	}
+	shard.add(metricID, value)
+
+	return nil
+}
+
+func (s *shardedStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
+	_ = ctx
+	for _, metric := range m {
+		if err := validMetricType(metric.MType, metric.ID); err != nil {
+			return fmt.Errorf("shardedStorage_BatchMetrics: %w", err)
+		}
+	}
+
+	// the shards of the batch are locked in order, like by lock
+	used := make([]bool, len(s.shards))
+	for _, metric := range m {
+		used[s.shardIndex(metric.MType, metric.ID)] = true
+	}
+	locked := make([]*memoryShard, 0, len(s.shards))
+	for i, shard := range s.shards {
+		if used[i] {
+			shard.mu.Lock()
+			locked = append(locked, shard)
+		}
+	}
+	defer func() {
+		for _, shard := range locked {
+			shard.mu.Unlock()
+		}
+	}()
+
+	if err := s.log(walRecord{Op: walOpBatch, Metrics: m}); err != nil {
+		return fmt.Errorf("shardedStorage_BatchMetrics: %w", err)
+	}
+
+	s.applyBatch(m)
+
+	return nil
+}
+
+// applyBatch sums the counters and sets the gauges, the caller holds the locks of their shards.
+func (s *shardedStorage) applyBatch(m []metrics.Metric) {
+	for _, metric := range m {
+		shard := s.shard(metric.MType, metric.ID)
+		if metric.MType == metrics.CounterStrName {
+			shard.add(metric.ID, metric.Int64Value())
+			continue
+		}
+		shard.set(metric)
+	}
+}
+
+// StoreMetrics writes a snapshot of the metrics, the WAL records it contains are dropped. All
+// shards are locked for writing as the increments append to the WAL under the read lock.
+func (s *shardedStorage) StoreMetrics() error {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	s.lock()
+	defer s.unlock()
+
+	m := s.metrics()
+	return s.store(&m)
+}
+
+// LoadMetrics restores the latest valid snapshot and replays the WAL records written after it,
+// the snapshot may be missing when the WAL is enabled.
+func (s *shardedStorage) LoadMetrics() error {
+	s.lock()
+	defer s.unlock()
+
+	return s.load(s.restore, s.apply)
+}
+
+// restore replaces the metrics of all shards, the caller holds their locks.
+func (s *shardedStorage) restore(m metrics.Metrics) {
+	for _, shard := range s.shards {
+		shard.counters = make(map[string]*int64)
+		shard.gauges = make(map[string]float64)
+	}
+	for id, metric := range m.Counter {
+		metric.ID, metric.MType = id, metrics.CounterStrName
+		s.shard(metric.MType, id).set(metric)
+	}
+	for id, metric := range m.Gauge {
+		metric.ID, metric.MType = id, metrics.GaugeStrName
+		s.shard(metric.MType, id).set(metric)
+	}
+}
+
+// apply replays the WAL record, the caller holds the locks of all shards.
+func (s *shardedStorage) apply(record walRecord) error {
+	switch record.Op {
+	case walOpSave:
+		for _, metric := range record.Metrics {
+			if err := validMetricType(metric.MType, metric.ID); err != nil {
+				return err
+			}
+			s.shard(metric.MType, metric.ID).set(metric)
+		}
+		return nil
+	case walOpIncrement:
+		s.shard(metrics.CounterStrName, record.ID).add(record.ID, record.Delta)
+		return nil
+	case walOpBatch:
+		s.applyBatch(record.Metrics)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown operation %s", ErrWAL, record.Op)
+	}
+}
+
+func (s *shardedStorage) Close(ctx context.Context) error {
+	_ = ctx
+	if err := s.close(); err != nil {
+		return fmt.Errorf("shardedStorage_Close: %w", err)
+	}
+
+	return nil
+}
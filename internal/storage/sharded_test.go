@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func TestNewShardedMemoryStorage(t *testing.T) {
+	_, err := NewShardedMemoryStorage("", 0)
+	assert.Error(t, err)
+
+	_, err = NewShardedMemoryStorage(filepath.Join(t.TempDir(), "missing", "metrics.json"), 4)
+	assert.Error(t, err, "the directory of the store file must exist")
+
+	_, err = NewShardedMemoryStorage("", 4, WithSnapshotKeep(0))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+}
+
+// TestShardedStorage_Concurrent mixes all operations on shared metrics, run it with -race.
+func TestShardedStorage_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewShardedMemoryStorage("", 4)
+	require.NoError(t, err)
+
+	const workers, updates, ids = 8, 200, 16
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				id := fmt.Sprintf("Counter%d", j%ids)
+				assert.NoError(t, s.IncrementCounter(ctx, id, 1))
+				assert.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+					testMetric(t, id, metrics.CounterStrName, "1"),
+					testMetric(t, fmt.Sprintf("Gauge%d", j%ids), metrics.GaugeStrName, fmt.Sprint(i)),
+				}))
+				assert.NoError(t, s.Save(ctx, testMetric(t, fmt.Sprintf("Worker%d", i), metrics.GaugeStrName, fmt.Sprint(j))))
+
+				_, getErr := s.GetMetric(ctx, metrics.CounterStrName, id)
+				assert.NoError(t, getErr)
+				if j%50 == 0 {
+					_, getErr = s.GetMetrics(ctx)
+					assert.NoError(t, getErr)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all, err := s.GetMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, all.Counter, ids)
+	require.Len(t, all.Gauge, ids+workers)
+
+	var total int64
+	for _, metric := range all.Counter {
+		total += *metric.Delta
+	}
+	assert.Equal(t, int64(2*workers*updates), total, "no increment is lost")
+	for i := 0; i < workers; i++ {
+		assert.Equal(t, float64(updates-1), gaugeValue(t, s, fmt.Sprintf("Worker%d", i)))
+	}
+}
+
+func TestShardedStorage_WALReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	open := func() MemoryStorage {
+		s, err := NewShardedMemoryStorage(filepath.Join(dir, "metrics.json"), 4,
+			WithWAL(filepath.Join(dir, "metrics.wal"), WALSyncAlways, 0))
+		require.NoError(t, err)
+		return s
+	}
+
+	s := open()
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", metrics.CounterStrName, "10")))
+	require.NoError(t, s.StoreMetrics())
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+	require.NoError(t, s.IncrementCounter(ctx, "Created", 2))
+	require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", metrics.CounterStrName, "1"),
+		testMetric(t, "Alloc", metrics.GaugeStrName, "1.5"),
+	}))
+	require.NoError(t, s.Close(ctx), "closed without a snapshot like on a crash")
+
+	s = open()
+	t.Cleanup(func() { _ = s.Close(ctx) })
+	require.NoError(t, s.LoadMetrics())
+	assert.Equal(t, int64(16), counterValue(t, s, "PollCount"), "the records after the snapshot are replayed")
+	assert.Equal(t, int64(2), counterValue(t, s, "Created"))
+	assert.Equal(t, 1.5, gaugeValue(t, s, "Alloc"))
+}
+
+func TestShardedStorage_Spread(t *testing.T) {
+	s, err := NewShardedMemoryStorage("", 8)
+	require.NoError(t, err)
+	sharded := s.(*shardedStorage)
+
+	used := make(map[int]int)
+	for i := 0; i < 256; i++ {
+		used[sharded.shardIndex(metrics.CounterStrName, fmt.Sprintf("Counter%d", i))]++
+	}
+	assert.Len(t, used, 8, "every shard is used")
+	assert.NotEqual(t, sharded.shardIndex(metrics.CounterStrName, "Alloc"),
+		sharded.shardIndex(metrics.GaugeStrName, "Alloc"), "the type is part of the hash")
+}
+
+// BenchmarkMemoryStorage_Parallel compares the single lock memory storage with the sharded one,
+// the goroutines update a few hot counters and gauges and read them back.
+func BenchmarkMemoryStorage_Parallel(b *testing.B) {
+	ctx := context.Background()
+	storages := map[string]func() (MemoryStorage, error){
+		"memory": func() (MemoryStorage, error) {
+			return NewMemoryStorage("")
+		},
+		"sharded": func() (MemoryStorage, error) {
+			return NewShardedMemoryStorage("", 32)
+		},
+	}
+
+	const ids = 64
+	counters, gauges := make([]string, ids), make([]metrics.Metric, ids)
+	for i := range counters {
+		counters[i] = fmt.Sprintf("Counter%d", i)
+		gauges[i] = metrics.Metric{ID: fmt.Sprintf("Gauge%d", i), MType: metrics.GaugeStrName}
+		gauges[i].SetFloat64(float64(i))
+	}
+
+	for name, newStorage := range storages {
+		b.Run(name, func(b *testing.B) {
+			s, err := newStorage()
+			require.NoError(b, err)
+
+			var next uint32
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddUint32(&next, 7))
+				for pb.Next() {
+					i++
+					var opErr error
+					switch i % 4 {
+					case 0:
+						opErr = s.Save(ctx, gauges[i%ids])
+					case 1:
+						if _, opErr = s.GetMetric(ctx, metrics.CounterStrName, counters[i%ids]); errors.Is(opErr, ErrNotFoundMetric) {
+							opErr = nil
+						}
+					default:
+						opErr = s.IncrementCounter(ctx, counters[i%ids], 1)
+					}
+					if opErr != nil {
+						b.Error(opErr)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// The snapshot file is a header line followed by the metrics JSON:
+//
+//	{"version":2,"checksum":"sha256:<hex of the metrics JSON>","wal_seq":42}
+//	{"counter":{...},"gauge":{...}}
+//
+// Files of version 1 are the bare metrics JSON written before the header was introduced.
+const (
+	snapshotVersion        = 2
+	snapshotChecksumPrefix = "sha256:"
+	snapshotTempSuffix     = ".tmp"
+)
+
+var (
+	SnapshotKeepDefault = 2
+
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+type (
+	snapshotHeader struct {
+		Version  int    `json:"version"`
+		Checksum string `json:"checksum"`
+		WALSeq   uint64 `json:"wal_seq,omitempty"`
+	}
+
+	// snapshot is the content of a version 1 file, WALSeq is the last WAL record it contains.
+	snapshot struct {
+		metrics.Metrics
+		WALSeq uint64 `json:"wal_seq,omitempty"`
+	}
+)
+
+// WithSnapshotKeep keeps the last keep snapshots, the older ones are named path.1, path.2, ...
+// LoadMetrics falls back to them when the newer ones are missing or damaged.
+func WithSnapshotKeep(keep int) MemoryStorageOption {
+	return func(p *memoryPersistence) error {
+		if keep < 1 {
+			return fmt.Errorf("WithSnapshotKeep: %w: keep %d", ErrInvalidSnapshot, keep)
+		}
+		p.snapshotKeep = keep
+		return nil
+	}
+}
+
+// snapshotPath is the path of the n-th previous snapshot, 0 is the latest one.
+func snapshotPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// writeSnapshot replaces the snapshot atomically: the content is fsynced to a temp file which
+// is renamed over the latest snapshot after the previous ones are shifted. A crash at any
+// point leaves either the new or the previous snapshot in place.
+func writeSnapshot(path string, keep int, m *metrics.Metrics, walSeq uint64) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("writeSnapshot: can't encode metrics: %w", err)
+	}
+
+	sum := sha256.Sum256(body)
+	header, err := json.Marshal(snapshotHeader{
+		Version:  snapshotVersion,
+		Checksum: snapshotChecksumPrefix + hex.EncodeToString(sum[:]),
+		WALSeq:   walSeq,
+	})
+	if err != nil {
+		return fmt.Errorf("writeSnapshot: can't encode header: %w", err)
+	}
+
+	tmpPath := path + snapshotTempSuffix
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	content := append(append(header, '\n'), body...)
+	if _, err = tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	for n := keep - 1; n > 0; n-- {
+		err = os.Rename(snapshotPath(path, n-1), snapshotPath(path, n))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("writeSnapshot: %w", err)
+		}
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the renames in the directory durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("syncDir: %w", err)
+	}
+	defer d.Close()
+
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("syncDir: %w", err)
+	}
+
+	return nil
+}
+
+// readSnapshot decodes a snapshot file and verifies its checksum.
+func readSnapshot(path string) (metrics.Metrics, uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return metrics.Metrics{}, 0, fmt.Errorf("readSnapshot: %w", err)
+	}
+
+	headerLine, body, _ := bytes.Cut(content, []byte{'\n'})
+
+	// a first line that is not a header is a version 1 file, its whole content is checked below
+	var header snapshotHeader
+	if err = json.Unmarshal(headerLine, &header); err != nil {
+		header.Version = 1
+	}
+
+	var snap snapshot
+	switch header.Version {
+	case 0, 1:
+		if err = json.Unmarshal(content, &snap); err != nil {
+			return metrics.Metrics{}, 0, fmt.Errorf("readSnapshot: %w: %s: %v", ErrInvalidSnapshot, path, err)
+		}
+	case snapshotVersion:
+		sum := sha256.Sum256(body)
+		if header.Checksum != snapshotChecksumPrefix+hex.EncodeToString(sum[:]) {
+			return metrics.Metrics{}, 0, fmt.Errorf("readSnapshot: %w: %s: checksum mismatch", ErrInvalidSnapshot, path)
+		}
+
+		if err = json.Unmarshal(body, &snap.Metrics); err != nil {
+			return metrics.Metrics{}, 0, fmt.Errorf("readSnapshot: %w: %s: %v", ErrInvalidSnapshot, path, err)
+		}
+		snap.WALSeq = header.WALSeq
+	default:
+		return metrics.Metrics{}, 0, fmt.Errorf("readSnapshot: %w: %s: unsupported version %d",
+			ErrInvalidSnapshot, path, header.Version)
+	}
+
+	if snap.Counter == nil {
+		snap.Counter = make(map[string]metrics.Metric)
+	}
+
+	if snap.Gauge == nil {
+		snap.Gauge = make(map[string]metrics.Metric)
+	}
+
+	return snap.Metrics, snap.WALSeq, nil
+}
+
+// loadSnapshot returns the latest valid snapshot of the kept ones, fs.ErrNotExist when
+// there is no snapshot at all and ErrInvalidSnapshot when all of them are damaged.
+func loadSnapshot(path string, keep int) (metrics.Metrics, uint64, error) {
+	found := false
+	for n := 0; n < keep; n++ {
+		m, walSeq, err := readSnapshot(snapshotPath(path, n))
+		switch {
+		case err == nil:
+			if n != 0 {
+				logging.Warnf("restored previous snapshot %s", snapshotPath(path, n))
+			}
+			return m, walSeq, nil
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		default:
+			found = true
+			logging.Errorf("%v", err)
+		}
+	}
+
+	if found {
+		return metrics.Metrics{}, 0, fmt.Errorf("loadSnapshot: %w: no valid snapshot of %s", ErrInvalidSnapshot, path)
+	}
+
+	return metrics.Metrics{}, 0, fmt.Errorf("loadSnapshot: %s: %w", path, fs.ErrNotExist)
+}
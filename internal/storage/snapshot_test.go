@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_Rotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	s, err := NewMemoryStorage(path, WithSnapshotKeep(3))
+	require.NoError(t, err)
+	defer s.Close(ctx)
+
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "1")))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.IncrementCounter(ctx, "PollCount", 1))
+		require.NoError(t, s.StoreMetrics())
+	}
+
+	for n, want := range []int64{5, 4, 3} {
+		m, _, err := readSnapshot(snapshotPath(path, n))
+		require.NoError(t, err)
+		assert.Equal(t, want, *m.Counter["PollCount"].Delta, "snapshot %d", n)
+	}
+
+	_, err = os.Stat(snapshotPath(path, 3))
+	assert.ErrorIs(t, err, os.ErrNotExist, "only the last snapshots are kept")
+	_, err = os.Stat(path + snapshotTempSuffix)
+	assert.ErrorIs(t, err, os.ErrNotExist, "temp file is renamed")
+}
+
+func TestSnapshot_Fallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, path string)
+		want    int64
+		wantErr bool
+	}{
+		{
+			name:   "latest is valid",
+			damage: func(t *testing.T, path string) {},
+			want:   2,
+		},
+		{
+			name: "latest is truncated",
+			damage: func(t *testing.T, path string) {
+				require.NoError(t, os.Truncate(path, 10))
+			},
+			want: 1,
+		},
+		{
+			name: "latest checksum mismatch",
+			damage: func(t *testing.T, path string) {
+				content, err := os.ReadFile(path)
+				require.NoError(t, err)
+				content[len(content)-3] = '7'
+				require.NoError(t, os.WriteFile(path, content, 0o644))
+			},
+			want: 1,
+		},
+		{
+			name: "latest is missing",
+			damage: func(t *testing.T, path string) {
+				require.NoError(t, os.Remove(path))
+			},
+			want: 1,
+		},
+		{
+			name: "all damaged",
+			damage: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, nil, 0o644))
+				require.NoError(t, os.WriteFile(snapshotPath(path, 1), []byte("{"), 0o644))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "metrics.json")
+
+			s, err := NewMemoryStorage(path)
+			require.NoError(t, err)
+			require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "1")))
+			require.NoError(t, s.StoreMetrics())
+			require.NoError(t, s.IncrementCounter(ctx, "PollCount", 1))
+			require.NoError(t, s.StoreMetrics())
+			require.NoError(t, s.Close(ctx))
+
+			tt.damage(t, path)
+
+			restored, err := NewMemoryStorage(path)
+			require.NoError(t, err)
+			defer restored.Close(ctx)
+
+			err = restored.LoadMetrics()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrLoadMetrics)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, counterValue(t, restored, "PollCount"))
+		})
+	}
+}
+
+func TestSnapshot_Version1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"counter":{"PollCount":{"id":"PollCount","type":"counter","delta":7}},"gauge":{}}`+"\n"), 0o644))
+
+	s, err := NewMemoryStorage(path)
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	require.NoError(t, s.LoadMetrics())
+	assert.Equal(t, int64(7), counterValue(t, s, "PollCount"))
+}
+
+func TestWithSnapshotKeep(t *testing.T) {
+	_, err := NewMemoryStorage("", WithSnapshotKeep(0))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/golang-migrate/migrate/v4"
+	//nolint:nolintlint
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "modernc.org/sqlite"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+// SQLiteDSNPrefix selects the SQLite storage in the database DSN, e.g. sqlite:///var/lib/yametrics/metrics.db.
+const SQLiteDSNPrefix = "sqlite://"
+
+const (
+	sqliteSave = "INSERT INTO metrics (name, type, delta, value) VALUES (?, ?, ?, ?) " +
+		"ON CONFLICT (name, type) DO UPDATE SET delta = excluded.delta, value = excluded.value"
+	sqliteIncrement = "INSERT INTO metrics (name, type, delta) VALUES (?, ?, ?) " +
+		"ON CONFLICT (name, type) DO UPDATE SET delta = metrics.delta + excluded.delta"
+)
+
+// NewSQLiteStorage opens the SQLite database at path with the pure-Go driver, creating it when
+// missing. SQLite has a single writer, so the storage uses one connection and waits for locks
+// held by other processes instead of failing.
+func NewSQLiteStorage(ctx context.Context, path string) (SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteStorage: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	for _, pragma := range []string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000"} {
+		if _, err = db.ExecContext(ctx, pragma); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("NewSQLiteStorage: %w", err)
+		}
+	}
+
+	logging.Infof("NewSQLiteStorage: success open %s", path)
+
+	return &sqliteStorage{db: db, path: path}, nil
+}
+
+func (s *sqliteStorage) Save(ctx context.Context, metric metrics.Metric) error {
+	if err := validMetricType(metric.MType, metric.ID); err != nil {
+		return fmt.Errorf("sqliteStorage_Save: %w", err)
+	}
+
+	_, err := s.db.ExecContext(ctx, sqliteSave,
+		metric.ID, metric.MType, metric.Int64Pointer(), metric.Float64Pointer())
+	if err != nil {
+		return fmt.Errorf("sqliteStorage_Save: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqliteStorage) GetMetric(ctx context.Context, metricType, metricID string) (*metrics.Metric, error) {
+	if err := validMetricType(metricType, metricID); err != nil {
+		return nil, fmt.Errorf("sqliteStorage_GetMetric: %w", err)
+	}
+
+	m := metrics.Metric{ID: metricID, MType: metricType}
+	err := s.db.QueryRowContext(ctx, "SELECT delta, value FROM metrics WHERE name = ? AND type = ?",
+		metricID, metricType).Scan(&m.Delta, &m.Value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("sqliteStorage_GetMetric: %s: %w", metricID, ErrNotFoundMetric)
+		}
+		return nil, fmt.Errorf("sqliteStorage_GetMetric: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (s *sqliteStorage) GetMetrics(ctx context.Context) (*metrics.Metrics, error) {
+	m := metrics.Metrics{
+		Counter: make(map[string]metrics.Metric),
+		Gauge:   make(map[string]metrics.Metric),
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, type, delta, value FROM metrics")
+	if err != nil {
+		return nil, fmt.Errorf("sqliteStorage_GetMetrics: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var v metrics.Metric
+		err = rows.Scan(&v.ID, &v.MType, &v.Delta, &v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("sqliteStorage_GetMetrics: %w", err)
+		}
+
+		switch v.MType {
+		case metrics.CounterStrName:
+			m.Counter[v.ID] = v
+		case metrics.GaugeStrName:
+			m.Gauge[v.ID] = v
+		default:
+			return nil, fmt.Errorf("sqliteStorage_GetMetrics: %w",
+				metrics.NewMetricError(v.MType, v.ID, metrics.ErrInvalidMetricType))
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("sqliteStorage_GetMetrics: %w", err)
+	}
+
+	return &m, nil
+}
+
+// IncrementCounter adds the value in a single upsert, so it's atomic and creates a missing counter
+// the same way pgStorage does.
+func (s *sqliteStorage) IncrementCounter(ctx context.Context, metricID string, value int64) error {
+	_, err := s.db.ExecContext(ctx, sqliteIncrement, metricID, metrics.CounterStrName, value)
+	if err != nil {
+		return fmt.Errorf("sqliteStorage_IncrementCounter: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqliteStorage) BatchMetrics(ctx context.Context, m []metrics.Metric) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqliteStorage_BatchMetrics: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	save, err := tx.PrepareContext(ctx, sqliteSave)
+	if err != nil {
+		return fmt.Errorf("sqliteStorage_BatchMetrics: %w", err)
+	}
+	defer save.Close()
+
+	increment, err := tx.PrepareContext(ctx, sqliteIncrement)
+	if err != nil {
+		return fmt.Errorf("sqliteStorage_BatchMetrics: %w", err)
+	}
+	defer increment.Close()
+
+	for _, metric := range m {
+		switch metric.MType {
+		case metrics.CounterStrName:
+			_, err = increment.ExecContext(ctx, metric.ID, metric.MType, metric.Int64Value())
+		case metrics.GaugeStrName:
+			_, err = save.ExecContext(ctx,
+				metric.ID, metric.MType, metric.Int64Pointer(), metric.Float64Pointer())
+		default:
+			err = metrics.NewMetricError(metric.MType, metric.ID, metrics.ErrInvalidMetricType)
+		}
+
+		if err != nil {
+			return fmt.Errorf("sqliteStorage_BatchMetrics: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqliteStorage_BatchMetrics: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqliteStorage) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("sqliteStorage_Ping: %w", ErrStorageUnavailable)
+	}
+	return nil
+}
+
+func (s *sqliteStorage) Close(ctx context.Context) error {
+	_ = ctx
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("sqliteStorage_Close: %w", err)
+	}
+	return nil
+}
+
+// Path is the database file.
+func (s *sqliteStorage) Path() string {
+	return s.path
+}
+
+// ValidateSchema applies the SQLite dialect migrations, see schema/sqlite.
+func (s *sqliteStorage) ValidateSchema(sourceMigrationsURL string) error {
+	m, err := migrate.New(sourceMigrationsURL, SQLiteDSNPrefix+s.path)
+	if err != nil {
+		return fmt.Errorf("sqliteStorage_ValidateSchema: %w", err)
+	}
+
+	defer m.Close()
+
+	err = m.Up()
+
+	if err != nil {
+		switch {
+		case errors.Is(err, migrate.ErrNoChange):
+			logging.Infof("Migrate up: %v", err)
+		default:
+			return fmt.Errorf("sqliteStorage_ValidateSchema: %w", err)
+		}
+	}
+	return nil
+}
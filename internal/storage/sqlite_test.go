@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func newTestSQLiteStorage(t *testing.T, path string) SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(context.Background(), path)
+	require.NoError(t, err)
+	require.NoError(t, s.ValidateSchema(testSQLiteMigrationsURL))
+	return s
+}
+
+func TestSQLiteStorage_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	s := newTestSQLiteStorage(t, path)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "10")))
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+	require.NoError(t, s.Save(ctx, testMetric(t, "Alloc", "gauge", "1.5")))
+	require.NoError(t, s.Save(ctx, testMetric(t, "Alloc", "gauge", "2.5")))
+	require.NoError(t, s.Close(ctx))
+
+	reopened := newTestSQLiteStorage(t, path)
+	defer reopened.Close(ctx)
+
+	assert.Equal(t, int64(15), counterValue(t, reopened, "PollCount"))
+	assert.Equal(t, 2.5, gaugeValue(t, reopened, "Alloc"))
+
+	m, err := reopened.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, m.Counter, 1)
+	assert.Len(t, m.Gauge, 1)
+	assert.NoError(t, reopened.Ping(ctx))
+}
+
+func TestSQLiteStorage_IncrementCounter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "metrics.db"))
+	defer s.Close(ctx)
+
+	// same upsert as pgStorage, a missing counter is created
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 3))
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 4))
+	assert.Equal(t, int64(7), counterValue(t, s, "PollCount"))
+}
+
+func TestSQLiteStorage_BatchMetrics(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "metrics.db"))
+	defer s.Close(ctx)
+
+	require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", "counter", "1"),
+		testMetric(t, "PollCount", "counter", "2"),
+		testMetric(t, "Alloc", "gauge", "1.5"),
+	}))
+	assert.Equal(t, int64(3), counterValue(t, s, "PollCount"))
+	assert.Equal(t, 1.5, gaugeValue(t, s, "Alloc"))
+
+	invalid := testMetric(t, "Alloc", "gauge", "2.5")
+	invalid.MType = "unknown"
+	err := s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", "counter", "10"),
+		invalid,
+	})
+	assert.Error(t, err)
+	assert.Equal(t, int64(3), counterValue(t, s, "PollCount"), "failed batch is rolled back")
+}
@@ -2,14 +2,15 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"os"
 	"sync"
 
 	//nolint:nolintlint
 	_ "github.com/golang-migrate/migrate/v4/database/pgx"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/sreway/yametrics/internal/metrics"
 )
@@ -22,14 +23,32 @@ var (
 )
 
 type (
+	// memoryPersistence is the snapshot and WAL state of the memory storages.
+	memoryPersistence struct {
+		// storeMu serializes the snapshot writes, they only read the metrics
+		storeMu      sync.Mutex
+		storeFile    string
+		snapshotKeep int
+		wal          *wal
+	}
+
 	memoryStorage struct {
+		memoryPersistence
 		metrics metrics.Metrics
 		mu      sync.RWMutex
-		fileObj *os.File
+	}
+
+	boltStorage struct {
+		db *bolt.DB
+	}
+
+	sqliteStorage struct {
+		db   *sql.DB
+		path string
 	}
 
 	pgStorage struct {
-		connection *pgx.Conn
+		pool *pgxpool.Pool
 	}
 
 	Storage interface {
@@ -47,9 +66,40 @@ type (
 		StoreMetrics() error
 	}
 
+	// BoltStorage is the embedded on-disk storage, see NewBoltStorage.
+	BoltStorage interface {
+		Storage
+		Path() string
+	}
+
+	// SQLiteStorage is the single-file SQL storage, see NewSQLiteStorage.
+	SQLiteStorage interface {
+		Storage
+		Path() string
+		Ping(ctx context.Context) error
+		ValidateSchema(sourceMigrationsURL string) error
+	}
+
+	// BufferedStorage is a database storage which buffers the updates while the database is
+	// unreachable, see NewBufferedStorage.
+	BufferedStorage interface {
+		Storage
+		Ping(ctx context.Context) error
+		Buffered() int
+	}
+
 	PgStorage interface {
 		Storage
 		Ping(ctx context.Context) error
 		ValidateSchema(sourceMigrationsURL string) error
+		Stats() PoolStats
 	}
 )
+
+// validMetricType checks the type for the SQL storages, which would store any type otherwise.
+func validMetricType(metricType, metricID string) error {
+	if metricType != metrics.CounterStrName && metricType != metrics.GaugeStrName {
+		return metrics.NewMetricError(metricType, metricID, metrics.ErrInvalidMetricType)
+	}
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/sreway/yametrics/internal/metrics"
 )
@@ -31,6 +33,32 @@ func NewTestMemoryStorage(metricID, metricType, metricValue, storageFile string)
 	return testStorage, err
 }
 
+const testSQLiteMigrationsURL = "file://../../schema/sqlite/"
+
+// testStorages are the backends the common storage tests run against.
+func testStorages(t *testing.T) map[string]func() Storage {
+	return map[string]func() Storage{
+		"memory": func() Storage {
+			s, err := NewMemoryStorage("")
+			require.NoError(t, err)
+			return s
+		},
+		"bolt": func() Storage {
+			s, err := NewBoltStorage(filepath.Join(t.TempDir(), "metrics.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = s.Close(context.Background()) })
+			return s
+		},
+		"sqlite": func() Storage {
+			s, err := NewSQLiteStorage(context.Background(), filepath.Join(t.TempDir(), "metrics.db"))
+			require.NoError(t, err)
+			require.NoError(t, s.ValidateSchema(testSQLiteMigrationsURL))
+			t.Cleanup(func() { _ = s.Close(context.Background()) })
+			return s
+		},
+	}
+}
+
 func OpenTestFile(path string) (*os.File, error) {
 	flag := os.O_RDWR | os.O_CREATE
 	fileObj, err := os.OpenFile(path, flag, 0o644)
@@ -81,15 +109,16 @@ func Test_storage_Save(t *testing.T) {
 			wantErr: true,
 		},
 	}
-	s, err := NewMemoryStorage("")
-	assert.NoError(t, err)
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			metric, _ := metrics.NewMetric(tt.args.metricID, tt.args.metricType, tt.args.metricValue)
-			if err := s.Save(context.Background(), metric); (err != nil) != tt.wantErr {
-				t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
+	for name, newStorage := range testStorages(t) {
+		s := newStorage()
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				metric, _ := metrics.NewMetric(tt.args.metricID, tt.args.metricType, tt.args.metricValue)
+				if err := s.Save(context.Background(), metric); (err != nil) != tt.wantErr {
+					t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
 	}
 }
 
@@ -164,25 +193,22 @@ func Test_storage_GetMetric(t *testing.T) {
 			wantErr: true,
 		},
 	}
-	var m Storage
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.fields.storageData != (storageData{}) {
-				testStorage, err := NewTestMemoryStorage(tt.fields.storageData.metricID,
-					tt.fields.storageData.metricType, tt.fields.storageData.metricValue, "")
-				assert.NoError(t, err)
-				m = testStorage
-			} else {
-				memStorage, err := NewMemoryStorage("")
-				assert.NoError(t, err)
-				m = memStorage
-			}
+	for name, newStorage := range testStorages(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				m := newStorage()
+				if tt.fields.storageData != (storageData{}) {
+					metric, err := metrics.NewMetric(tt.fields.storageData.metricID,
+						tt.fields.storageData.metricType, tt.fields.storageData.metricValue)
+					require.NoError(t, err)
+					require.NoError(t, m.Save(context.Background(), metric))
+				}
 
-			if _, err := m.GetMetric(context.Background(), tt.args.metricType, tt.args.metricID); (err != nil) != tt.wantErr {
-				t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
+				if _, err := m.GetMetric(context.Background(), tt.args.metricType, tt.args.metricID); (err != nil) != tt.wantErr {
+					t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
 	}
 }
 
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sreway/yametrics/internal/logging"
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+const (
+	// WALSyncAlways fsyncs the log after every operation, an acknowledged update survives a crash.
+	WALSyncAlways WALSyncPolicy = "always"
+	// WALSyncInterval fsyncs the log periodically, a crash loses at most the last interval.
+	WALSyncInterval WALSyncPolicy = "interval"
+	// WALSyncNever leaves flushing to the OS, the log survives a process crash but not a host crash.
+	WALSyncNever WALSyncPolicy = "never"
+
+	walOpSave      = "save"
+	walOpIncrement = "increment"
+	walOpBatch     = "batch"
+)
+
+var (
+	ErrInvalidWALSync = errors.New("invalid wal sync policy")
+	ErrWAL            = errors.New("wal error")
+)
+
+type (
+	WALSyncPolicy string
+
+	// walRecord is a line of the log. Seq grows monotonically across truncations, the snapshot
+	// stores the last seq it contains so records already in the snapshot are not replayed.
+	walRecord struct {
+		Seq     uint64           `json:"seq"`
+		Op      string           `json:"op"`
+		Metrics []metrics.Metric `json:"metrics,omitempty"`
+		ID      string           `json:"id,omitempty"`
+		Delta   int64            `json:"delta,omitempty"`
+	}
+
+	wal struct {
+		file   *os.File
+		policy WALSyncPolicy
+		seq    uint64
+		mu     sync.Mutex
+		dirty  bool
+		done   chan struct{}
+		wg     sync.WaitGroup
+	}
+
+	MemoryStorageOption func(p *memoryPersistence) error
+)
+
+func (p WALSyncPolicy) Valid() error {
+	switch p {
+	case WALSyncAlways, WALSyncInterval, WALSyncNever:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidWALSync, p)
+	}
+}
+
+// WithWAL logs every update of the memory storage to the append-only file at path, the log
+// is replayed by LoadMetrics and truncated by StoreMetrics. The interval is used by the
+// WALSyncInterval policy only.
+func WithWAL(path string, policy WALSyncPolicy, interval time.Duration) MemoryStorageOption {
+	return func(p *memoryPersistence) error {
+		w, err := openWAL(path, policy, interval)
+		if err != nil {
+			return fmt.Errorf("WithWAL: %w", err)
+		}
+		p.wal = w
+		return nil
+	}
+}
+
+func openWAL(path string, policy WALSyncPolicy, interval time.Duration) (*wal, error) {
+	if err := policy.Valid(); err != nil {
+		return nil, err
+	}
+
+	if policy == WALSyncInterval && interval <= 0 {
+		return nil, fmt.Errorf("%w: non-positive sync interval %s", ErrInvalidWALSync, interval)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("openWAL: %w", err)
+	}
+
+	w := &wal{file: file, policy: policy, done: make(chan struct{})}
+
+	// the records are read once to continue the sequence and to cut a torn tail,
+	// replay reads them again when the storage is restored
+	if _, err = w.replay(0, func(walRecord) error { return nil }); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("openWAL: %w", err)
+	}
+
+	if policy == WALSyncInterval {
+		w.wg.Add(1)
+		go w.syncLoop(interval)
+	}
+
+	return w, nil
+}
+
+// append writes the record with the next seq, the concurrent calls are serialized.
+func (w *wal) append(record walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	record.Seq = w.seq + 1
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("%w: can't encode record: %v", ErrWAL, err)
+	}
+
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("%w: can't seek: %v", ErrWAL, err)
+	}
+
+	if _, err = w.file.Write(append(data, '\n')); err != nil {
+		// a partial record would hide the following ones from replay
+		_ = w.file.Truncate(offset)
+		_, _ = w.file.Seek(offset, io.SeekStart)
+		return fmt.Errorf("%w: can't write record: %v", ErrWAL, err)
+	}
+	w.seq = record.Seq
+
+	if w.policy == WALSyncAlways {
+		if err = w.file.Sync(); err != nil {
+			return fmt.Errorf("%w: can't sync: %v", ErrWAL, err)
+		}
+		return nil
+	}
+
+	w.dirty = true
+	return nil
+}
+
+// replay applies the records with seq greater than after and returns the number of applied records.
+// A record that can't be decoded is a write torn by a crash, the log is cut before it.
+func (w *wal) replay(after uint64, apply func(record walRecord) error) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("%w: can't seek: %v", ErrWAL, err)
+	}
+
+	var (
+		offset  int64
+		applied int
+		reader  = bufio.NewReader(w.file)
+	)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) != 0 {
+				logging.Warnf("wal: torn record at offset %d, discarded", offset)
+			}
+			break
+		}
+		if err != nil {
+			return applied, fmt.Errorf("%w: can't read: %v", ErrWAL, err)
+		}
+
+		var record walRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			logging.Warnf("wal: invalid record at offset %d, discarded with the rest of the log", offset)
+			break
+		}
+
+		if record.Seq > after {
+			if err = apply(record); err != nil {
+				return applied, fmt.Errorf("%w: replay record %d: %v", ErrWAL, record.Seq, err)
+			}
+			applied++
+		}
+
+		if record.Seq > w.seq {
+			w.seq = record.Seq
+		}
+		offset += int64(len(line))
+	}
+
+	if err := w.file.Truncate(offset); err != nil {
+		return applied, fmt.Errorf("%w: can't truncate: %v", ErrWAL, err)
+	}
+
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return applied, fmt.Errorf("%w: can't seek: %v", ErrWAL, err)
+	}
+
+	return applied, nil
+}
+
+// lastSeq is the seq of the last appended record.
+func (w *wal) lastSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// truncate drops the records up to the snapshot, the seq keeps growing.
+func (w *wal) truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("%w: can't truncate: %v", ErrWAL, err)
+	}
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("%w: can't seek: %v", ErrWAL, err)
+	}
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("%w: can't sync: %v", ErrWAL, err)
+	}
+	w.dirty = false
+
+	return nil
+}
+
+func (w *wal) syncLoop(interval time.Duration) {
+	defer w.wg.Done()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := w.sync(); err != nil {
+				logging.Errorf("%v", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("%w: can't sync: %v", ErrWAL, err)
+	}
+	w.dirty = false
+
+	return nil
+}
+
+func (w *wal) close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
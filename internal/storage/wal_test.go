@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+func newTestWALStorage(t *testing.T, dir string) MemoryStorage {
+	t.Helper()
+	s, err := NewMemoryStorage(filepath.Join(dir, "metrics.json"),
+		WithWAL(filepath.Join(dir, "metrics.wal"), WALSyncAlways, 0))
+	require.NoError(t, err)
+	return s
+}
+
+func testMetric(t *testing.T, id, metricType, value string) metrics.Metric {
+	t.Helper()
+	metric, err := metrics.NewMetric(id, metricType, value)
+	require.NoError(t, err)
+	return metric
+}
+
+func counterValue(t *testing.T, s Storage, id string) int64 {
+	t.Helper()
+	metric, err := s.GetMetric(context.Background(), metrics.CounterStrName, id)
+	require.NoError(t, err)
+	return *metric.Delta
+}
+
+func gaugeValue(t *testing.T, s Storage, id string) float64 {
+	t.Helper()
+	metric, err := s.GetMetric(context.Background(), metrics.GaugeStrName, id)
+	require.NoError(t, err)
+	return *metric.Value
+}
+
+func TestWAL_Replay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := newTestWALStorage(t, dir)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "10")))
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+	require.NoError(t, s.BatchMetrics(ctx, []metrics.Metric{
+		testMetric(t, "PollCount", "counter", "1"),
+		testMetric(t, "Alloc", "gauge", "1.5"),
+	}))
+	// the process crashes, no snapshot is written
+	require.NoError(t, s.Close(ctx))
+
+	restored := newTestWALStorage(t, dir)
+	defer restored.Close(ctx)
+	require.NoError(t, restored.LoadMetrics())
+	assert.Equal(t, int64(16), counterValue(t, restored, "PollCount"))
+	assert.Equal(t, 1.5, gaugeValue(t, restored, "Alloc"))
+}
+
+func TestWAL_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "metrics.wal")
+
+	s := newTestWALStorage(t, dir)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "10")))
+	require.NoError(t, s.StoreMetrics())
+
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "snapshot truncates the log")
+
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+	require.NoError(t, s.Close(ctx))
+
+	restored := newTestWALStorage(t, dir)
+	defer restored.Close(ctx)
+	require.NoError(t, restored.LoadMetrics())
+	assert.Equal(t, int64(15), counterValue(t, restored, "PollCount"))
+}
+
+func TestWAL_SnapshotBeforeTruncate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "metrics.wal")
+
+	s := newTestWALStorage(t, dir)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "10")))
+	content, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	require.NoError(t, s.StoreMetrics())
+	require.NoError(t, s.IncrementCounter(ctx, "PollCount", 5))
+	require.NoError(t, s.Close(ctx))
+
+	// the crash hit between the snapshot and the truncation, the old record is still in the log
+	current, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(walPath, append(content, current...), 0o644))
+
+	restored := newTestWALStorage(t, dir)
+	defer restored.Close(ctx)
+	require.NoError(t, restored.LoadMetrics())
+	assert.Equal(t, int64(15), counterValue(t, restored, "PollCount"))
+}
+
+func TestWAL_TornRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "metrics.wal")
+
+	s := newTestWALStorage(t, dir)
+	require.NoError(t, s.Save(ctx, testMetric(t, "PollCount", "counter", "10")))
+	require.NoError(t, s.Close(ctx))
+
+	file, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":2,"op":"increment","id":"Poll`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restored := newTestWALStorage(t, dir)
+	require.NoError(t, restored.LoadMetrics())
+	assert.Equal(t, int64(10), counterValue(t, restored, "PollCount"))
+
+	// the torn tail is cut, so new records are not hidden behind it
+	require.NoError(t, restored.IncrementCounter(ctx, "PollCount", 1))
+	require.NoError(t, restored.Close(ctx))
+
+	again := newTestWALStorage(t, dir)
+	defer again.Close(ctx)
+	require.NoError(t, again.LoadMetrics())
+	assert.Equal(t, int64(11), counterValue(t, again, "PollCount"))
+}
+
+func TestWithWAL(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		policy   WALSyncPolicy
+		interval time.Duration
+		wantErr  bool
+	}{
+		{name: "always", policy: WALSyncAlways},
+		{name: "interval", policy: WALSyncInterval, interval: time.Millisecond},
+		{name: "never", policy: WALSyncNever},
+		{name: "interval without duration", policy: WALSyncInterval, wantErr: true},
+		{name: "unknown policy", policy: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewMemoryStorage("", WithWAL(filepath.Join(dir, tt.name+".wal"), tt.policy, tt.interval))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidWALSync)
+				return
+			}
+			require.NoError(t, err)
+			assert.NoError(t, s.Save(context.Background(), testMetric(t, "Alloc", "gauge", "1")))
+			assert.NoError(t, s.Close(context.Background()))
+		})
+	}
+}
@@ -0,0 +1,741 @@
+package testutil
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/stretchr/testify/require"
+	// the stand-in stores the data in SQLite
+	_ "modernc.org/sqlite"
+)
+
+// pgStandIn is an in-process stand-in for a PostgreSQL server: it speaks the wire protocol
+// pgx uses and runs the statements of the Postgres storage on SQLite after translating the few
+// PostgreSQL-only constructs they use. It covers the Postgres storage, the schema is applied
+// from the migration directly rather than by ValidateSchema.
+//
+// Sessions run one at a time, a transaction holds the database until it ends.
+type pgStandIn struct {
+	listener net.Listener
+	db       *sql.Conn
+	mu       sync.Mutex
+	// constraints are the columns of the named unique constraints of the schema.
+	constraints map[string]string
+	wg          sync.WaitGroup
+}
+
+const (
+	pgOIDInt8   = 20
+	pgOIDText   = 25
+	pgOIDFloat8 = 701
+
+	pgCopySignature = "PGCOPY\n\xff\r\n\x00"
+)
+
+var (
+	pgStandInConstraint = regexp.MustCompile(`(?i)CONSTRAINT\s+(\w+)\s+UNIQUE\s*\(([^)]*)\)`)
+	pgStandInOnConflict = regexp.MustCompile(`(?i)ON CONFLICT ON CONSTRAINT (\w+)`)
+	pgStandInDistinctOn = regexp.MustCompile(`(?i)SELECT DISTINCT ON \(([^)]*)\) (.+?) FROM (.+?) ORDER BY ([^)]*)`)
+	pgStandInInsertFrom = regexp.MustCompile(`(?is)^(INSERT\s.*\sSELECT\s.*?)(\s+ON CONFLICT\s)`)
+	pgStandInParam      = regexp.MustCompile(`\$(\d+)`)
+	pgStandInCast       = regexp.MustCompile(`::\w+`)
+	pgStandInOnCommit   = regexp.MustCompile(`(?i)^CREATE TEMP TABLE (\w+)(.*) ON COMMIT DROP$`)
+	pgStandInCopy       = regexp.MustCompile(`(?i)^copy "?(\w+)"? \(([^)]*)\) from stdin binary;?$`)
+	pgStandInColumnArg  = regexp.MustCompile(`(\w+)\s*=\s*\$(\d+)`)
+	pgStandInInsert     = regexp.MustCompile(`(?i)INSERT INTO \w+ \(([^)]*)\) VALUES \(([^)]*)\)`)
+)
+
+// newPgStandIn starts the stand-in with the schema of migration and returns its DSN.
+func newPgStandIn(tb testing.TB, migration string) string {
+	tb.Helper()
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = db.Close() })
+
+	conn, err := db.Conn(ctx)
+	require.NoError(tb, err)
+
+	schema, err := os.ReadFile(migration)
+	require.NoError(tb, err)
+	_, err = conn.ExecContext(ctx, string(schema))
+	require.NoError(tb, err)
+
+	s := &pgStandIn{db: conn, constraints: make(map[string]string)}
+	for _, match := range pgStandInConstraint.FindAllStringSubmatch(string(schema), -1) {
+		s.constraints[match[1]] = match[2]
+	}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		_ = s.listener.Close()
+		s.wg.Wait()
+		_ = conn.Close()
+	})
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer c.Close()
+				(&pgStandInSession{server: s, conn: c}).serve()
+			}()
+		}
+	}()
+
+	return fmt.Sprintf("postgres://standin@%s/metrics?sslmode=disable", s.listener.Addr())
+}
+
+// translate rewrites a PostgreSQL statement of the Postgres storage to SQLite.
+func (s *pgStandIn) translate(query string) string {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	query = pgStandInOnConflict.ReplaceAllStringFunc(query, func(match string) string {
+		name := pgStandInOnConflict.FindStringSubmatch(match)[1]
+		return "ON CONFLICT (" + s.constraints[name] + ")"
+	})
+	query = pgStandInDistinctOn.ReplaceAllString(query,
+		"SELECT $2 FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY $1 ORDER BY $4) AS distinct_on_rn FROM $3) "+
+			"WHERE distinct_on_rn = 1")
+	// SQLite needs a WHERE before the ON CONFLICT of INSERT ... SELECT to parse it, the last
+	// SELECT of the statements translated here has none
+	if !strings.Contains(strings.ToUpper(query), " VALUES ") {
+		query = pgStandInInsertFrom.ReplaceAllString(query, "$1 WHERE true$2")
+	}
+	query = pgStandInCast.ReplaceAllString(query, "")
+	return pgStandInParam.ReplaceAllString(query, "?$1")
+}
+
+// columnOIDs maps the column names of the schema to the type OIDs reported to the client.
+func (s *pgStandIn) columnOIDs() (map[string]uint32, error) {
+	ctx := context.Background()
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT p.name, p.type FROM sqlite_temp_master m, pragma_table_info(m.name) p WHERE m.type = 'table' "+
+			"UNION ALL "+
+			"SELECT p.name, p.type FROM sqlite_master m, pragma_table_info(m.name) p WHERE m.type = 'table'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	oids := make(map[string]uint32)
+	for rows.Next() {
+		var name, declType string
+		if err = rows.Scan(&name, &declType); err != nil {
+			return nil, err
+		}
+		oids[strings.ToLower(name)] = typeOID(declType)
+	}
+
+	return oids, rows.Err()
+}
+
+func typeOID(declType string) uint32 {
+	declType = strings.ToUpper(declType)
+	switch {
+	case strings.Contains(declType, "INT"), declType == "SERIAL":
+		return pgOIDInt8
+	case strings.Contains(declType, "DOUBLE"), strings.Contains(declType, "REAL"), strings.Contains(declType, "FLOAT"):
+		return pgOIDFloat8
+	default:
+		return pgOIDText
+	}
+}
+
+type (
+	pgStandInSession struct {
+		server  *pgStandIn
+		conn    net.Conn
+		backend *pgproto3.Backend
+		w       *bufio.Writer
+		locked  bool
+		// txStatus is the transaction status of ReadyForQuery: idle, in a transaction or failed
+		txStatus   byte
+		onCommit   []string
+		statements map[string]*pgStandInStatement
+		portal     *pgStandInPortal
+		// skip drops the extended protocol messages after an error until the next Sync
+		skip bool
+	}
+
+	pgStandInStatement struct {
+		query     string
+		paramOIDs []uint32
+		fields    []pgproto3.FieldDescription
+	}
+
+	pgStandInPortal struct {
+		statement     *pgStandInStatement
+		args          []interface{}
+		resultFormats []int16
+	}
+)
+
+func (c *pgStandInSession) serve() {
+	c.backend = pgproto3.NewBackend(pgproto3.NewChunkReader(c.conn), c.conn)
+	c.w = bufio.NewWriter(c.conn)
+	c.statements = make(map[string]*pgStandInStatement)
+	c.txStatus = 'I'
+
+	defer c.release()
+
+	startup, err := c.backend.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if _, ok := startup.(*pgproto3.StartupMessage); !ok {
+		return
+	}
+
+	c.send(&pgproto3.AuthenticationOk{})
+	for name, value := range map[string]string{
+		"server_version":              "14.0",
+		"client_encoding":             "UTF8",
+		"standard_conforming_strings": "on",
+		"integer_datetimes":           "on",
+	} {
+		c.send(&pgproto3.ParameterStatus{Name: name, Value: value})
+	}
+	c.send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	c.ready()
+
+	for {
+		msg, err := c.backend.Receive()
+		if err != nil {
+			return
+		}
+
+		if _, ok := msg.(*pgproto3.Sync); ok {
+			c.skip = false
+			c.ready()
+			continue
+		}
+
+		if c.skip {
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			c.query(msg.String)
+			c.skip = false
+			c.ready()
+		case *pgproto3.Parse:
+			c.fail(c.parse(msg))
+		case *pgproto3.Describe:
+			c.fail(c.describe(msg))
+		case *pgproto3.Bind:
+			c.fail(c.bind(msg))
+		case *pgproto3.Execute:
+			c.fail(c.execute())
+		case *pgproto3.Close:
+			delete(c.statements, msg.Name)
+			c.send(&pgproto3.CloseComplete{})
+		case *pgproto3.Flush:
+			_ = c.w.Flush()
+		case *pgproto3.Terminate:
+			return
+		default:
+			c.fail(fmt.Errorf("unsupported message %T", msg))
+		}
+	}
+}
+
+func (c *pgStandInSession) send(msg pgproto3.BackendMessage) {
+	_, _ = c.w.Write(msg.Encode(nil))
+}
+
+// lock takes the database for the session until it's idle again.
+func (c *pgStandInSession) lock() {
+	if !c.locked {
+		c.server.mu.Lock()
+		c.locked = true
+	}
+}
+
+func (c *pgStandInSession) ready() {
+	c.send(&pgproto3.ReadyForQuery{TxStatus: c.txStatus})
+	_ = c.w.Flush()
+	if c.txStatus == 'I' && c.locked {
+		c.locked = false
+		c.server.mu.Unlock()
+	}
+}
+
+// release rolls back the transaction of a broken session.
+func (c *pgStandInSession) release() {
+	if c.txStatus != 'I' {
+		_, _ = c.server.db.ExecContext(context.Background(), "ROLLBACK")
+		c.endTx()
+	}
+	if c.locked {
+		c.locked = false
+		c.server.mu.Unlock()
+	}
+}
+
+func (c *pgStandInSession) endTx() {
+	for _, table := range c.onCommit {
+		_, _ = c.server.db.ExecContext(context.Background(), "DROP TABLE IF EXISTS temp."+table)
+	}
+	c.onCommit = nil
+	c.txStatus = 'I'
+}
+
+// fail reports err, the following extended protocol messages are skipped until Sync.
+func (c *pgStandInSession) fail(err error) {
+	if err == nil {
+		return
+	}
+
+	c.send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: err.Error()})
+	c.skip = true
+	if c.txStatus == 'T' {
+		c.txStatus = 'E'
+	}
+}
+
+// query runs a statement of the simple protocol: transaction control, statements without
+// arguments and COPY.
+func (c *pgStandInSession) query(query string) {
+	c.lock()
+	ctx := context.Background()
+	query = strings.TrimSpace(query)
+
+	switch command := strings.ToLower(strings.TrimSuffix(query, ";")); {
+	case command == "":
+		c.send(&pgproto3.EmptyQueryResponse{})
+		return
+	case c.txStatus == 'E' && command != "commit" && command != "rollback":
+		c.fail(errors.New("current transaction is aborted, commands ignored until end of transaction block"))
+		return
+	case strings.HasPrefix(command, "begin"):
+		if _, err := c.server.db.ExecContext(ctx, "BEGIN"); err != nil {
+			c.fail(err)
+			return
+		}
+		c.txStatus = 'T'
+		c.send(&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")})
+		return
+	case command == "commit", command == "rollback":
+		tag, statement := strings.ToUpper(command), strings.ToUpper(command)
+		if c.txStatus == 'E' {
+			tag, statement = "ROLLBACK", "ROLLBACK"
+		}
+		_, err := c.server.db.ExecContext(ctx, statement)
+		c.endTx()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+		return
+	}
+
+	if match := pgStandInCopy.FindStringSubmatch(query); match != nil {
+		c.fail(c.copyFrom(match[1], strings.Split(strings.ReplaceAll(match[2], `"`, ""), ",")))
+		return
+	}
+
+	query = strings.TrimSuffix(query, ";")
+	if match := pgStandInOnCommit.FindStringSubmatch(query); match != nil {
+		c.onCommit = append(c.onCommit, match[1])
+		query = "CREATE TEMP TABLE " + match[1] + match[2]
+	}
+
+	result, err := c.server.db.ExecContext(ctx, c.server.translate(query))
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.send(&pgproto3.CommandComplete{CommandTag: commandTag(query, result)})
+}
+
+func commandTag(query string, result sql.Result) []byte {
+	command := strings.ToUpper(strings.Fields(query)[0])
+	rows, _ := result.RowsAffected()
+	switch command {
+	case "INSERT":
+		return []byte(fmt.Sprintf("INSERT 0 %d", rows))
+	case "UPDATE", "DELETE":
+		return []byte(fmt.Sprintf("%s %d", command, rows))
+	default:
+		return []byte(command)
+	}
+}
+
+func (c *pgStandInSession) parse(msg *pgproto3.Parse) error {
+	c.lock()
+	if c.txStatus == 'E' {
+		return errors.New("current transaction is aborted")
+	}
+
+	oids, err := c.server.columnOIDs()
+	if err != nil {
+		return err
+	}
+
+	statement := &pgStandInStatement{query: c.server.translate(msg.Query)}
+
+	// the type of a parameter is the type of the column it's compared with or inserted into
+	paramColumns := make(map[int]string)
+	for _, match := range pgStandInColumnArg.FindAllStringSubmatch(msg.Query, -1) {
+		n, _ := strconv.Atoi(match[2])
+		paramColumns[n] = match[1]
+	}
+	if match := pgStandInInsert.FindStringSubmatch(msg.Query); match != nil {
+		columns, values := strings.Split(match[1], ","), strings.Split(match[2], ",")
+		for i, value := range values {
+			if n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(value), "$")); err == nil && i < len(columns) {
+				paramColumns[n] = strings.TrimSpace(columns[i])
+			}
+		}
+	}
+	for _, match := range pgStandInParam.FindAllStringSubmatch(msg.Query, -1) {
+		n, _ := strconv.Atoi(match[1])
+		for len(statement.paramOIDs) < n {
+			statement.paramOIDs = append(statement.paramOIDs, pgOIDText)
+		}
+		if oid, ok := oids[strings.ToLower(paramColumns[n])]; ok {
+			statement.paramOIDs[n-1] = oid
+		}
+	}
+
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(msg.Query)), "SELECT") {
+		// the column types are known once SQLite prepared the query, no rows are read
+		rows, err := c.server.db.QueryContext(context.Background(), statement.query,
+			make([]interface{}, len(statement.paramOIDs))...)
+		if err != nil {
+			return err
+		}
+		columns, err := rows.ColumnTypes()
+		_ = rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, column := range columns {
+			statement.fields = append(statement.fields, pgproto3.FieldDescription{
+				Name:         []byte(column.Name()),
+				DataTypeOID:  typeOID(column.DatabaseTypeName()),
+				DataTypeSize: -1,
+				TypeModifier: -1,
+			})
+		}
+	}
+
+	c.statements[msg.Name] = statement
+	c.send(&pgproto3.ParseComplete{})
+	return nil
+}
+
+func (c *pgStandInSession) describe(msg *pgproto3.Describe) error {
+	c.lock()
+	if msg.ObjectType == 'P' {
+		if c.portal == nil {
+			return errors.New("unknown portal")
+		}
+		c.sendFields(c.portal.statement.fields, c.portal.resultFormats)
+		return nil
+	}
+
+	statement, ok := c.statements[msg.Name]
+	if !ok {
+		return fmt.Errorf("prepared statement %q does not exist", msg.Name)
+	}
+
+	c.send(&pgproto3.ParameterDescription{ParameterOIDs: statement.paramOIDs})
+	c.sendFields(statement.fields, nil)
+	return nil
+}
+
+func (c *pgStandInSession) sendFields(fields []pgproto3.FieldDescription, formats []int16) {
+	if len(fields) == 0 {
+		c.send(&pgproto3.NoData{})
+		return
+	}
+
+	described := make([]pgproto3.FieldDescription, len(fields))
+	for i, field := range fields {
+		field.Format = formatCode(formats, i)
+		described[i] = field
+	}
+	c.send(&pgproto3.RowDescription{Fields: described})
+}
+
+// formatCode is the format of the i-th value, one code applies to all values.
+func formatCode(codes []int16, i int) int16 {
+	switch {
+	case len(codes) == 0:
+		return 0
+	case len(codes) == 1:
+		return codes[0]
+	default:
+		return codes[i]
+	}
+}
+
+func (c *pgStandInSession) bind(msg *pgproto3.Bind) error {
+	c.lock()
+	statement, ok := c.statements[msg.PreparedStatement]
+	if !ok {
+		return fmt.Errorf("prepared statement %q does not exist", msg.PreparedStatement)
+	}
+
+	formats := make([]int16, len(msg.ParameterFormatCodes))
+	for i, code := range msg.ParameterFormatCodes {
+		formats[i] = int16(code)
+	}
+
+	portal := &pgStandInPortal{statement: statement, args: make([]interface{}, len(msg.Parameters))}
+	for i, value := range msg.Parameters {
+		arg, err := decodeValue(statement.paramOIDs[i], formatCode(formats, i), value)
+		if err != nil {
+			return err
+		}
+		portal.args[i] = arg
+	}
+	for _, code := range msg.ResultFormatCodes {
+		portal.resultFormats = append(portal.resultFormats, int16(code))
+	}
+
+	c.portal = portal
+	c.send(&pgproto3.BindComplete{})
+	return nil
+}
+
+func (c *pgStandInSession) execute() error {
+	c.lock()
+	if c.portal == nil {
+		return errors.New("unknown portal")
+	}
+	if c.txStatus == 'E' {
+		return errors.New("current transaction is aborted")
+	}
+
+	ctx := context.Background()
+	statement := c.portal.statement
+	if len(statement.fields) == 0 {
+		result, err := c.server.db.ExecContext(ctx, statement.query, c.portal.args...)
+		if err != nil {
+			return err
+		}
+		c.send(&pgproto3.CommandComplete{CommandTag: commandTag(statement.query, result)})
+		return nil
+	}
+
+	rows, err := c.server.db.QueryContext(ctx, statement.query, c.portal.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		values := make([]interface{}, len(statement.fields))
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		row := &pgproto3.DataRow{Values: make([][]byte, len(values))}
+		for i, value := range values {
+			if row.Values[i], err = encodeValue(statement.fields[i].DataTypeOID,
+				formatCode(c.portal.resultFormats, i), value); err != nil {
+				return err
+			}
+		}
+		c.send(row)
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	c.send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("SELECT %d", count))})
+	return nil
+}
+
+// copyFrom receives the binary COPY data of the columns and inserts the rows into table.
+func (c *pgStandInSession) copyFrom(table string, columns []string) error {
+	oids, err := c.server.columnOIDs()
+	if err != nil {
+		return err
+	}
+
+	formats := make([]uint16, len(columns))
+	for i := range formats {
+		formats[i] = 1
+	}
+	c.send(&pgproto3.CopyInResponse{OverallFormat: 1, ColumnFormatCodes: formats})
+	_ = c.w.Flush()
+
+	var data []byte
+	for done := false; !done; {
+		msg, err := c.backend.Receive()
+		if err != nil {
+			return err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			data = append(data, msg.Data...)
+		case *pgproto3.CopyDone:
+			done = true
+		case *pgproto3.CopyFail:
+			return fmt.Errorf("COPY from stdin failed: %s", msg.Message)
+		default:
+			return fmt.Errorf("unexpected message %T during COPY", msg)
+		}
+	}
+
+	if !strings.HasPrefix(string(data), pgCopySignature) || len(data) < len(pgCopySignature)+8 {
+		return errors.New("invalid COPY file header")
+	}
+	data = data[len(pgCopySignature)+4:]
+	extension := int(binary.BigEndian.Uint32(data))
+	data = data[4+extension:]
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+
+	// the trailer is optional, pgx ends the data after the last tuple
+	count := 0
+	for len(data) != 0 {
+		if len(data) < 2 {
+			return io.ErrUnexpectedEOF
+		}
+		fields := int16(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if fields == -1 {
+			break
+		}
+
+		args := make([]interface{}, fields)
+		for i := range args {
+			if len(data) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			size := int32(binary.BigEndian.Uint32(data))
+			data = data[4:]
+			if size == -1 {
+				continue
+			}
+
+			args[i], err = decodeValue(oids[strings.TrimSpace(columns[i])], 1, data[:size])
+			if err != nil {
+				return err
+			}
+			data = data[size:]
+		}
+
+		if _, err = c.server.db.ExecContext(context.Background(), insert, args...); err != nil {
+			return err
+		}
+		count++
+	}
+
+	c.send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("COPY %d", count))})
+	return nil
+}
+
+func decodeValue(oid uint32, format int16, value []byte) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch {
+	case oid == pgOIDInt8 && format == 1:
+		if len(value) != 8 {
+			return nil, fmt.Errorf("invalid int8 of %d bytes", len(value))
+		}
+		return int64(binary.BigEndian.Uint64(value)), nil
+	case oid == pgOIDInt8:
+		return strconv.ParseInt(string(value), 10, 64)
+	case oid == pgOIDFloat8 && format == 1:
+		if len(value) != 8 {
+			return nil, fmt.Errorf("invalid float8 of %d bytes", len(value))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), nil
+	case oid == pgOIDFloat8:
+		return strconv.ParseFloat(string(value), 64)
+	default:
+		return string(value), nil
+	}
+}
+
+func encodeValue(oid uint32, format int16, value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch oid {
+	case pgOIDInt8:
+		var v int64
+		switch value := value.(type) {
+		case int64:
+			v = value
+		case float64:
+			v = int64(value)
+		default:
+			return nil, fmt.Errorf("can't encode %T as int8", value)
+		}
+		if format == 1 {
+			return encodeUint64(uint64(v)), nil
+		}
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case pgOIDFloat8:
+		var v float64
+		switch value := value.(type) {
+		case float64:
+			v = value
+		case int64:
+			v = float64(value)
+		default:
+			return nil, fmt.Errorf("can't encode %T as float8", value)
+		}
+		if format == 1 {
+			return encodeUint64(math.Float64bits(v)), nil
+		}
+		return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	default:
+		switch value := value.(type) {
+		case string:
+			return []byte(value), nil
+		case []byte:
+			return value, nil
+		default:
+			return []byte(fmt.Sprint(value)), nil
+		}
+	}
+}
+
+func encodeUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,22 @@
+// Package testutil holds the helpers shared by the tests of several packages.
+package testutil
+
+import (
+	"os"
+	"testing"
+)
+
+// DatabaseDSN is TEST_DATABASE_DSN, without it the tests run against an in-process stand-in
+// with the schema of migration, except in CI which must run them against a real server.
+// real reports whether the DSN is a PostgreSQL server, the stand-in doesn't run ValidateSchema.
+func DatabaseDSN(tb testing.TB, migration string) (dsn string, real bool) {
+	tb.Helper()
+	if dsn = os.Getenv("TEST_DATABASE_DSN"); dsn != "" {
+		return dsn, true
+	}
+
+	if os.Getenv("CI") != "" {
+		tb.Fatal("TEST_DATABASE_DSN is required in CI")
+	}
+	return newPgStandIn(tb, migration), false
+}
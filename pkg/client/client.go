@@ -0,0 +1,341 @@
+// Package client reports metrics to the yametrics server without running the agent.
+//
+//	c, err := client.New("127.0.0.1:8080", client.WithKey(key))
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close(context.Background())
+//
+//	c.Counter("Requests").Add(1)
+//	c.Gauge("QueueLength").Set(17)
+//
+// Updates are batched in memory and sent to /updates/ on the flush interval.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+var (
+	FlushIntervalDefault = 10 * time.Second
+	BatchSizeDefault     = 1000
+
+	ErrInvalidOption  = errors.New("invalid client option")
+	ErrUnexpectedCode = errors.New("unexpected response status")
+	ErrRejected       = errors.New("batch rejected")
+	ErrClosed         = errors.New("client closed")
+)
+
+type (
+	// Client accumulates counter increments and the last gauge values until they are flushed.
+	// It is safe for concurrent use.
+	Client struct {
+		endpoint      string
+		key           string
+		flushInterval time.Duration
+		batchSize     int
+		httpClient    *http.Client
+		errorHandler  func(error)
+
+		mu       sync.Mutex
+		counters map[string]int64
+		gauges   map[string]float64
+		closed   bool
+
+		flush chan struct{}
+		done  chan struct{}
+		wg    sync.WaitGroup
+	}
+
+	Option func(*Client) error
+
+	Counter struct {
+		client *Client
+		name   string
+	}
+
+	Gauge struct {
+		client *Client
+		name   string
+	}
+)
+
+// New creates a client for the server at address, either "host:port" or a base URL,
+// and starts the background flush.
+func New(address string, opts ...Option) (*Client, error) {
+	if address == "" {
+		return nil, fmt.Errorf("New: %w: empty address", ErrInvalidOption)
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	c := &Client{
+		endpoint:      strings.TrimSuffix(address, "/") + "/updates/",
+		flushInterval: FlushIntervalDefault,
+		batchSize:     BatchSizeDefault,
+		httpClient:    &http.Client{Timeout: 5 * time.Second},
+		errorHandler: func(err error) {
+			log.Printf("yametrics client: %v", err)
+		},
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, fmt.Errorf("New: %w", err)
+		}
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c, nil
+}
+
+// WithKey signs every metric with the HMAC-SHA256 key shared with the server.
+func WithKey(key string) Option {
+	return func(c *Client) error {
+		c.key = key
+		return nil
+	}
+}
+
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("WithFlushInterval: %w: %s", ErrInvalidOption, interval)
+		}
+
+		c.flushInterval = interval
+		return nil
+	}
+}
+
+// WithBatchSize flushes early once the number of pending metrics reaches size.
+func WithBatchSize(size int) Option {
+	return func(c *Client) error {
+		if size <= 0 {
+			return fmt.Errorf("WithBatchSize: %w: %d", ErrInvalidOption, size)
+		}
+
+		c.batchSize = size
+		return nil
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return fmt.Errorf("WithHTTPClient: %w: nil http client", ErrInvalidOption)
+		}
+
+		c.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithErrorHandler receives the errors of background flushes, by default they are logged.
+func WithErrorHandler(handler func(error)) Option {
+	return func(c *Client) error {
+		if handler == nil {
+			return fmt.Errorf("WithErrorHandler: %w: nil handler", ErrInvalidOption)
+		}
+
+		c.errorHandler = handler
+		return nil
+	}
+}
+
+func (c *Client) Counter(name string) Counter {
+	return Counter{client: c, name: name}
+}
+
+func (c *Client) Gauge(name string) Gauge {
+	return Gauge{client: c, name: name}
+}
+
+// Add increments the counter by n, the server sums the increments.
+func (c Counter) Add(n int64) {
+	c.client.update(func() {
+		c.client.counters[c.name] += n
+	})
+}
+
+// Set replaces the gauge value.
+func (g Gauge) Set(v float64) {
+	g.client.update(func() {
+		g.client.gauges[g.name] = v
+	})
+}
+
+func (c *Client) update(fn func()) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	fn()
+	full := len(c.counters)+len(c.gauges) >= c.batchSize
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+	tick := time.NewTicker(c.flushInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+		case <-c.flush:
+		case <-c.done:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.flushInterval)
+		if err := c.Flush(ctx); err != nil {
+			c.errorHandler(err)
+		}
+		cancel()
+	}
+}
+
+// Flush sends the pending metrics. If the server can't be reached they are kept
+// and merged with the updates made in the meantime. A batch the server rejects with
+// a permanent 4xx status is dropped and ErrRejected is returned, a retry would fail forever.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	counters, gauges := c.counters, c.gauges
+	c.counters = make(map[string]int64)
+	c.gauges = make(map[string]float64)
+	c.mu.Unlock()
+
+	if len(counters)+len(gauges) == 0 {
+		return nil
+	}
+
+	if err := c.send(ctx, counters, gauges); err != nil {
+		if errors.Is(err, ErrRejected) {
+			return fmt.Errorf("Flush: %w", err)
+		}
+
+		c.mu.Lock()
+		for name, delta := range counters {
+			c.counters[name] += delta
+		}
+		for name, value := range gauges {
+			if _, exist := c.gauges[name]; !exist {
+				c.gauges[name] = value
+			}
+		}
+		c.mu.Unlock()
+
+		return fmt.Errorf("Flush: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops the background flush and sends the pending metrics.
+// Updates made after Close are dropped.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.done)
+	c.wg.Wait()
+
+	if err := c.Flush(ctx); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, counters map[string]int64, gauges map[string]float64) error {
+	batch := make([]metrics.Metric, 0, len(counters)+len(gauges))
+
+	for name, delta := range counters {
+		m := metrics.Metric{ID: name, MType: metrics.CounterStrName}
+		m.SetInt64(delta)
+		batch = append(batch, m)
+	}
+
+	for name, value := range gauges {
+		m := metrics.Metric{ID: name, MType: metrics.GaugeStrName}
+		m.SetFloat64(value)
+		batch = append(batch, m)
+	}
+
+	if c.key != "" {
+		for i := range batch {
+			batch[i].Hash = batch[i].CalcHash(c.key)
+		}
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(batch); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	defer response.Body.Close()
+
+	if permanentFailure(response.StatusCode) {
+		return fmt.Errorf("send: %w: %s", ErrRejected, response.Status)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("send: %w: %s", ErrUnexpectedCode, response.Status)
+	}
+
+	return nil
+}
+
+// permanentFailure reports the 4xx statuses a retry of the same batch can't fix,
+// the timeout and rate limit ones are temporary.
+func permanentFailure(code int) bool {
+	if code == http.StatusRequestTimeout || code == http.StatusTooManyRequests {
+		return false
+	}
+
+	return code >= http.StatusBadRequest && code < http.StatusInternalServerError
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sreway/yametrics/internal/metrics"
+)
+
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	received [][]metrics.Metric
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var batch []metrics.Metric
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status == http.StatusOK {
+			s.received = append(s.received, batch)
+		}
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testServer) batches() [][]metrics.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+func findMetric(t *testing.T, batch []metrics.Metric, id string) metrics.Metric {
+	t.Helper()
+	for _, m := range batch {
+		if m.ID == id {
+			return m
+		}
+	}
+	t.Fatalf("metric %s not found", id)
+	return metrics.Metric{}
+}
+
+func TestClient_Flush(t *testing.T) {
+	server := newTestServer(t)
+	c, err := New(server.URL, WithKey("secret"), WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	c.Counter("Requests").Add(2)
+	c.Counter("Requests").Add(3)
+	c.Gauge("QueueLength").Set(1)
+	c.Gauge("QueueLength").Set(17.5)
+	require.NoError(t, c.Flush(context.Background()))
+
+	batches := server.batches()
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 2)
+
+	counter := findMetric(t, batches[0], "Requests")
+	assert.Equal(t, metrics.CounterStrName, counter.MType)
+	assert.Equal(t, int64(5), counter.Int64Value())
+	assert.Equal(t, counter.CalcHash("secret"), counter.Hash)
+
+	gauge := findMetric(t, batches[0], "QueueLength")
+	assert.Equal(t, metrics.GaugeStrName, gauge.MType)
+	assert.Equal(t, 17.5, gauge.Float64Value())
+	assert.Equal(t, gauge.CalcHash("secret"), gauge.Hash)
+
+	require.NoError(t, c.Flush(context.Background()))
+	assert.Len(t, server.batches(), 1, "nothing pending, nothing sent")
+}
+
+func TestClient_Flush_Retry(t *testing.T) {
+	server := newTestServer(t)
+	server.status = http.StatusInternalServerError
+	c, err := New(server.URL, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	c.Counter("Requests").Add(2)
+	c.Gauge("QueueLength").Set(1)
+	assert.ErrorIs(t, c.Flush(context.Background()), ErrUnexpectedCode)
+
+	c.Counter("Requests").Add(1)
+	c.Gauge("QueueLength").Set(2)
+	server.mu.Lock()
+	server.status = http.StatusOK
+	server.mu.Unlock()
+	require.NoError(t, c.Flush(context.Background()))
+
+	batches := server.batches()
+	require.Len(t, batches, 1)
+	counter := findMetric(t, batches[0], "Requests")
+	assert.Equal(t, int64(3), counter.Int64Value())
+	assert.Empty(t, counter.Hash)
+
+	gauge := findMetric(t, batches[0], "QueueLength")
+	assert.Equal(t, float64(2), gauge.Float64Value(), "a failed gauge value must not override a newer one")
+}
+
+func TestClient_Flush_Rejected(t *testing.T) {
+	server := newTestServer(t)
+	server.status = http.StatusBadRequest
+	c, err := New(server.URL, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	c.Counter("Invalid").Add(2)
+	assert.ErrorIs(t, c.Flush(context.Background()), ErrRejected)
+
+	server.mu.Lock()
+	server.status = http.StatusOK
+	server.mu.Unlock()
+	c.Counter("Requests").Add(1)
+	require.NoError(t, c.Flush(context.Background()))
+
+	batches := server.batches()
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 1, "the rejected batch is dropped")
+	counter := findMetric(t, batches[0], "Requests")
+	assert.Equal(t, int64(1), counter.Int64Value())
+
+	server.mu.Lock()
+	server.status = http.StatusTooManyRequests
+	server.mu.Unlock()
+	c.Counter("Requests").Add(1)
+	assert.ErrorIs(t, c.Flush(context.Background()), ErrUnexpectedCode, "rate limited batches are retried")
+}
+
+func TestClient_BatchSize(t *testing.T) {
+	server := newTestServer(t)
+	c, err := New(server.URL, WithFlushInterval(time.Hour), WithBatchSize(2))
+	require.NoError(t, err)
+
+	c.Gauge("a").Set(1)
+	c.Gauge("b").Set(2)
+
+	assert.Eventually(t, func() bool {
+		return len(server.batches()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClient_Close(t *testing.T) {
+	server := newTestServer(t)
+	c, err := New(server.URL, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	c.Counter("Requests").Add(1)
+	require.NoError(t, c.Close(context.Background()))
+	require.Len(t, server.batches(), 1)
+
+	c.Counter("Requests").Add(1)
+	assert.ErrorIs(t, c.Close(context.Background()), ErrClosed)
+	assert.Len(t, server.batches(), 1)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		opts    []Option
+	}{
+		{
+			name: "empty address",
+		},
+
+		{
+			name:    "invalid flush interval",
+			address: "127.0.0.1:8080",
+			opts:    []Option{WithFlushInterval(0)},
+		},
+
+		{
+			name:    "invalid batch size",
+			address: "127.0.0.1:8080",
+			opts:    []Option{WithBatchSize(-1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.address, tt.opts...)
+			assert.ErrorIs(t, err, ErrInvalidOption)
+		})
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE metrics;
\ No newline at end of file
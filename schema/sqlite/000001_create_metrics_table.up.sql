@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS metrics
+(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    delta INTEGER,
+    value REAL,
+    CONSTRAINT uniq_name_type UNIQUE (name,type)
+);